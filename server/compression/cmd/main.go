@@ -2,26 +2,38 @@ package main
 
 import (
 	"context"
+	"errors"
+	"ffmpeg/wrapper/compression/internal/capacity"
 	"ffmpeg/wrapper/compression/internal/controller/ffmpeg"
+	"ffmpeg/wrapper/compression/internal/dedup"
 	"ffmpeg/wrapper/compression/internal/repository"
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
 	"ffmpeg/wrapper/gen"
+	"ffmpeg/wrapper/internal/discoveryutil"
 	"ffmpeg/wrapper/pkg/discovery"
-	"ffmpeg/wrapper/pkg/discovery/consul"
 	"ffmpeg/wrapper/pkg/discovery/tracing"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	grpchandler "ffmpeg/wrapper/compression/internal/handler/grpc"
-	"ffmpeg/wrapper/internal/util"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/grpcutil"
+	"ffmpeg/wrapper/internal/lifecycle"
+	"ffmpeg/wrapper/internal/metrics"
+	"ffmpeg/wrapper/internal/scheduling"
+	"ffmpeg/wrapper/internal/user"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
 	"github.com/segmentio/kafka-go"
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
@@ -30,7 +42,6 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
-	"gopkg.in/yaml.v3"
 )
 
 const serviceName = "compression"
@@ -39,127 +50,266 @@ func main() {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	f, err := os.Open("../configs/default.yaml")
-	if err != nil {
-		logger.Fatal("Failed to open configuration", zap.Error(err))
-	}
-	var cfg configuration
-	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
-		logger.Fatal("Failed to parse configuration", zap.Error(err))
+	cfg, err := config.Load(serviceName, os.Args[1:], config.RequireStorage, config.RequireKafka, config.RequireMetrics, config.RequireDedup)
+	if errors.Is(err, config.ErrConfigPrinted) {
+		return
+	} else if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 	port := cfg.API.Port
 	logger.Info("Starting the compression service on port %v", zap.Int("port:", port))
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	lc := lifecycle.New(logger, cfg.Limits.ShutdownTimeout)
+	ctx := lc.Context()
 
 	tp, err := tracing.NewJaegerProvider(ctx, cfg.Jaeger.URL, serviceName)
 	if err != nil {
 		logger.Fatal("Failed to initialize Jaeger provider", zap.Error(err))
 	}
-	defer func() {
-		if err := tp.Shutdown(ctx); err != nil {
-			logger.Fatal("Failed to shut down Jaeger prodiver", zap.Error(err))
-		}
-	}()
+	lc.OnShutdown("tracing", 0, tp.Shutdown)
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(propagation.TraceContext{})
 
-	util.RecordMetrics()
-	http.Handle("/metrics", promhttp.Handler())
-	go func() {
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.Prometheus.MetricsPort), nil); err != nil {
-			logger.Fatal("Failed to start the metrics handler", zap.Error((err)))
-		}
-	}()
-
-	registry, err := consul.NewRegistry(cfg.ServiceDiscovery.Consul.Address)
+	registry, err := discoveryutil.NewRegistry(lc, cfg.ServiceDiscovery)
 	if err != nil {
 		panic(err)
 	}
 	apiInstanceID := discovery.GenerateInstanceID(serviceName + "-api")
 	metricsInstanceID := discovery.GenerateInstanceID(serviceName + "-metrics")
 
-	err = registry.Register(ctx, metricsInstanceID, serviceName+"-metrics", fmt.Sprintf("%s:%d", serviceName, cfg.Prometheus.MetricsPort))
+	registerOpts := cfg.ServiceDiscovery.RegisterOptions()
+	encoders, err := ffmpeg.Encoders(ctx)
+	if err != nil {
+		logger.Warn("Failed to detect ffmpeg encoders", zap.Error(err))
+	}
+	registerOpts = append(registerOpts, discovery.WithMeta(discovery.MetaEncoders, strings.Join(encoders, ",")))
+	for _, e := range encoders {
+		registerOpts = append(registerOpts, discovery.WithTags("encoder:"+e))
+	}
+
+	err = registry.Register(ctx, metricsInstanceID, serviceName+"-metrics", cfg.ServiceDiscovery.HostPort(serviceName, cfg.Prometheus.MetricsPort),
+		append(registerOpts, discovery.WithTags("metrics"))...)
 	if err != nil {
 		panic(err)
 	}
 
-	err = registry.Register(ctx, apiInstanceID, serviceName+"-api", fmt.Sprintf("%s:%d", serviceName, port))
+	err = registry.Register(ctx, apiInstanceID, serviceName+"-api", cfg.ServiceDiscovery.HostPort(serviceName, port),
+		append(registerOpts, discovery.WithGRPCHealthCheck(cfg.ServiceDiscovery.HealthCheckInterval))...)
 	if err != nil {
 		panic(err)
 	}
-	go func() {
+	lc.OnShutdown("service discovery", 0, func(ctx context.Context) error {
+		return errors.Join(
+			registry.Deregister(ctx, apiInstanceID, serviceName+"-api"),
+			registry.Deregister(ctx, metricsInstanceID, serviceName+"-metrics"),
+		)
+	})
+	lc.Go("health reporting", 0, func(ctx context.Context) error {
 		ticker := time.NewTicker(2 * time.Second)
 		defer ticker.Stop()
-		for range ticker.C {
-			if err := registry.ReportHealthyState(apiInstanceID, "api"); err != nil {
-				logger.Error("Failed to report healthy state", zap.Error(err))
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
 			}
 			if err := registry.ReportHealthyState(metricsInstanceID, "metrics"); err != nil {
 				logger.Error("Failed to report healthy state", zap.Error(err))
 			}
 		}
+	})
 
-	}()
-	defer func() {
-		if err := registry.Deregister(ctx, apiInstanceID, serviceName+"-api"); err != nil {
-			logger.Error("Failed to deregister API service", zap.Error(err))
-		}
-		if err := registry.Deregister(ctx, metricsInstanceID, serviceName+"-metrics"); err != nil {
-			logger.Error("Failed to deregister Metrics service", zap.Error(err))
-		}
-	}()
-	var accountId = os.Getenv("accountId")
-	var accessKeyId = os.Getenv("accessKeyId")
-	var accessKey = os.Getenv("secretKey")
-
-	AWScfg, err := config.LoadDefaultConfig(ctx,
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyId, accessKey, "")),
-		config.WithRegion("auto"),
+	AWScfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.Storage.AccessKeyID, cfg.Storage.SecretKey, "")),
+		awsconfig.WithRegion(cfg.Storage.Region),
 	)
 	if err != nil {
 		logger.Error("Failed to load AWS s3 config", zap.Error(err))
 	}
 
 	s3Client := s3.NewFromConfig(AWScfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountId))
+		o.BaseEndpoint = aws.String(cfg.Storage.BaseEndpoint())
 		o.UsePathStyle = true
+		o.APIOptions = append(o.APIOptions, metrics.AddS3Metrics)
 	})
 	presignClient := s3.NewPresignClient(s3Client)
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     []string{os.Getenv("kafkaBroker")},
-		Topic:       "compression-job",
-		GroupID:     "compression-worker",
-		MaxBytes:    10e6,
-		StartOffset: kafka.LastOffset,
-	})
+	// Every priority has a topic of its own, read by the
+	// same consumer group.
+	jobReaders := map[compressionmodel.Priority]*kafka.Reader{}
+	var readers []*kafka.Reader
+	for _, p := range compressionmodel.Priorities {
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     cfg.Kafka.Brokers,
+			Topic:       scheduling.Topic(cfg.Kafka.Topics, p),
+			GroupID:     cfg.Kafka.ConsumerGroup,
+			MaxBytes:    10e6,
+			StartOffset: kafka.LastOffset,
+		})
+		jobReaders[p] = reader
+		readers = append(readers, reader)
+	}
 	writer := &kafka.Writer{
-		Addr:        kafka.TCP(os.Getenv("kafkaBroker")),
-		Topic:       "compression-job",
+		Addr:        kafka.TCP(cfg.Kafka.Brokers...),
+		Topic:       cfg.Kafka.Topics.Results,
 		Balancer:    &kafka.LeastBytes{},
 		Logger:      kafka.LoggerFunc(logf),
 		ErrorLogger: kafka.LoggerFunc(logf),
 	}
+	lc.OnShutdown("kafka writer", 0, func(context.Context) error { return writer.Close() })
+	// The cancellations and segment results topics are read
+	// by every worker outside any consumer group, from their
+	// single partition.
+	kafkaClient := &kafka.Client{Addr: kafka.TCP(cfg.Kafka.Brokers...), Timeout: 10 * time.Second}
+	for _, topic := range []string{cfg.Kafka.Topics.Cancellations, cfg.Kafka.Topics.SegmentResults} {
+		if err := requireSinglePartition(ctx, kafkaClient, topic); err != nil {
+			logger.Fatal("Invalid Kafka topic", zap.Error(err))
+		}
+	}
+	cancelReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:  cfg.Kafka.Brokers,
+		Topic:    cfg.Kafka.Topics.Cancellations,
+		MaxBytes: 10e6,
+	})
+	lc.OnShutdown("kafka cancellation reader", 0, func(context.Context) error { return cancelReader.Close() })
+
+	// Segments are shared by the consumer group, while their
+	// results are read by every worker.
+	segments := ffmpeg.SegmentClients{
+		Reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:  cfg.Kafka.Brokers,
+			Topic:    cfg.Kafka.Topics.Segments,
+			GroupID:  cfg.Kafka.ConsumerGroup,
+			MaxBytes: 10e6,
+		}),
+		ResultReader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:  cfg.Kafka.Brokers,
+			Topic:    cfg.Kafka.Topics.SegmentResults,
+			MaxBytes: 10e6,
+		}),
+		Writer: &kafka.Writer{
+			Addr:                   kafka.TCP(cfg.Kafka.Brokers...),
+			Balancer:               &kafka.LeastBytes{},
+			AllowAutoTopicCreation: true,
+			Logger:                 kafka.LoggerFunc(logf),
+			ErrorLogger:            kafka.LoggerFunc(logf),
+		},
+	}
+	lc.OnShutdown("kafka segment clients", 0, func(context.Context) error {
+		return errors.Join(segments.Reader.Close(), segments.ResultReader.Close(), segments.Writer.Close())
+	})
+
+	var dedupStore dedup.Store
+	if cfg.Dedup.Enabled && cfg.Redis.Address != "" {
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:         cfg.Redis.Address,
+			Password:     cfg.Redis.Password,
+			DB:           cfg.Redis.DB,
+			DialTimeout:  cfg.Redis.Timeout,
+			ReadTimeout:  cfg.Redis.Timeout,
+			WriteTimeout: cfg.Redis.Timeout,
+		})
+		lc.OnShutdown("redis client", 0, func(context.Context) error { return redisClient.Close() })
+		dedupStore = dedup.NewRedisStore(redisClient, cfg.Redis.Timeout)
+	} else {
+		if cfg.Dedup.Enabled {
+			logger.Warn("No Redis configured, compressed outputs are only reused by this worker")
+		}
+		dedupStore = dedup.NewMemoryStore()
+	}
+	cache := dedup.New(cfg.Dedup, dedupStore, cfg.Encoding)
 
 	repo := repository.New(presignClient, s3Client)
-	ctrl := ffmpeg.New(reader, writer, repo)
+	ctrl := ffmpeg.New(jobReaders, writer, cancelReader, segments, repo, cache, cfg)
+
+	// Running jobs and segments are interrupted after the
+	// drain timeout, and then publish their result before
+	// the Kafka clients are closed.
+	drainTimeout := cfg.Encoding.Limits.DrainTimeout + cfg.Limits.ShutdownTimeout
+	lc.Go("kafka consumer", drainTimeout, func(ctx context.Context) error {
+		ctrl.ConsumeCompressionEvent(ctx)
+		return nil
+	})
+	lc.Go("kafka cancellation consumer", 0, ctrl.ConsumeCancellations)
+	lc.Go("kafka segment consumer", drainTimeout, ctrl.ConsumeSegments)
+	lc.Go("kafka segment result consumer", 0, ctrl.ConsumeSegmentResults)
 
-	go ctrl.ConsumeCompressionEvent(ctx)
+	prometheus.MustRegister(metrics.NewKafkaReaderCollector(cfg.Kafka.ConsumerGroup, slices.Concat(readers, []*kafka.Reader{segments.Reader})...))
+
+	// The backlog of the whole fleet and the load of this
+	// worker are served next to the metrics, for autoscalers.
+	var jobTopics []string
+	for _, reader := range readers {
+		jobTopics = append(jobTopics, reader.Config().Topic)
+	}
+	// The segments of chunked jobs are work of the group
+	// as well.
+	jobTopics = append(jobTopics, cfg.Kafka.Topics.Segments)
+	monitor := capacity.NewMonitor(kafkaClient, cfg.Kafka.ConsumerGroup, jobTopics, ctrl.Load)
+	lc.Go("capacity monitor", 0, monitor.Run)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/capacity", monitor)
+	metricsSrv := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Prometheus.MetricsPort), Handler: mux}
+	lc.Serve("metrics server", metricsSrv.ListenAndServe, lifecycle.HTTPStopper(metricsSrv))
 
 	h := grpchandler.New(ctrl)
-	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	addr := fmt.Sprintf("%s:%d", cfg.API.Host, port)
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		logger.Fatal("Failed to listen", zap.Error(err))
 	}
-	srv := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	srv := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor(), user.UnaryServerInterceptor()),
+	)
 	reflection.Register(srv)
 	gen.RegisterCompressionServiceServer(srv, h)
-	if err := srv.Serve(lis); err != nil {
-		panic(err)
+	healthSrv := grpcutil.NewHealthServer(srv)
+	lc.Serve("grpc server", func() error { return srv.Serve(lis) }, lifecycle.GRPCStopper(srv))
+	lc.OnShutdown("grpc health", 0, func(context.Context) error {
+		healthSrv.Shutdown()
+		return nil
+	})
+
+	if err := lc.Wait(); err != nil {
+		logger.Fatal("Compression service stopped", zap.Error(err))
+	}
+	logger.Info("Compression service stopped")
+}
+
+// requireSinglePartition checks that topic has a single
+// partition, as readers outside a consumer group only read
+// partition 0. A missing topic is created with one.
+func requireSinglePartition(ctx context.Context, client *kafka.Client, topic string) error {
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return fmt.Errorf("failed to read the partitions of %s: %w", topic, err)
+	}
+	if len(meta.Topics) != 1 {
+		return fmt.Errorf("no metadata for topic %s", topic)
+	}
+	t := meta.Topics[0]
+	if errors.Is(t.Error, kafka.UnknownTopicOrPartition) {
+		resp, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+			Topics: []kafka.TopicConfig{{Topic: topic, NumPartitions: 1, ReplicationFactor: -1}},
+		})
+		if err == nil {
+			err = resp.Errors[topic]
+		}
+		// Another worker may have created it first, with a
+		// single partition too.
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			return fmt.Errorf("failed to create topic %s: %w", topic, err)
+		}
+		return nil
+	} else if t.Error != nil {
+		return fmt.Errorf("failed to read the partitions of %s: %w", topic, t.Error)
+	}
+	if len(t.Partitions) != 1 {
+		return fmt.Errorf("topic %s has %d partitions, it must have a single one", topic, len(t.Partitions))
 	}
+	return nil
 }
 
 func logf(msg string, a ...interface{}) {
@@ -0,0 +1,204 @@
+// Package capacity reports the backlog and the free
+// capacity of the compression workers, as the signal to
+// scale them on.
+package capacity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// refreshInterval is how often the lag of the consumer
+// group is read from Kafka.
+const refreshInterval = 10 * time.Second
+
+// Load is the work of a single worker.
+type Load struct {
+	// Capacity is the number of jobs the worker runs at
+	// once.
+	Capacity int
+	InFlight int
+	// Queued is the number of jobs the worker fetched and
+	// did not start yet.
+	Queued int
+	// QueuedVideoSeconds is the duration of the videos of
+	// the queued jobs.
+	QueuedVideoSeconds float64
+	// EncodeFactor is the time a job took per second of
+	// video, measured by the worker.
+	EncodeFactor float64
+}
+
+// Report is the body of the capacity endpoint. The lag is
+// the one of the whole consumer group, the other fields
+// describe the answering worker.
+type Report struct {
+	ConsumerGroup string `json:"consumer_group"`
+	// Lag is the number of messages of the job topics the
+	// group did not commit yet: the jobs queued in Kafka,
+	// fetched by a worker, or running.
+	Lag          int64            `json:"lag"`
+	LagByTopic   map[string]int64 `json:"lag_by_topic"`
+	LagUpdatedAt time.Time        `json:"lag_updated_at"`
+	Capacity     int              `json:"capacity"`
+	InFlight     int              `json:"in_flight"`
+	Queued       int              `json:"queued"`
+	// QueuedEncodeSeconds estimates the encode time of the
+	// queued jobs from the durations of their videos.
+	QueuedEncodeSeconds float64 `json:"queued_encode_seconds"`
+	// IdleWorkers is 1 if the worker runs no job.
+	IdleWorkers int `json:"idle_workers"`
+}
+
+// Monitor reads the lag of the consumer group, and exposes
+// it with the load of the worker as metrics and on the
+// capacity endpoint.
+type Monitor struct {
+	client *kafka.Client
+	group  string
+	topics []string
+	load   func() Load
+
+	mu         sync.Mutex
+	lag        map[string]int64
+	lagUpdated time.Time
+}
+
+// NewMonitor creates a monitor of the lag of group on the
+// given topics, and of the load returned by load.
+func NewMonitor(client *kafka.Client, group string, topics []string, load func() Load) *Monitor {
+	return &Monitor{client: client, group: group, topics: topics, load: load, lag: map[string]int64{}}
+}
+
+// Run refreshes the lag and the metrics until ctx is done.
+func (m *Monitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+	for {
+		lag, err := m.fetchLag(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Failed to read the consumer group lag: %v", err)
+		} else {
+			m.mu.Lock()
+			m.lag = lag
+			m.lagUpdated = time.Now()
+			m.mu.Unlock()
+		}
+		m.updateMetrics(m.Report())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Report returns the last lag read and the current load.
+func (m *Monitor) Report() Report {
+	load := m.load()
+	r := Report{
+		ConsumerGroup:       m.group,
+		LagByTopic:          map[string]int64{},
+		Capacity:            load.Capacity,
+		InFlight:            load.InFlight,
+		Queued:              load.Queued,
+		QueuedEncodeSeconds: load.QueuedVideoSeconds * load.EncodeFactor,
+	}
+	if load.InFlight == 0 {
+		r.IdleWorkers = 1
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for topic, lag := range m.lag {
+		r.LagByTopic[topic] = lag
+		r.Lag += lag
+	}
+	r.LagUpdatedAt = m.lagUpdated
+	return r
+}
+
+// ServeHTTP answers the report as JSON.
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.Report()); err != nil {
+		log.Printf("Failed to write the capacity report: %v", err)
+	}
+}
+
+func (m *Monitor) updateMetrics(r Report) {
+	for topic, lag := range r.LagByTopic {
+		groupLag.WithLabelValues(topic).Set(float64(lag))
+	}
+	inFlight.Set(float64(r.InFlight))
+	queuedEncodeSeconds.Set(r.QueuedEncodeSeconds)
+	idleWorkers.Set(float64(r.IdleWorkers))
+}
+
+// fetchLag returns the lag of the group by topic. Topics
+// that do not exist yet have no lag. Partitions the group
+// never committed count from their first offset.
+func (m *Monitor) fetchLag(ctx context.Context) (map[string]int64, error) {
+	meta, err := m.client.Metadata(ctx, &kafka.MetadataRequest{Topics: m.topics})
+	if err != nil {
+		return nil, err
+	}
+	partitions := map[string][]int{}
+	requests := map[string][]kafka.OffsetRequest{}
+	for _, t := range meta.Topics {
+		if t.Error != nil {
+			continue
+		}
+		for _, p := range t.Partitions {
+			partitions[t.Name] = append(partitions[t.Name], p.ID)
+			requests[t.Name] = append(requests[t.Name], kafka.FirstOffsetOf(p.ID), kafka.LastOffsetOf(p.ID))
+		}
+	}
+	lag := map[string]int64{}
+	for _, topic := range m.topics {
+		lag[topic] = 0
+	}
+	if len(partitions) == 0 {
+		return lag, nil
+	}
+
+	committed, err := m.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: m.group, Topics: partitions})
+	if err != nil {
+		return nil, err
+	}
+	if committed.Error != nil {
+		return nil, committed.Error
+	}
+	offsets, err := m.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: requests})
+	if err != nil {
+		return nil, err
+	}
+	for topic, ps := range offsets.Topics {
+		commits := map[int]int64{}
+		for _, p := range committed.Topics[topic] {
+			if p.Error == nil {
+				commits[p.Partition] = p.CommittedOffset
+			}
+		}
+		for _, p := range ps {
+			if p.Error != nil {
+				return nil, fmt.Errorf("failed to list the offsets of %s/%d: %w", topic, p.Partition, p.Error)
+			}
+			start, ok := commits[p.Partition]
+			if !ok || start < 0 {
+				start = p.FirstOffset
+			}
+			lag[topic] += max(p.LastOffset-start, 0)
+		}
+	}
+	return lag, nil
+}
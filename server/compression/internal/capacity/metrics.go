@@ -0,0 +1,27 @@
+package capacity
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// groupLag is the lag of the whole consumer group, so
+	// every worker reports the same value.
+	groupLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "compression_consumer_group_lag",
+		Help: "Number of job messages the compression consumer group did not commit, by topic.",
+	}, []string{"topic"})
+	inFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "compression_jobs_in_flight",
+		Help: "Number of compression jobs the worker runs.",
+	})
+	queuedEncodeSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "compression_queued_encode_seconds",
+		Help: "Estimated encode time of the jobs the worker fetched and did not start.",
+	})
+	idleWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "compression_idle_workers",
+		Help: "1 if the worker runs no compression job.",
+	})
+)
@@ -0,0 +1,160 @@
+package ffmpeg
+
+import (
+	"context"
+	compressionModel "ffmpeg/wrapper/compression/pkg/model"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// animatedMargin is the share of the target an attempt at
+// an animated image aims for once the previous one did not
+// fit, as the size of images does not shrink in proportion
+// to their settings.
+const animatedMargin = 0.9
+
+// animatedSettings are the settings of an attempt at an
+// animated image. Colors apply to GIF images and quality
+// to WebP images.
+type animatedSettings struct {
+	fps     int
+	width   int
+	colors  int
+	quality int
+}
+
+func (s animatedSettings) String() string {
+	return fmt.Sprintf("%d fps, %d pixels wide, %d colors, quality %d", s.fps, s.width, s.colors, s.quality)
+}
+
+// encodeAnimated encodes the first video stream of input
+// into an animated GIF or WebP image, from the extension of
+// output. Each attempt that exceeds the target is followed
+// by one at lower settings, down to the minimums of
+// encoding.animated. Images are always rotated upright, as
+// they carry no rotation.
+func (c *Controller) encodeAnimated(ctx context.Context, cg *cgroup, timeout time.Duration, input string, duration float64, output string) error {
+	a := c.encoding.Animated
+	if duration > a.MaxDuration.Seconds() {
+		return failure(compressionModel.FailureReasonTooLarge,
+			"the video lasts %.0fs, longer than the %s encoded into images", duration, a.MaxDuration)
+	}
+	format := outputFormat(output)
+	s := animatedSettings{fps: a.FPS, width: a.Width, colors: a.Colors, quality: a.Quality}
+	for attempt := 1; ; attempt++ {
+		var err error
+		if format == "gif" {
+			err = c.encodeGIF(ctx, cg, timeout, input, s, output)
+		} else {
+			err = c.encodeWebP(ctx, cg, timeout, input, s, output)
+		}
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(output)
+		if err != nil {
+			return failure(compressionModel.FailureReasonEncode, "failed to stat the output: %w", err)
+		}
+		if fi.Size() <= c.targetBytes() {
+			animatedAttempts.WithLabelValues(format).Observe(float64(attempt))
+			return nil
+		}
+		next := c.lowerAnimated(s, format, float64(c.targetBytes())/float64(fi.Size()))
+		if attempt >= a.MaxAttempts || next == s {
+			return failure(compressionModel.FailureReasonTooLarge,
+				"the %s image takes %d bytes at %s, more than the target of %d", format, fi.Size(), s, c.targetBytes())
+		}
+		log.Printf("Encoding %s again at %s, as it took %d bytes at %s", output, next, fi.Size(), s)
+		s = next
+	}
+}
+
+// lowerAnimated returns the settings of the attempt after
+// one at s that produced an image ratio times the target.
+// The reduction is shared by the frame rate, the area and
+// the colors or quality, within the minimums.
+func (c *Controller) lowerAnimated(s animatedSettings, format string, ratio float64) animatedSettings {
+	a := c.encoding.Animated
+	k := math.Cbrt(ratio * animatedMargin)
+	lower := func(v int, k float64, minimum int) int {
+		return max(minimum, min(v, int(float64(v)*k)))
+	}
+	s.fps = lower(s.fps, k, a.MinFPS)
+	s.width = lower(s.width, math.Sqrt(k), a.MinWidth)
+	if format == "gif" {
+		s.colors = lower(s.colors, k, a.MinColors)
+	} else {
+		s.quality = lower(s.quality, k, a.MinQuality)
+	}
+	return s
+}
+
+// animatedFilters returns the filters resampling the video
+// at the frame rate and at most the width of s.
+func animatedFilters(s animatedSettings) string {
+	return fmt.Sprintf("fps=%d,scale='min(%d,iw)':-1:flags=lanczos", s.fps, s.width)
+}
+
+// encodeGIF encodes input into a GIF image in two steps:
+// palettegen computes the palette of the whole video, then
+// paletteuse maps the frames to it.
+func (c *Controller) encodeGIF(ctx context.Context, cg *cgroup, timeout time.Duration, input string, s animatedSettings, output string) error {
+	palette := output + ".palette.png"
+	defer os.Remove(palette)
+	filters := animatedFilters(s)
+
+	cmd := c.ffmpegCommand(ctx, cg,
+		"-y",
+		"-i", input,
+		"-map", "0:v:0",
+		"-vf", filters+",palettegen=max_colors="+strconv.Itoa(s.colors)+":stats_mode=diff",
+		palette,
+	)
+	start := time.Now()
+	if err := c.runTraced(ctx, "ffmpeg/PaletteGen", cmd); err != nil {
+		return encodeFailure(ctx, cg, "palettegen", timeout, err)
+	}
+	encodeSeconds.WithLabelValues("palettegen").Observe(time.Since(start).Seconds())
+
+	args := slices.Concat([]string{
+		"-y",
+		"-i", input,
+		"-i", palette,
+		"-lavfi", "[0:v:0]" + filters + "[x];[x][1:v]paletteuse=dither=bayer:bayer_scale=5:diff_mode=rectangle",
+		"-an",
+		"-loop", "0",
+	}, c.metadataArgs(), []string{"-f", "gif", output})
+	start = time.Now()
+	if err := c.runTraced(ctx, "ffmpeg/PaletteUse", c.ffmpegCommand(ctx, cg, args...)); err != nil {
+		return encodeFailure(ctx, cg, "paletteuse", timeout, err)
+	}
+	encodeSeconds.WithLabelValues("paletteuse").Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// encodeWebP encodes input into a lossy animated WebP image
+// with libwebp_anim.
+func (c *Controller) encodeWebP(ctx context.Context, cg *cgroup, timeout time.Duration, input string, s animatedSettings, output string) error {
+	args := slices.Concat([]string{
+		"-y",
+		"-i", input,
+		"-map", "0:v:0",
+		"-vf", animatedFilters(s),
+		"-c:v", "libwebp_anim",
+		"-lossless", "0",
+		"-quality", strconv.Itoa(s.quality),
+		"-an",
+		"-loop", "0",
+	}, c.metadataArgs(), []string{"-f", "webp", output})
+	start := time.Now()
+	if err := c.runTraced(ctx, "ffmpeg/WebP", c.ffmpegCommand(ctx, cg, args...)); err != nil {
+		return encodeFailure(ctx, cg, "webp", timeout, err)
+	}
+	encodeSeconds.WithLabelValues("webp").Observe(time.Since(start).Seconds())
+	return nil
+}
@@ -0,0 +1,169 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	compressionModel "ffmpeg/wrapper/compression/pkg/model"
+	"log"
+	"sync"
+	"time"
+)
+
+// cancelRetention is how long a cancellation is kept for
+// jobs that have not started yet.
+const cancelRetention = 24 * time.Hour
+
+// errCancelled is the cause of the context of a cancelled
+// job.
+var errCancelled = errors.New("job cancelled")
+
+// errInterrupted is the cause of the context of a job
+// still running once the worker stopped draining.
+var errInterrupted = errors.New("worker stopped")
+
+// cancellations tracks the running jobs of a worker and the
+// jobs cancelled before they started. A chunked job may run
+// on a worker alongside segments of its own.
+type cancellations struct {
+	mu        sync.Mutex
+	running   map[int64]map[int]context.CancelCauseFunc
+	nextID    int
+	cancelled map[int64]time.Time
+}
+
+func newCancellations() *cancellations {
+	return &cancellations{
+		running:   map[int64]map[int]context.CancelCauseFunc{},
+		cancelled: map[int64]time.Time{},
+	}
+}
+
+// start returns the context of a job, cancelled with
+// errCancelled once the job is, and a function to call once
+// the job ended. The context of a job cancelled before it
+// started is already done.
+func (c *cancellations) start(ctx context.Context, jobID int64) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cancelled[jobID]; ok {
+		cancel(errCancelled)
+	}
+	if c.running[jobID] == nil {
+		c.running[jobID] = map[int]context.CancelCauseFunc{}
+	}
+	id := c.nextID
+	c.nextID++
+	c.running[jobID][id] = cancel
+	return ctx, func() {
+		c.mu.Lock()
+		delete(c.running[jobID], id)
+		if len(c.running[jobID]) == 0 {
+			delete(c.running, jobID)
+		}
+		c.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancel stops the job if it is running, and otherwise
+// keeps the cancellation for when it starts. It reports
+// whether the job was running.
+func (c *cancellations) cancel(jobID int64, requestedAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, at := range c.cancelled {
+		if now.Sub(at) > cancelRetention {
+			delete(c.cancelled, id)
+		}
+	}
+	c.cancelled[jobID] = requestedAt
+	for _, cancel := range c.running[jobID] {
+		cancel(errCancelled)
+	}
+	return len(c.running[jobID]) > 0
+}
+
+// interrupt stops every running job with errInterrupted.
+func (c *cancellations) interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, running := range c.running {
+		for _, cancel := range running {
+			cancel(errInterrupted)
+		}
+	}
+}
+
+// isInterrupted reports whether ctx is the context of a job
+// interrupted by the shutdown of the worker.
+func isInterrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errInterrupted)
+}
+
+// drain interrupts the running jobs once
+// encoding.limits.drainTimeout passed after ctx is done,
+// so that they publish their failure and commit before the
+// Kafka clients are closed. It returns a function stopping
+// it.
+func (c *Controller) drain(ctx context.Context) func() {
+	var mu sync.Mutex
+	var timer *time.Timer
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		timer = time.AfterFunc(c.encoding.Limits.DrainTimeout, c.cancels.interrupt)
+	})
+	return func() {
+		stop()
+		mu.Lock()
+		defer mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// isCancelled reports whether ctx is the context of a
+// cancelled job.
+func isCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errCancelled)
+}
+
+// ConsumeCancellations stops the jobs cancelled on the
+// cancellations topic until ctx is done. Cancellations
+// requested up to cancelRetention ago are read again at
+// startup, for jobs still queued.
+func (c *Controller) ConsumeCancellations(ctx context.Context) error {
+	if err := c.cancelReader.SetOffsetAt(ctx, time.Now().Add(-cancelRetention)); err != nil && ctx.Err() == nil {
+		log.Printf("Failed to seek cancellations, reading them all: %v", err)
+	}
+	for {
+		m, err := c.cancelReader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("kafka reading error: %v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		var event compressionModel.CancellationEvent
+		if err := json.Unmarshal(m.Value, &event); err != nil || event.JobID == 0 {
+			log.Printf("Skipping invalid cancellation at offset %d", m.Offset)
+			continue
+		}
+		if time.Since(event.RequestedAt) > cancelRetention {
+			continue
+		}
+		if c.cancels.cancel(event.JobID, event.RequestedAt) {
+			log.Printf("Cancelling running job %d", event.JobID)
+		}
+	}
+}
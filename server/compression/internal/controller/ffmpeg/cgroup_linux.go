@@ -0,0 +1,123 @@
+//go:build linux
+
+package ffmpeg
+
+import (
+	"bufio"
+	"errors"
+	"ffmpeg/wrapper/internal/config"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// cpuPeriod is the cpu.max period, in microseconds.
+const cpuPeriod = 100000
+
+// cgroup is the cgroup v2 the ffmpeg processes of a job run
+// in. A nil cgroup leaves the processes unconstrained.
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// newCgroup creates the cgroup of a job under
+// limits.cgroupParent, or returns nil if it is not set.
+func newCgroup(limits config.EncodeLimits, name string) (*cgroup, error) {
+	if limits.CgroupParent == "" {
+		return nil, nil
+	}
+	path := filepath.Join(limits.CgroupParent, name)
+	if err := os.Mkdir(path, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	cg := &cgroup{path: path}
+	if err := cg.configure(limits); err != nil {
+		cg.remove()
+		return nil, err
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		cg.remove()
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	cg.dir = dir
+	return cg, nil
+}
+
+func (cg *cgroup) configure(limits config.EncodeLimits) error {
+	if limits.MemoryMax > 0 {
+		if err := cg.write("memory.max", strconv.FormatInt(limits.MemoryMax, 10)); err != nil {
+			return err
+		}
+		// Without swap accounting the file does not exist,
+		// and there is no swap to limit.
+		if err := cg.write("memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if limits.CPUMax > 0 {
+		quota := int64(limits.CPUMax * cpuPeriod)
+		if err := cg.write("cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cg *cgroup) write(file string, value string) error {
+	if err := os.WriteFile(filepath.Join(cg.path, file), []byte(value), 0); err != nil {
+		return fmt.Errorf("failed to set %s: %w", file, err)
+	}
+	return nil
+}
+
+// apply starts cmd inside the cgroup.
+func (cg *cgroup) apply(cmd *exec.Cmd) {
+	if cg == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+}
+
+// oomKilled reports whether a process of the cgroup was
+// killed for exceeding memory.max.
+func (cg *cgroup) oomKilled() bool {
+	if cg == nil {
+		return false
+	}
+	f, err := os.Open(filepath.Join(cg.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if n, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+			return n != "0"
+		}
+	}
+	return false
+}
+
+// remove deletes the cgroup, once its processes exited.
+func (cg *cgroup) remove() {
+	if cg == nil {
+		return
+	}
+	if cg.dir != nil {
+		cg.dir.Close()
+	}
+	if err := os.Remove(cg.path); err != nil {
+		log.Printf("Failed to remove cgroup %s: %v", cg.path, err)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package ffmpeg
+
+import (
+	"errors"
+	"ffmpeg/wrapper/internal/config"
+	"os/exec"
+)
+
+// cgroup is not supported outside Linux. It is always nil.
+type cgroup struct{}
+
+// newCgroup fails if limits.cgroupParent is set, as cgroups
+// only exist on Linux.
+func newCgroup(limits config.EncodeLimits, name string) (*cgroup, error) {
+	if limits.CgroupParent != "" {
+		return nil, errors.New("cgroup limits are only supported on Linux")
+	}
+	return nil, nil
+}
+
+func (cg *cgroup) apply(cmd *exec.Cmd) {}
+
+func (cg *cgroup) oomKilled() bool { return false }
+
+func (cg *cgroup) remove() {}
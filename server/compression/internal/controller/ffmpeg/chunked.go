@@ -0,0 +1,500 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	compressionModel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/pkg/discovery/tracing"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/vansante/go-ffprobe.v2"
+)
+
+// SegmentClients are the Kafka clients chunked jobs are
+// dispatched and collected with.
+type SegmentClients struct {
+	// Reader reads the segments topic in the consumer group
+	// of the workers.
+	Reader *kafka.Reader
+	// ResultReader reads the segment results topic outside
+	// any consumer group, as each worker waits for the
+	// segments of its own jobs.
+	ResultReader *kafka.Reader
+	// Writer has no topic, as it publishes to both.
+	Writer *kafka.Writer
+}
+
+// segmentWaiters routes segment results to the chunked jobs
+// waiting for them, by segment prefix.
+type segmentWaiters struct {
+	mu      sync.Mutex
+	waiting map[string]chan compressionModel.SegmentResultEvent
+}
+
+func newSegmentWaiters() *segmentWaiters {
+	return &segmentWaiters{waiting: map[string]chan compressionModel.SegmentResultEvent{}}
+}
+
+// wait returns the channel the results of the n segments
+// stored under prefix are sent to, until stop is called.
+func (w *segmentWaiters) wait(prefix string, n int) <-chan compressionModel.SegmentResultEvent {
+	// Results read twice are dropped once the channel is
+	// full.
+	results := make(chan compressionModel.SegmentResultEvent, 2*n)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.waiting[prefix] = results
+	return results
+}
+
+func (w *segmentWaiters) stop(prefix string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.waiting, prefix)
+}
+
+func (w *segmentWaiters) deliver(r compressionModel.SegmentResultEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case w.waiting[r.Prefix] <- r:
+	default:
+	}
+}
+
+// chunkable reports whether a video of the given duration
+// in seconds is split into segments.
+func (c *Controller) chunkable(duration float64) bool {
+	ch := c.encoding.Chunking
+	return ch.Enabled && duration >= ch.MinDuration.Seconds()
+}
+
+type sourceSegment struct {
+	file     string
+	duration float64
+}
+
+// encodeChunked splits the video at keyframes, has the
+// workers encode the segments, and joins them to output.
+// Each segment gets the share of the video target of its
+// duration and the rotation of the video, and the audio is
+// encoded here as a whole. It returns errUnsuited if the
+// video has a single segment, or if the output exceeds the
+// target.
+func (c *Controller) encodeChunked(ctx context.Context, cg *cgroup, timeout time.Duration, jobID int64, filePath string, duration float64, rotation int, output string) error {
+	dir, err := os.MkdirTemp(c.encoding.WorkDir, "chunks-")
+	if err != nil {
+		return failure(compressionModel.FailureReasonEncode, "failed to create the segment directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	segments, err := c.split(ctx, cg, timeout, filePath, dir)
+	if err != nil {
+		return err
+	}
+	if len(segments) < 2 {
+		return fmt.Errorf("%w: the video has a single segment", errUnsuited)
+	}
+	var total float64
+	for _, seg := range segments {
+		total += seg.duration
+	}
+
+	prefix := fmt.Sprintf("segments/%d-%s", jobID, RandStringBytes(8))
+	events := make([]compressionModel.SegmentEvent, len(segments))
+	for i, seg := range segments {
+		events[i] = compressionModel.SegmentEvent{
+			JobID:         jobID,
+			Prefix:        prefix,
+			Index:         i,
+			Duration:      seg.duration,
+			TargetVideoMB: c.encoding.TargetVideoMB * seg.duration / total,
+			Rotation:      rotation,
+		}
+	}
+	// The segments are deleted even if the job was cancelled
+	// or timed out.
+	defer c.removeSegments(context.WithoutCancel(ctx), events)
+	for i, seg := range segments {
+		if err := c.repo.UploadObject(ctx, c.bucket, events[i].SourceKey(), seg.file); err != nil {
+			return failure(compressionModel.FailureReasonUpload, "error uploading segment %d: %w", i, err)
+		}
+		os.Remove(seg.file)
+	}
+
+	results := c.segmentWaiters.wait(prefix, len(events))
+	defer c.segmentWaiters.stop(prefix)
+	if err := c.publishSegments(ctx, events); err != nil {
+		return failure(compressionModel.FailureReasonEncode, "failed to dispatch the segments: %w", err)
+	}
+	log.Printf("Dispatched job %d as %d segments", jobID, len(events))
+
+	// The audio is encoded while the workers, this one
+	// included, encode the segments.
+	audioFile, err := c.encodeAudio(ctx, cg, timeout, filePath, duration, dir)
+	if err != nil {
+		return err
+	}
+
+	encoded := make([]string, len(events))
+	for remaining := len(events); remaining > 0; {
+		var r compressionModel.SegmentResultEvent
+		select {
+		case <-ctx.Done():
+			return encodeFailure(ctx, cg, "segments", timeout, context.Cause(ctx))
+		case r = <-results:
+		}
+		if r.Index < 0 || r.Index >= len(events) || encoded[r.Index] != "" {
+			continue
+		}
+		if r.Reason != "" {
+			return failure(r.Reason, "segment %d of job %d failed", r.Index, jobID)
+		}
+		file := filepath.Join(dir, fmt.Sprintf("encoded_%04d.mp4", r.Index))
+		if _, err := c.repo.DownloadObject(ctx, c.bucket, events[r.Index].OutputKey(), file); err != nil {
+			return failure(compressionModel.FailureReasonDownload, "error downloading segment %d: %w", r.Index, err)
+		}
+		encoded[r.Index] = file
+		remaining--
+	}
+
+	if err := c.concat(ctx, cg, timeout, dir, encoded, audioFile, filePath, rotation, output); err != nil {
+		return err
+	}
+	return c.checkTarget(output)
+}
+
+// split copies the video stream of filePath into segments
+// in dir, cut at the first keyframe after every
+// encoding.chunking.segmentDuration.
+func (c *Controller) split(ctx context.Context, cg *cgroup, timeout time.Duration, filePath string, dir string) ([]sourceSegment, error) {
+	list := filepath.Join(dir, "segments.csv")
+	cmd := c.ffmpegCommand(ctx, cg,
+		"-y",
+		"-i", filePath,
+		"-map", "0:v:0",
+		"-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.FormatFloat(c.encoding.Chunking.SegmentDuration.Seconds(), 'f', -1, 64),
+		"-segment_list", list, "-segment_list_type", "csv",
+		"-reset_timestamps", "1",
+		filepath.Join(dir, "source_%04d.mkv"),
+	)
+	if err := c.runTraced(ctx, "ffmpeg/Split", cmd); err != nil {
+		return nil, encodeFailure(ctx, cg, "split", timeout, err)
+	}
+	f, err := os.Open(list)
+	if err != nil {
+		return nil, failure(compressionModel.FailureReasonEncode, "failed to read the segment list: %w", err)
+	}
+	defer f.Close()
+	// Each record is the file, start and end of a segment.
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, failure(compressionModel.FailureReasonEncode, "failed to read the segment list: %w", err)
+	}
+	segments := make([]sourceSegment, 0, len(records))
+	for _, r := range records {
+		if len(r) != 3 {
+			return nil, failure(compressionModel.FailureReasonEncode, "invalid segment list entry %q", strings.Join(r, ","))
+		}
+		start, err1 := strconv.ParseFloat(r[1], 64)
+		end, err2 := strconv.ParseFloat(r[2], 64)
+		if err := errors.Join(err1, err2); err != nil {
+			return nil, failure(compressionModel.FailureReasonEncode, "invalid segment list entry %q: %w", strings.Join(r, ","), err)
+		}
+		segments = append(segments, sourceSegment{file: filepath.Join(dir, filepath.Base(r[0])), duration: end - start})
+	}
+	return segments, nil
+}
+
+// encodeAudio encodes the first audio stream of filePath
+// into dir at the audio target. It returns an empty file
+// name if the video has no audio.
+func (c *Controller) encodeAudio(ctx context.Context, cg *cgroup, timeout time.Duration, filePath string, duration float64, dir string) (string, error) {
+	data, err := ffprobe.ProbeURL(ctx, filePath)
+	if err != nil {
+		return "", encodeFailure(ctx, cg, "probe", timeout, err)
+	}
+	if data.FirstAudioStream() == nil {
+		return "", nil
+	}
+	_, audioBitrate := CalculateBitrates(duration, c.encoding.TargetVideoMB, c.encoding.TargetAudioMB)
+	audioFile := filepath.Join(dir, "audio.m4a")
+	cmd := c.ffmpegCommand(ctx, cg,
+		"-y",
+		"-i", filePath,
+		"-map", "0:a:0",
+		"-c:a", c.encoding.AudioCodec,
+		"-b:a", strconv.FormatFloat(audioBitrate, 'f', 0, 64),
+		audioFile,
+	)
+	if err := c.runTraced(ctx, "ffmpeg/Audio", cmd); err != nil {
+		return "", encodeFailure(ctx, cg, "audio", timeout, err)
+	}
+	return audioFile, nil
+}
+
+// concat joins the encoded segments and the audio to
+// output without encoding them again, with the subtitles,
+// chapters and metadata of the source that are kept. The
+// output keeps the rotation of the source unless its
+// frames were rotated.
+func (c *Controller) concat(ctx context.Context, cg *cgroup, timeout time.Duration, dir string, segments []string, audioFile string, source string, rotation int, output string) error {
+	var list strings.Builder
+	for _, file := range segments {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(file, "'", `'\''`))
+	}
+	listFile := filepath.Join(dir, "concat.txt")
+	if err := os.WriteFile(listFile, []byte(list.String()), 0o600); err != nil {
+		return failure(compressionModel.FailureReasonEncode, "failed to write the concat list: %w", err)
+	}
+	args := []string{"-y"}
+	if !c.encoding.Output.NormalizeRotation {
+		args = append(args, c.inputArgs(rotation)...)
+	}
+	args = append(args, "-f", "concat", "-safe", "0", "-i", listFile)
+	maps := []string{"-map", "0:v"}
+	if audioFile != "" {
+		args = append(args, "-i", audioFile)
+		maps = append(maps, "-map", "1:a")
+	}
+	// The source is the last input, for its subtitles,
+	// chapters and metadata.
+	args = append(args, "-i", source)
+	format := outputFormat(output)
+	args = append(args, maps...)
+	args = append(args, "-c", "copy")
+	args = append(args, c.sourceArgs(len(maps)/2, format)...)
+	args = append(args, c.containerArgs(format)...)
+	args = append(args, output)
+	if err := c.runTraced(ctx, "ffmpeg/Concat", c.ffmpegCommand(ctx, cg, args...)); err != nil {
+		return encodeFailure(ctx, cg, "concat", timeout, err)
+	}
+	return nil
+}
+
+func (c *Controller) publishSegments(ctx context.Context, events []compressionModel.SegmentEvent) error {
+	ctx, span := otel.Tracer(tracerID).Start(ctx, "Kafka/PublishSegmentEvents",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", c.topics.Segments)),
+	)
+	defer span.End()
+	msgs := make([]kafka.Message, len(events))
+	for i, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal segment event: %w", err)
+		}
+		msgs[i] = kafka.Message{
+			Topic: c.topics.Segments,
+			Key:   []byte(fmt.Sprintf("%s/%d", event.Prefix, event.Index)),
+			Value: payload,
+		}
+		tracing.InjectKafka(ctx, &msgs[i])
+	}
+	if err := c.segments.Writer.WriteMessages(ctx, msgs...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to publish segments")
+		return err
+	}
+	return nil
+}
+
+// removeSegments deletes the stored sources and outputs of
+// the segments of a job.
+func (c *Controller) removeSegments(ctx context.Context, events []compressionModel.SegmentEvent) {
+	for _, event := range events {
+		for _, key := range []string{event.SourceKey(), event.OutputKey()} {
+			if err := c.repo.RemoveObject(ctx, c.bucket, key); err != nil {
+				log.Printf("Failed to delete segment %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// ConsumeSegments encodes the segments of chunked jobs
+// until ctx is cancelled. A segment in progress when ctx is
+// cancelled is finished, unless it is interrupted after
+// encoding.limits.drainTimeout. Segments are only committed
+// once encoded, so that the segments of a worker that
+// stopped are encoded by another one.
+func (c *Controller) ConsumeSegments(ctx context.Context) error {
+	segmentCtx := context.WithoutCancel(ctx)
+	defer c.drain(ctx)()
+	for {
+		m, err := c.segments.Reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("kafka reading error: %v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		var event compressionModel.SegmentEvent
+		if err := json.Unmarshal(m.Value, &event); err != nil || event.Prefix == "" {
+			log.Printf("Skipping invalid segment at offset %d", m.Offset)
+		} else if !c.handleSegmentEvent(segmentCtx, m, event) {
+			return nil
+		}
+		if err := c.segments.Reader.CommitMessages(segmentCtx, m); err != nil {
+			log.Printf("failed to commit segment: %v", err)
+		}
+	}
+}
+
+// handleSegmentEvent encodes a single segment and reports
+// it to the worker that split the job. The segments of a
+// cancelled job are dropped. It returns false if the
+// segment was interrupted, to be encoded by another worker.
+func (c *Controller) handleSegmentEvent(ctx context.Context, m kafka.Message, event compressionModel.SegmentEvent) bool {
+	ctx, span := otel.Tracer(tracerID).Start(tracing.ExtractKafka(ctx, &m), "Kafka/ConsumeSegmentEvent",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int64("job.id", event.JobID),
+			attribute.Int("segment.index", event.Index),
+			attribute.Float64("segment.duration", event.Duration),
+		),
+	)
+	defer span.End()
+
+	segmentCtx, done := c.cancels.start(ctx, event.JobID)
+	defer done()
+	if isCancelled(segmentCtx) {
+		return true
+	}
+	result := compressionModel.SegmentResultEvent{JobID: event.JobID, Prefix: event.Prefix, Index: event.Index}
+	start := time.Now()
+	err := c.encodeSegment(segmentCtx, event)
+	if isCancelled(segmentCtx) {
+		return true
+	}
+	if err != nil && isInterrupted(segmentCtx) {
+		log.Printf("segment %d of job %d interrupted", event.Index, event.JobID)
+		return false
+	}
+	if err != nil {
+		log.Printf("segment %d of job %d failed: %v", event.Index, event.JobID, err)
+		segmentsFailed.WithLabelValues(string(failureReason(err))).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, string(failureReason(err)))
+		result.Reason = failureReason(err)
+	} else {
+		segmentsEncoded.Inc()
+		c.load.encoded(event.Duration, time.Since(start))
+	}
+	if err := c.publishSegmentResult(ctx, result); err != nil {
+		log.Printf("failed to publish the result of segment %d of job %d: %v", event.Index, event.JobID, err)
+		span.RecordError(err)
+	}
+	return true
+}
+
+// encodeSegment encodes the video of a segment in two
+// passes at its share of the target.
+func (c *Controller) encodeSegment(ctx context.Context, event compressionModel.SegmentEvent) error {
+	name := "segment_" + RandStringBytes(12)
+	workDir := c.encoding.WorkDir
+	source, err := c.repo.DownloadObject(ctx, c.bucket, event.SourceKey(), filepath.Join(workDir, name+".mkv"))
+	if err != nil {
+		return failure(compressionModel.FailureReasonDownload, "error downloading segment: %w", err)
+	}
+	defer os.Remove(source)
+	output := filepath.Join(workDir, name+".mp4")
+	defer os.Remove(output)
+
+	timeout := encodeTimeout(c.encoding.Limits, event.Duration)
+	encodeCtx, cancel := context.WithTimeoutCause(ctx, timeout, errTimedOut)
+	defer cancel()
+	cg, err := newCgroup(c.encoding.Limits, name)
+	if err != nil {
+		return failure(compressionModel.FailureReasonEncode, "failed to limit ffmpeg: %w", err)
+	}
+	defer cg.remove()
+	videoBitrate, _ := CalculateBitrates(event.Duration, event.TargetVideoMB, 0)
+	// Segments have pass logs of their own, as they may be
+	// encoded while the worker runs a job.
+	err = c.encodeTwoPass(encodeCtx, cg, timeout, source, c.inputArgs(event.Rotation), output, nil, filepath.Join(workDir, name+"-passlog"),
+		strconv.FormatFloat(videoBitrate, 'f', 0, 64), "")
+	if err != nil {
+		return err
+	}
+	if err := c.repo.UploadObject(ctx, c.bucket, event.OutputKey(), output); err != nil {
+		return failure(compressionModel.FailureReasonUpload, "error uploading segment: %w", err)
+	}
+	return nil
+}
+
+func (c *Controller) publishSegmentResult(ctx context.Context, result compressionModel.SegmentResultEvent) error {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal segment result: %w", err)
+	}
+	ctx, span := otel.Tracer(tracerID).Start(ctx, "Kafka/PublishSegmentResultEvent",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", c.topics.SegmentResults)),
+	)
+	defer span.End()
+	msg := kafka.Message{
+		Topic: c.topics.SegmentResults,
+		Key:   []byte(fmt.Sprintf("%d", result.JobID)),
+		Value: payload,
+	}
+	tracing.InjectKafka(ctx, &msg)
+	if err := c.segments.Writer.WriteMessages(ctx, msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to publish segment result")
+		return err
+	}
+	return nil
+}
+
+// ConsumeSegmentResults hands the results of segments to
+// the chunked jobs of the worker until ctx is done. Only
+// results published after the worker started are read, as
+// earlier ones belong to jobs it does not run.
+func (c *Controller) ConsumeSegmentResults(ctx context.Context) error {
+	if err := c.segments.ResultReader.SetOffset(kafka.LastOffset); err != nil {
+		return fmt.Errorf("failed to seek segment results: %w", err)
+	}
+	for {
+		m, err := c.segments.ResultReader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("kafka reading error: %v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		var result compressionModel.SegmentResultEvent
+		if err := json.Unmarshal(m.Value, &result); err != nil || result.Prefix == "" {
+			log.Printf("Skipping invalid segment result at offset %d", m.Offset)
+			continue
+		}
+		c.segmentWaiters.deliver(result)
+	}
+}
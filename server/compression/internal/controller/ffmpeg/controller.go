@@ -4,109 +4,292 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"ffmpeg/wrapper/compression/internal/dedup"
 	"ffmpeg/wrapper/compression/internal/repository"
 	compressionModel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/user"
 	metadataModel "ffmpeg/wrapper/metadata/pkg/model"
+	"ffmpeg/wrapper/pkg/discovery/tracing"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var ErrNotFound = errors.New("not found")
 
+const tracerID = "compression-controller-ffmpeg"
+
 type Controller struct {
-	kafkaReader *kafka.Reader
+	// jobReaders read the job topic of each priority.
+	jobReaders  map[compressionModel.Priority]*kafka.Reader
+	scheduler   *scheduler
+	load        *load
 	kafkaWriter *kafka.Writer
-	repo        repository.S3
+	// cancelReader reads the cancellations topic outside
+	// any consumer group, so that every worker reads every
+	// cancellation.
+	cancelReader *kafka.Reader
+	cancels      *cancellations
+	// segments are the Kafka clients of chunked jobs.
+	segments       SegmentClients
+	topics         config.Topics
+	segmentWaiters *segmentWaiters
+	repo           repository.S3
+	bucket         string
+	encoding       config.Encoding
+	limits         config.Limits
+	// dedup holds the outputs of earlier jobs, reused for
+	// identical sources.
+	dedup *dedup.Cache
 }
 
-func New(reader *kafka.Reader, writer *kafka.Writer, repository repository.S3) *Controller {
+func New(jobReaders map[compressionModel.Priority]*kafka.Reader, writer *kafka.Writer, cancelReader *kafka.Reader, segments SegmentClients, repository repository.S3, cache *dedup.Cache, cfg *config.Config) *Controller {
 	return &Controller{
-		kafkaReader: reader,
-		kafkaWriter: writer,
-		repo:        repository,
+		jobReaders:     jobReaders,
+		scheduler:      newScheduler(cfg.Scheduling),
+		load:           &load{encodeFactor: defaultEncodeFactor},
+		kafkaWriter:    writer,
+		cancelReader:   cancelReader,
+		cancels:        newCancellations(),
+		segments:       segments,
+		topics:         cfg.Kafka.Topics,
+		segmentWaiters: newSegmentWaiters(),
+		repo:           repository,
+		bucket:         cfg.Storage.Bucket,
+		encoding:       cfg.Encoding,
+		limits:         cfg.Limits,
+		dedup:          cache,
 	}
 }
 
-var bucketName = os.Getenv("bucketname")
-
-func (c *Controller) Compress(ctx context.Context, duration float64, compressedKey string, objectKey string, filename string) (*v4.PresignedHTTPRequest, error) {
-	target_video := 8.0 //megabytes
-	target_audio := 1.0 // megabytes
-
-	videoBitrate, audioBitrate := CalculateBitrates(duration, target_video, target_audio)
+// Compress encodes the source described by meta with the
+// given strategy, or with the strategies it falls back to,
+// and uploads it. It returns the strategy that encoded it.
+func (c *Controller) Compress(ctx context.Context, jobID int64, meta metadataModel.Metadata, strategy compressionModel.Strategy, duration float64, compressedKey string, objectKey string, filename string) (*v4.PresignedHTTPRequest, compressionModel.Strategy, error) {
+	videoBitrate, audioBitrate := CalculateBitrates(duration, c.encoding.TargetVideoMB, c.encoding.TargetAudioMB)
 
 	videoBitrateStr := strconv.FormatFloat(videoBitrate, 'f', 0, 64)
 	audioBitrateStr := strconv.FormatFloat(audioBitrate, 'f', 0, 64)
 
-	filePath, err := c.repo.DownloadObject(ctx, bucketName, objectKey, filename)
+	workDir := c.encoding.WorkDir
+	filePath, err := c.repo.DownloadObject(ctx, c.bucket, objectKey, filepath.Join(workDir, filename))
+	if err != nil {
+		return nil, "", failure(compressionModel.FailureReasonDownload, "error downloading object from R2: %w", err)
+	}
+	defer os.Remove(filePath)
+	var inputSize int64
+	if fi, err := os.Stat(filePath); err == nil {
+		inputSize = fi.Size()
+		inputBytes.Observe(float64(inputSize))
+	}
+	outputFilename := filepath.Join(workDir, compressedKey)
+	// The output is also removed when ffmpeg was killed
+	// halfway through a cancelled job.
+	defer os.Remove(outputFilename)
+
+	timeout := encodeTimeout(c.encoding.Limits, duration)
+	encodeCtx, cancel := context.WithTimeoutCause(ctx, timeout, errTimedOut)
+	defer cancel()
+	cg, err := newCgroup(c.encoding.Limits, "job-"+RandStringBytes(12))
 	if err != nil {
-		return nil, fmt.Errorf("error downloading object from R2: %w", err)
+		return nil, "", failure(compressionModel.FailureReasonEncode, "failed to limit ffmpeg: %w", err)
 	}
-	outputFilename := "/tmp/" + fmt.Sprintf("compressed_%s", filename)
+	defer cg.remove()
+
+	for {
+		var err error
+		switch strategy {
+		case compressionModel.StrategyRemux:
+			err = c.remux(encodeCtx, cg, timeout, filePath, c.remuxFormat(meta, objectKey), outputFilename)
+		case compressionModel.StrategyChunked:
+			err = c.encodeChunked(encodeCtx, cg, timeout, jobID, filePath, duration, meta.Rotation, outputFilename)
+		case compressionModel.StrategyAnimated:
+			err = c.encodeAnimated(encodeCtx, cg, timeout, filePath, duration, outputFilename)
+		case compressionModel.StrategyCRF:
+			err = c.encodeCRF(encodeCtx, cg, timeout, filePath, duration, outputFilename)
+		default:
+			log.Println(outputFilename, filePath, filename)
+			err = c.encodeTwoPass(encodeCtx, cg, timeout, filePath, c.inputArgs(0), outputFilename, c.outputArgs(outputFormat(outputFilename)),
+				filepath.Join(workDir, "passlog"), videoBitrateStr, audioBitrateStr)
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errUnsuited) {
+			return nil, "", err
+		}
+		next := c.fallbackStrategy(strategy, duration)
+		log.Printf("Encoding job %d with %s instead of %s: %v", jobID, next, strategy, err)
+		strategyFallbacks.WithLabelValues(string(strategy)).Inc()
+		strategy = next
+	}
+	jobsEncoded.WithLabelValues(string(strategy)).Inc()
+	if fi, err := os.Stat(outputFilename); err == nil {
+		outputBytes.Observe(float64(fi.Size()))
+		if inputSize > 0 && fi.Size() > 0 {
+			compressionRatio.Observe(float64(inputSize) / float64(fi.Size()))
+		}
+	}
+
+	err = c.repo.UploadObject(ctx, c.bucket, compressedKey, outputFilename)
+	if err != nil {
+		return nil, "", failure(compressionModel.FailureReasonUpload, "error uploading object to R2: %w", err)
+	}
+	presignedRequest, err := c.repo.GetObject(ctx, c.bucket, compressedKey, int64(c.limits.DownloadURLLifetime.Seconds()))
+	if err != nil {
+		return nil, "", failure(compressionModel.FailureReasonPresign, "failed to create presigned download url: %w", err)
+	}
+	return presignedRequest, strategy, nil
+}
+
+// encodeTwoPass encodes the first video stream of input to
+// output in two passes at the given bitrates, with the
+// given input options and output options of the second
+// pass. Without audio bitrate, the output has no audio.
+func (c *Controller) encodeTwoPass(ctx context.Context, cg *cgroup, timeout time.Duration, input string, inputArgs []string, output string, outputArgs []string, passLogFile string, videoBitrate string, audioBitrate string) error {
+	// The pass logs are also removed when ffmpeg was killed
+	// halfway through a cancelled job.
+	defer os.Remove(passLogFile + "-0.log")
+	defer os.Remove(passLogFile + "-0.log.mbtree")
+	audio := []string{"-an"}
+	if audioBitrate != "" {
+		audio = []string{"-c:a", c.encoding.AudioCodec, "-b:a", audioBitrate}
+	}
+
+	args := func(pass string, out ...string) []string {
+		return slices.Concat([]string{"-y"}, inputArgs, []string{
+			"-i", input,
+			"-map", "0:v:0",
+			"-c:v", c.encoding.VideoCodec,
+			"-preset", c.encoding.Preset,
+			"-b:v", videoBitrate,
+			"-pass", pass, "-passlogfile", passLogFile,
+		}, audio, out)
+	}
+
 	// PASS 1
 
-	log.Println(outputFilename, filePath, filename)
-	cmd1 := exec.Command(
-		"ffmpeg",
-		"-y",
-		"-i", filePath,
-		"-c:v", "libx264",
-		"-preset", "medium",
-		"-b:v", videoBitrateStr,
-		"-pass", "1", "-passlogfile", "/tmp/passlog",
-		"-c:a", "aac",
-		"-b:a", audioBitrateStr,
-		"-f", "mp4", "/dev/null",
-	)
-	cmd1.Dir = "/tmp"
-	cmd1.Stderr = os.Stderr
-	cmd1.Stdout = os.Stdout
-	if err := cmd1.Run(); err != nil {
-		return nil, fmt.Errorf("error running ffmpeg pass 1 %w", err)
+	cmd1 := c.ffmpegCommand(ctx, cg, args("1", "-f", "mp4", "/dev/null")...)
+	start := time.Now()
+	if err := c.runTraced(ctx, "ffmpeg/Pass1", cmd1); err != nil {
+		return encodeFailure(ctx, cg, "1", timeout, err)
 	}
+	encodeSeconds.WithLabelValues("1").Observe(time.Since(start).Seconds())
 
 	// PASS 2
 
-	cmd2 := exec.Command(
-		"ffmpeg",
-		"-y",
-		"-i", filePath,
-		"-c:v", "libx264",
-		"-preset", "medium",
-		"-b:v", videoBitrateStr,
-		"-pass", "2", "-passlogfile", "/tmp/passlog",
-		"-c:a", "aac",
-		"-b:a", audioBitrateStr,
-		outputFilename,
-	)
-	cmd2.Dir = "/tmp"
-	cmd2.Stderr = os.Stderr
-	cmd2.Stdout = os.Stdout
-	if err := cmd2.Run(); err != nil {
-		return nil, fmt.Errorf("error running ffmpeg pass 2 %w", err)
+	cmd2 := c.ffmpegCommand(ctx, cg, args("2", append(outputArgs, output)...)...)
+	start = time.Now()
+	if err := c.runTraced(ctx, "ffmpeg/Pass2", cmd2); err != nil {
+		return encodeFailure(ctx, cg, "2", timeout, err)
+	}
+	encodeSeconds.WithLabelValues("2").Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// outputKey returns the key of the output of the source
+// objectKey in the given format. Videos keep the extension
+// of their source, unless their streams are copied into an
+// MP4 file.
+func (c *Controller) outputKey(meta metadataModel.Metadata, objectKey string, format compressionModel.OutputFormat) string {
+	ext := format.Extension()
+	if ext == "" && c.remuxFormat(meta, objectKey) == "mp4" {
+		ext = ".mp4"
 	}
+	if ext != "" {
+		objectKey = strings.TrimSuffix(objectKey, path.Ext(objectKey)) + ext
+	}
+	return "compressed_" + objectKey
+}
 
-	os.Remove("/tmp/passlog-0.log")
-	os.Remove("/tmp/passlog-0.log.mbtree")
+// returnSource copies the source to compressedKey and
+// presigns its download, for a source that already fits
+// the target. It returns false if that failed, in which
+// case the job is encoded instead.
+func (c *Controller) returnSource(ctx context.Context, objectKey string, compressedKey string) (*v4.PresignedHTTPRequest, bool) {
+	if err := c.repo.CopyObject(ctx, c.bucket, objectKey, compressedKey, compressedKey); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to return source %s, encoding instead: %v", objectKey, err)
+		}
+		return nil, false
+	}
+	presignedRequest, err := c.repo.GetObject(ctx, c.bucket, compressedKey, int64(c.limits.DownloadURLLifetime.Seconds()))
+	if err != nil {
+		log.Printf("Failed to presign returned source %s, encoding instead: %v", compressedKey, err)
+		return nil, false
+	}
+	log.Printf("Returned source %s as it is already small enough", objectKey)
+	return presignedRequest, true
+}
 
-	err = c.repo.UploadObject(ctx, bucketName, compressedKey, outputFilename)
+// reuse copies the cached output of an identical source in
+// the given format to compressedKey and presigns its
+// download. It returns false if there is none, or if
+// reusing it failed, in which case the job is encoded
+// instead.
+func (c *Controller) reuse(ctx context.Context, sourceSHA256 string, format compressionModel.OutputFormat, compressedKey string) (*v4.PresignedHTTPRequest, bool) {
+	cachedKey, ok := c.dedup.Get(ctx, sourceSHA256, format)
+	if !ok {
+		return nil, false
+	}
+	if cachedKey != compressedKey {
+		if err := c.repo.CopyObject(ctx, c.bucket, cachedKey, compressedKey, compressedKey); err != nil {
+			if ctx.Err() != nil {
+				return nil, false
+			}
+			// The cached output is gone once its retention
+			// ended.
+			log.Printf("Failed to reuse %s, encoding instead: %v", cachedKey, err)
+			c.dedup.Stale(ctx, sourceSHA256, format)
+			return nil, false
+		}
+	}
+	presignedRequest, err := c.repo.GetObject(ctx, c.bucket, compressedKey, int64(c.limits.DownloadURLLifetime.Seconds()))
 	if err != nil {
-		return nil, fmt.Errorf("error uploading object to R2: %w", err)
+		log.Printf("Failed to presign reused output %s, encoding instead: %v", compressedKey, err)
+		return nil, false
+	}
+	log.Printf("Reused output %s of an identical source for %s", cachedKey, compressedKey)
+	return presignedRequest, true
+}
+
+// runTraced runs an ffmpeg command inside a span, at the
+// niceness of encoding.limits.nice.
+func (c *Controller) runTraced(ctx context.Context, name string, cmd *exec.Cmd) error {
+	_, span := otel.Tracer(tracerID).Start(ctx, name, trace.WithAttributes(
+		attribute.StringSlice("process.command_args", cmd.Args),
+	))
+	defer span.End()
+	err := cmd.Start()
+	if err == nil {
+		if err := setNice(cmd, c.encoding.Limits.Nice); err != nil {
+			log.Printf("Failed to set the niceness of ffmpeg: %v", err)
+		}
+		err = cmd.Wait()
 	}
-	presignedRequest, err := c.repo.GetObject(ctx, bucketName, compressedKey, 1800)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create presigned download url: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
 	}
-	os.Remove(outputFilename)
-	return presignedRequest, nil
+	return nil
 }
 
 func CalculateBitrates(duration float64, targetVideo, targetAudio float64) (float64, float64) {
@@ -127,61 +310,187 @@ func RandStringBytes(n int) string {
 	return string(b)
 }
 
+// ConsumeCompressionEvent compresses the videos of incoming
+// compression events until ctx is cancelled. The events of
+// all priorities are fetched ahead of time, and run in the
+// order of the fair queue of the scheduler. A job that is
+// in progress when ctx is cancelled is finished before the
+// readers are closed, so that the caller can drain the
+// worker by waiting for ConsumeCompressionEvent to return.
+// It fails as interrupted if it does not finish within
+// encoding.limits.drainTimeout. Jobs fetched but not
+// started are not committed, and are read again by another
+// worker.
 func (c *Controller) ConsumeCompressionEvent(ctx context.Context) {
-
+	jobCtx := context.WithoutCancel(ctx)
+	defer c.drain(ctx)()
+	var wg sync.WaitGroup
+	for p, reader := range c.jobReaders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.scheduler.fetch(ctx, p, reader)
+		}()
+	}
 	for {
-		m, err := c.kafkaReader.ReadMessage(ctx)
+		j, err := c.scheduler.next(ctx)
 		if err != nil {
 			break
 		}
+		c.load.start()
+		c.handleCompressionEvent(jobCtx, j.message)
+		c.load.finish()
+		c.scheduler.done(jobCtx, j)
+	}
+	wg.Wait()
 
-		var event metadataModel.CompressionEvent
-		if err := json.Unmarshal(m.Value, &event); err != nil {
-			log.Printf("Unmarshal error: %v", err)
-			continue
+	for _, reader := range c.jobReaders {
+		if err := reader.Close(); err != nil {
+			log.Printf("failed to close reader: %v", err)
 		}
+	}
+}
 
-		// Check if this is actually a CompressionEvent with metadata
-		if event.Metadata.Duration == "" {
-			log.Printf("Skipping message without duration (not a CompressionEvent?)")
-			continue
-		}
-		durationFloat, err := strconv.ParseFloat(event.Metadata.Duration, 64)
-		if err != nil {
-			log.Printf("failed to parse duration: %v", err)
-			// handle error or skip processing
-			continue
-		}
+// handleCompressionEvent compresses the video of a single
+// compression event and publishes the result. The job is
+// traced as part of the trace the event was published in.
+func (c *Controller) handleCompressionEvent(ctx context.Context, m kafka.Message) {
+	var event metadataModel.CompressionEvent
+	if err := json.Unmarshal(m.Value, &event); err != nil {
+		log.Printf("Unmarshal error: %v", err)
+		return
+	}
 
-		compressedKey := fmt.Sprintf("compressed_%s", event.ObjectKey)
+	// Check if this is actually a CompressionEvent with metadata
+	if event.Metadata.Duration == "" {
+		log.Printf("Skipping message without duration (not a CompressionEvent?)")
+		return
+	}
 
-		presignedDownloadURL, err := c.Compress(ctx, durationFloat, compressedKey, event.ObjectKey, event.ObjectKey)
+	if event.UserID != "" {
+		ctx = user.WithID(ctx, event.UserID)
+	}
+	ctx, span := otel.Tracer(tracerID).Start(tracing.ExtractKafka(ctx, &m), "Kafka/ConsumeCompressionEvent",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.Int64("job.id", event.JobID),
+			attribute.String("object.key", event.ObjectKey),
+			attribute.String("user.id", event.UserID),
+			attribute.String("job.priority", string(event.Priority)),
+			attribute.String("job.output_format", string(event.OutputFormat)),
+			attribute.String("messaging.destination.name", m.Topic),
+		),
+	)
+	defer span.End()
 
-		if err != nil {
-			log.Printf("compression failed: %v", err)
-			_ = c.PublishCompressionResultEvent(ctx, compressionModel.CompressionEventTypeFail,
-				event.JobID, event.ObjectKey, compressedKey, nil, time.Time{})
-			continue
-		}
+	jobsStarted.Inc()
+	durationFloat, err := strconv.ParseFloat(event.Metadata.Duration, 64)
+	if err != nil {
+		log.Printf("failed to parse duration: %v", err)
+		jobsFailed.WithLabelValues(string(compressionModel.FailureReasonInvalidEvent)).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid compression event")
+		return
+	}
 
-		err = c.PublishCompressionResultEvent(ctx, compressionModel.CompressionEventTypeSuccess,
-			event.JobID, event.ObjectKey, compressedKey, presignedDownloadURL, getExpiry())
-		if err != nil {
-			log.Printf("failed to publish compression result: %v", err)
+	compressedKey := c.outputKey(event.Metadata, event.ObjectKey, event.OutputFormat)
+
+	var presignedDownloadURL *v4.PresignedHTTPRequest
+	var strategy compressionModel.Strategy
+	var outcome compressionModel.Outcome
+	jobCtx, done := c.cancels.start(ctx, event.JobID)
+	defer done()
+	if !isCancelled(jobCtx) {
+		// The job no longer counts as queued.
+		if err := c.PublishCompressionResultEvent(ctx, compressionModel.CompressionEventTypeProcessing,
+			event.JobID, event.ObjectKey, compressedKey, nil, time.Time{}, event.Callback, event.JobType, "", "", ""); err != nil {
+			log.Printf("failed to publish the start of job %d: %v", event.JobID, err)
+		}
+		var skipped, reused bool
+		if c.returnable(event.Metadata, event.ObjectKey, event.OutputFormat) {
+			presignedDownloadURL, skipped = c.returnSource(jobCtx, event.ObjectKey, compressedKey)
+		}
+		if !skipped {
+			presignedDownloadURL, reused = c.reuse(jobCtx, event.Metadata.SHA256, event.OutputFormat, compressedKey)
+		}
+		span.SetAttributes(attribute.Bool("dedup.hit", reused))
+		switch {
+		case skipped:
+			outcome = compressionModel.OutcomeSkippedAlreadySmall
+		case reused:
+			outcome = compressionModel.OutcomeReused
+		default:
+			start := time.Now()
+			strategy = c.selectStrategy(event.Metadata, event.ObjectKey, event.OutputFormat, durationFloat)
+			presignedDownloadURL, strategy, err = c.Compress(jobCtx, event.JobID, event.Metadata, strategy, durationFloat, compressedKey, event.ObjectKey, event.ObjectKey)
+			if err == nil {
+				c.load.encoded(durationFloat, time.Since(start))
+				span.SetAttributes(attribute.String("encoding.strategy", string(strategy)))
+			}
+			// Only already small sources are skipped, the others
+			// are remuxed by the auto strategy.
+			outcome = compressionModel.OutcomeCompressed
+			if _, ok := c.alreadySmall(event.Metadata, event.ObjectKey); ok && strategy == compressionModel.StrategyRemux {
+				outcome = compressionModel.OutcomeSkippedAlreadySmall
+			}
 		}
 	}
+	// A job cancelled while it ran is reported as cancelled,
+	// even if it managed to finish.
+	if isCancelled(jobCtx) {
+		c.jobCancelled(ctx, event, compressedKey)
+		return
+	}
+	if err != nil && isInterrupted(jobCtx) {
+		err = failure(compressionModel.FailureReasonInterrupted, "job interrupted by the shutdown of the worker: %w", err)
+	}
+	if err != nil {
+		log.Printf("compression failed: %v", err)
+		jobsFailed.WithLabelValues(string(failureReason(err))).Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, string(failureReason(err)))
+		_ = c.PublishCompressionResultEvent(ctx, compressionModel.CompressionEventTypeFail,
+			event.JobID, event.ObjectKey, compressedKey, nil, time.Time{}, event.Callback, event.JobType, "", "", failureReason(err))
+		return
+	}
+	jobsSucceeded.Inc()
+	jobOutcomes.WithLabelValues(string(outcome)).Inc()
+	span.SetAttributes(attribute.String("job.outcome", string(outcome)))
+	// The newest output is cached, as it is retained the
+	// longest.
+	c.dedup.Put(ctx, event.Metadata.SHA256, event.OutputFormat, compressedKey)
+
+	err = c.PublishCompressionResultEvent(ctx, compressionModel.CompressionEventTypeSuccess,
+		event.JobID, event.ObjectKey, compressedKey, presignedDownloadURL, c.getExpiry(), event.Callback, event.JobType, outcome, strategy, "")
+	if err != nil {
+		log.Printf("failed to publish compression result: %v", err)
+		span.RecordError(err)
+	}
+}
 
-	if err := c.kafkaReader.Close(); err != nil {
-		log.Fatal("failed to close reader", err)
+// jobCancelled deletes the output a cancelled job may have
+// uploaded, and publishes the cancellation of the job.
+func (c *Controller) jobCancelled(ctx context.Context, event metadataModel.CompressionEvent, compressedKey string) {
+	log.Printf("Job %d cancelled", event.JobID)
+	jobsCancelled.Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("job.cancelled", true))
+	if err := c.repo.RemoveObject(ctx, c.bucket, compressedKey); err != nil {
+		log.Printf("Failed to delete the output of cancelled job %d: %v", event.JobID, err)
+	}
+	err := c.PublishCompressionResultEvent(ctx, compressionModel.CompressionEventTypeCancelled,
+		event.JobID, event.ObjectKey, compressedKey, nil, time.Time{}, event.Callback, event.JobType, "", "", "")
+	if err != nil {
+		log.Printf("failed to publish compression result: %v", err)
 	}
 }
-func getExpiry() time.Time {
+
+func (c *Controller) getExpiry() time.Time {
 	current := time.Now()
-	expiry := current.Add(1800 * time.Second)
+	expiry := current.Add(c.limits.DownloadURLLifetime)
 	return expiry
 }
 func (c *Controller) PublishCompressionResultEvent(ctx context.Context, eventType compressionModel.CompressionEventType,
-	jobID int64, objecKey string, compressedKey string, presignedDownloadURL *v4.PresignedHTTPRequest, expiry time.Time) error {
+	jobID int64, objecKey string, compressedKey string, presignedDownloadURL *v4.PresignedHTTPRequest, expiry time.Time, callback *compressionModel.Callback, jobType compressionModel.JobType, outcome compressionModel.Outcome, strategy compressionModel.Strategy, reason compressionModel.FailureReason) error {
 
 	var presignedPayload *compressionModel.PresignedRequestPayload
 	if presignedDownloadURL != nil {
@@ -205,9 +514,15 @@ func (c *Controller) PublishCompressionResultEvent(ctx context.Context, eventTyp
 		CompressedKey:        compressedKey,
 		PresignedDownloadUrl: presignedPayload,
 		Expiry:               expiry,
+		UserID:               user.ID(ctx),
+		Callback:             callback,
+		JobType:              jobType,
+		Strategy:             strategy,
+		Outcome:              outcome,
+		Reason:               reason,
 	}
 
-	if eventType == compressionModel.CompressionEventTypeFail {
+	if eventType != compressionModel.CompressionEventTypeSuccess {
 		event.PresignedDownloadUrl = nil
 	}
 
@@ -215,8 +530,21 @@ func (c *Controller) PublishCompressionResultEvent(ctx context.Context, eventTyp
 	if err != nil {
 		return fmt.Errorf("failed to marshal compression event: %w", err)
 	}
-	return c.kafkaWriter.WriteMessages(ctx, kafka.Message{
+
+	ctx, span := otel.Tracer(tracerID).Start(ctx, "Kafka/PublishCompressionResultEvent",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", c.kafkaWriter.Topic)),
+	)
+	defer span.End()
+	msg := kafka.Message{
 		Key:   []byte(fmt.Sprintf("%d", jobID)),
 		Value: payload,
-	})
+	}
+	tracing.InjectKafka(ctx, &msg)
+	if err := c.kafkaWriter.WriteMessages(ctx, msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to publish compression result")
+		return err
+	}
+	return nil
 }
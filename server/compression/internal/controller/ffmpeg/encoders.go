@@ -0,0 +1,50 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// knownEncoders are the encoders worth advertising to the
+// service registry. The full ffmpeg list is far longer
+// than registry metadata allows.
+var knownEncoders = []string{
+	"libx264", "libx265", "libvpx-vp9", "libaom-av1", "libsvtav1",
+	"h264_nvenc", "hevc_nvenc", "h264_qsv", "hevc_qsv", "h264_vaapi", "hevc_vaapi",
+	"aac", "libopus", "libmp3lame",
+	"gif", "libwebp",
+}
+
+// Encoders returns the known encoders supported by the
+// installed ffmpeg.
+func Encoders(ctx context.Context) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ffmpeg encoders: %w", err)
+	}
+	var res []string
+	listing := false
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// The encoder list follows a legend ending with a
+		// " ------" line, e.g. " V....D libx264  H.264 ...".
+		if strings.HasPrefix(line, "---") {
+			listing = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !listing || len(fields) < 2 {
+			continue
+		}
+		if slices.Contains(knownEncoders, fields[1]) {
+			res = append(res, fields[1])
+		}
+	}
+	return res, scanner.Err()
+}
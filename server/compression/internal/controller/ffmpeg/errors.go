@@ -0,0 +1,36 @@
+package ffmpeg
+
+import (
+	"errors"
+	"ffmpeg/wrapper/compression/pkg/model"
+	"fmt"
+)
+
+// JobError is returned when a compression job fails and
+// records the stage of the job that failed.
+type JobError struct {
+	Reason model.FailureReason
+	Err    error
+}
+
+func (e *JobError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+func failure(reason model.FailureReason, format string, a ...any) error {
+	return &JobError{Reason: reason, Err: fmt.Errorf(format, a...)}
+}
+
+// failureReason returns the reason of a failed job, or
+// "unknown" if err does not carry one.
+func failureReason(err error) model.FailureReason {
+	var jobErr *JobError
+	if errors.As(err, &jobErr) {
+		return jobErr.Reason
+	}
+	return model.FailureReason("unknown")
+}
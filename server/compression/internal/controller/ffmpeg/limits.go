@@ -0,0 +1,64 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	compressionModel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// errTimedOut is the cause of the context of an encoding
+// that exceeded its timeout.
+var errTimedOut = errors.New("encoding timed out")
+
+// encodeTimeout returns how long encoding a video of the
+// given duration in seconds may take.
+func encodeTimeout(limits config.EncodeLimits, duration float64) time.Duration {
+	secs := limits.BaseTimeout.Seconds()
+	if duration > 0 {
+		secs += limits.TimeoutFactor * duration
+	}
+	timeout := time.Duration(math.MaxInt64)
+	if secs < timeout.Seconds() {
+		timeout = time.Duration(secs * float64(time.Second))
+	}
+	if limits.MaxTimeout > 0 {
+		timeout = min(timeout, limits.MaxTimeout)
+	}
+	return timeout
+}
+
+// ffmpegCommand returns an ffmpeg command limited by
+// encoding.limits, running in cg. The last argument is the
+// output file.
+func (c *Controller) ffmpegCommand(ctx context.Context, cg *cgroup, args ...string) *exec.Cmd {
+	if threads := c.encoding.Limits.Threads; threads > 0 && len(args) > 0 {
+		output := args[len(args)-1]
+		args = append(args[:len(args)-1:len(args)-1], "-threads", strconv.Itoa(threads), output)
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Dir = c.encoding.WorkDir
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	killProcessGroup(cmd)
+	cg.apply(cmd)
+	return cmd
+}
+
+// encodeFailure returns the failure of an ffmpeg pass,
+// telling a timeout and a memory limit apart from other
+// errors.
+func encodeFailure(ctx context.Context, cg *cgroup, pass string, timeout time.Duration, err error) error {
+	if errors.Is(context.Cause(ctx), errTimedOut) {
+		return failure(compressionModel.FailureReasonTimeout, "ffmpeg pass %s exceeded the timeout of %s: %w", pass, timeout, err)
+	}
+	if cg.oomKilled() {
+		return failure(compressionModel.FailureReasonResourceLimit, "ffmpeg pass %s exceeded the memory limit: %w", pass, err)
+	}
+	return failure(compressionModel.FailureReasonEncode, "error running ffmpeg pass %s %w", pass, err)
+}
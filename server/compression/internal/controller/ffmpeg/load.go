@@ -0,0 +1,61 @@
+package ffmpeg
+
+import (
+	"ffmpeg/wrapper/compression/internal/capacity"
+	"sync"
+	"time"
+)
+
+// defaultEncodeFactor is the time a job is assumed to take
+// per second of video, until the worker encoded a job.
+const defaultEncodeFactor = 1.0
+
+// load tracks the jobs the worker runs, and how long they
+// take per second of video.
+type load struct {
+	mu       sync.Mutex
+	inFlight int
+	// encodeFactor is a moving average over the encoded
+	// jobs.
+	encodeFactor float64
+}
+
+func (l *load) start() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight++
+}
+
+func (l *load) finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+}
+
+// encoded records a job that encoded duration seconds of
+// video in elapsed.
+func (l *load) encoded(duration float64, elapsed time.Duration) {
+	if duration <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.encodeFactor = 0.8*l.encodeFactor + 0.2*elapsed.Seconds()/duration
+}
+
+// Load returns the current work of the worker. It runs one
+// job at a time.
+func (c *Controller) Load() capacity.Load {
+	c.scheduler.mu.Lock()
+	queued, queuedSeconds := c.scheduler.queued, c.scheduler.queuedSeconds
+	c.scheduler.mu.Unlock()
+	c.load.mu.Lock()
+	defer c.load.mu.Unlock()
+	return capacity.Load{
+		Capacity:           1,
+		InFlight:           c.load.inFlight,
+		Queued:             queued,
+		QueuedVideoSeconds: queuedSeconds,
+		EncodeFactor:       c.load.encodeFactor,
+	}
+}
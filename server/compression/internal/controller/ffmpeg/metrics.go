@@ -0,0 +1,74 @@
+package ffmpeg
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	jobsStarted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "compression_jobs_started_total",
+		Help: "Total number of compression jobs started.",
+	})
+	jobsSucceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "compression_jobs_succeeded_total",
+		Help: "Total number of compression jobs that succeeded.",
+	})
+	jobsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "compression_jobs_failed_total",
+		Help: "Total number of compression jobs that failed, by reason.",
+	}, []string{"reason"})
+	jobsCancelled = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "compression_jobs_cancelled_total",
+		Help: "Total number of compression jobs cancelled.",
+	})
+	jobOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "compression_job_outcomes_total",
+		Help: "Total number of compression jobs that succeeded, by how they produced their output.",
+	}, []string{"outcome"})
+	jobsEncoded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "compression_jobs_encoded_total",
+		Help: "Total number of compression jobs encoded, by strategy.",
+	}, []string{"strategy"})
+	strategyFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "compression_strategy_fallbacks_total",
+		Help: "Total number of compression jobs encoded again after a strategy did not suit them, by that strategy.",
+	}, []string{"strategy"})
+	animatedAttempts = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "compression_animated_attempts",
+		Help:    "Number of encodings animated images took to fit the target, by format.",
+		Buckets: prometheus.LinearBuckets(1, 1, 8),
+	}, []string{"format"})
+	segmentsEncoded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "compression_segments_encoded_total",
+		Help: "Total number of segments of chunked jobs encoded by the worker.",
+	})
+	segmentsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "compression_segments_failed_total",
+		Help: "Total number of segments of chunked jobs that failed, by reason.",
+	}, []string{"reason"})
+	jobsQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "compression_jobs_queued",
+		Help: "Number of fetched compression jobs waiting for the worker, by priority.",
+	}, []string{"priority"})
+	encodeSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "compression_encode_duration_seconds",
+		Help:    "Duration of ffmpeg encoding passes.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"pass"})
+	inputBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "compression_input_size_bytes",
+		Help:    "Size of the source videos.",
+		Buckets: prometheus.ExponentialBuckets(1<<20, 2, 12),
+	})
+	outputBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "compression_output_size_bytes",
+		Help:    "Size of the compressed videos.",
+		Buckets: prometheus.LinearBuckets(1<<20, 1<<20, 12),
+	})
+	compressionRatio = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "compression_ratio",
+		Help:    "Ratio of source size to compressed size.",
+		Buckets: []float64{0.5, 1, 1.5, 2, 3, 5, 10, 20, 50, 100},
+	})
+)
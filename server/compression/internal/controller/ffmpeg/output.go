@@ -0,0 +1,102 @@
+package ffmpeg
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// inputArgs returns the ffmpeg options of the source of a
+// job, placed before its -i. ffmpeg rotates the frames of
+// rotated sources when it encodes them, unless told not to.
+// A non-zero rotation replaces that of the source, for
+// segments that may have lost it when split.
+func (c *Controller) inputArgs(rotation int) []string {
+	var args []string
+	if rotation != 0 {
+		args = []string{"-display_rotation", strconv.Itoa(rotation)}
+	}
+	if !c.encoding.Output.NormalizeRotation {
+		args = append(args, "-noautorotate")
+	}
+	return args
+}
+
+// outputArgs returns the ffmpeg options of the final output
+// of a job in the given format, applying encoding.output.
+// They map the first audio stream and the kept subtitles,
+// so the caller maps the video stream.
+func (c *Controller) outputArgs(format string) []string {
+	return slices.Concat([]string{"-map", "0:a:0?"}, c.sourceArgs(0, format), c.containerArgs(format))
+}
+
+// sourceArgs returns the ffmpeg options taking the kept
+// subtitles, chapters and metadata of an output in the
+// given format from its input of the given index.
+func (c *Controller) sourceArgs(input int, format string) []string {
+	o := c.encoding.Output
+	var args []string
+	if o.Subtitles == "keep" {
+		args = append(args, "-map", strconv.Itoa(input)+":s?", "-c:s", subtitleCodec(format))
+	}
+	if o.Chapters == "keep" {
+		args = append(args, "-map_chapters", strconv.Itoa(input))
+	} else {
+		args = append(args, "-map_chapters", "-1")
+	}
+	if o.KeepMetadata {
+		args = append(args, "-map_metadata", strconv.Itoa(input))
+	}
+	return append(args, c.metadataArgs()...)
+}
+
+// metadataArgs returns the ffmpeg options stripping the
+// metadata of the source, unless it is kept.
+func (c *Controller) metadataArgs() []string {
+	if !c.encoding.Output.KeepMetadata {
+		return []string{"-map_metadata", "-1"}
+	}
+	return nil
+}
+
+// containerArgs returns the ffmpeg options of an output in
+// the given format that do not depend on its source.
+func (c *Controller) containerArgs(format string) []string {
+	if c.encoding.Output.Faststart && (format == "mp4" || format == "mov") {
+		return []string{"-movflags", "+faststart"}
+	}
+	return nil
+}
+
+// outputFormat returns the format ffmpeg writes a file in,
+// from its extension.
+func outputFormat(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".webm":
+		return "webm"
+	case ".mkv":
+		return "matroska"
+	case ".mov":
+		return "mov"
+	case ".gif":
+		return "gif"
+	case ".webp":
+		return "webp"
+	default:
+		return "mp4"
+	}
+}
+
+// subtitleCodec returns the codec subtitles are written in
+// to a file in the given format.
+func subtitleCodec(format string) string {
+	switch format {
+	case "webm":
+		return "webvtt"
+	case "matroska":
+		return "copy"
+	default:
+		return "mov_text"
+	}
+}
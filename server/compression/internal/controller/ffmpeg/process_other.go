@@ -0,0 +1,13 @@
+//go:build !unix
+
+package ffmpeg
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as is on platforms without
+// process groups. Only ffmpeg itself is killed when the
+// context of cmd is done.
+func killProcessGroup(cmd *exec.Cmd) {}
+
+// setNice does nothing on platforms without niceness.
+func setNice(cmd *exec.Cmd, nice int) error { return nil }
@@ -0,0 +1,25 @@
+//go:build unix
+
+package ffmpeg
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup runs cmd in a process group of its own,
+// and kills the whole group when the context of cmd is
+// done, so that no child of ffmpeg outlives a cancelled
+// job.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// setNice sets the niceness of the process group of a
+// started cmd.
+func setNice(cmd *exec.Cmd, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PGRP, cmd.Process.Pid, nice)
+}
@@ -0,0 +1,195 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	compressionModel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/scheduling"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// queuedJob is a fetched compression event waiting for
+// the worker.
+type queuedJob struct {
+	reader   *kafka.Reader
+	message  kafka.Message
+	priority compressionModel.Priority
+	// duration is the duration of the video in seconds.
+	duration float64
+}
+
+// partition identifies the partition of a fetched message.
+type partition struct {
+	topic     string
+	partition int
+}
+
+// offsets tracks the fetched messages of a partition that
+// are not committed yet. Jobs finish out of order, so only
+// the offsets up to the first unfinished job are committed.
+type offsets struct {
+	fetched []int64
+	// done counts the finished messages by offset, as a
+	// message may be fetched again after a rebalance.
+	done map[int64]int
+}
+
+// scheduler holds the jobs the worker fetched ahead of
+// time, and hands them out in the order of a fair queue.
+type scheduler struct {
+	prefetch int
+
+	mu    sync.Mutex
+	queue *scheduling.Queue[queuedJob]
+	// queued and queuedSeconds are the number of queued
+	// jobs and the duration of their videos.
+	queued        int
+	queuedSeconds float64
+	offsets       map[partition]*offsets
+	// changed is closed and replaced whenever a job is
+	// queued or taken, to wake up the waiting fetchers and
+	// the worker.
+	changed chan struct{}
+}
+
+func newScheduler(cfg config.Scheduling) *scheduler {
+	return &scheduler{
+		prefetch: cfg.Prefetch,
+		queue:    scheduling.NewQueue[queuedJob](cfg.Weights),
+		offsets:  map[partition]*offsets{},
+		changed:  make(chan struct{}),
+	}
+}
+
+// notify wakes up the waiters. s.mu must be held.
+func (s *scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// fetch queues the jobs read from the topic of priority p
+// until ctx is done, holding at most the prefetch limit of
+// jobs at once.
+func (s *scheduler) fetch(ctx context.Context, p compressionModel.Priority, reader *kafka.Reader) {
+	for {
+		s.mu.Lock()
+		full := s.queue.Len(p) >= s.prefetch
+		changed := s.changed
+		s.mu.Unlock()
+		if full {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+			continue
+		}
+
+		m, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("kafka reading error: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		j := queuedJob{reader: reader, message: m, priority: p}
+		var event struct {
+			JobID    int64  `json:"job_id"`
+			UserID   string `json:"user_id"`
+			Metadata struct {
+				Duration string `json:"duration"`
+			} `json:"metadata"`
+		}
+		s.mu.Lock()
+		s.track(m)
+		queued := json.Unmarshal(m.Value, &event) == nil && event.Metadata.Duration != ""
+		if queued {
+			j.duration, _ = strconv.ParseFloat(event.Metadata.Duration, 64)
+			queued = s.queue.Push(event.JobID, p, event.UserID, j)
+		}
+		if queued {
+			s.queued++
+			s.queuedSeconds += j.duration
+			jobsQueued.WithLabelValues(string(p)).Set(float64(s.queue.Len(p)))
+			s.notify()
+		}
+		s.mu.Unlock()
+		// Messages that are no jobs, and jobs redelivered
+		// while queued, are skipped.
+		if !queued {
+			s.done(context.WithoutCancel(ctx), j)
+		}
+	}
+}
+
+// next returns the job to run next, waiting until one is
+// fetched or ctx is done.
+func (s *scheduler) next(ctx context.Context) (queuedJob, error) {
+	for {
+		s.mu.Lock()
+		j, ok := s.queue.Pop()
+		if ok {
+			s.queued--
+			s.queuedSeconds -= j.duration
+			jobsQueued.WithLabelValues(string(j.priority)).Set(float64(s.queue.Len(j.priority)))
+			s.notify()
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		if ok {
+			return j, nil
+		}
+		select {
+		case <-ctx.Done():
+			return queuedJob{}, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (s *scheduler) track(m kafka.Message) {
+	key := partition{topic: m.Topic, partition: m.Partition}
+	o, ok := s.offsets[key]
+	if !ok {
+		o = &offsets{done: map[int64]int{}}
+		s.offsets[key] = o
+	}
+	o.fetched = append(o.fetched, m.Offset)
+}
+
+// done marks the job finished, and commits the offsets of
+// its partition up to the first unfinished job. Commits are
+// made holding s.mu, so that they do not overtake another.
+func (s *scheduler) done(ctx context.Context, j queuedJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.offsets[partition{topic: j.message.Topic, partition: j.message.Partition}]
+	o.done[j.message.Offset]++
+	commit := int64(-1)
+	for len(o.fetched) > 0 && o.done[o.fetched[0]] > 0 {
+		commit = o.fetched[0]
+		if o.done[commit]--; o.done[commit] == 0 {
+			delete(o.done, commit)
+		}
+		o.fetched = o.fetched[1:]
+	}
+	if commit < 0 {
+		return
+	}
+	m := kafka.Message{Topic: j.message.Topic, Partition: j.message.Partition, Offset: commit}
+	if err := j.reader.CommitMessages(ctx, m); err != nil {
+		log.Printf("failed to commit offset %d of %s/%d: %v", commit, m.Topic, m.Partition, err)
+	}
+}
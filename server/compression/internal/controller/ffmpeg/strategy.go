@@ -0,0 +1,212 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	compressionModel "ffmpeg/wrapper/compression/pkg/model"
+	metadataModel "ffmpeg/wrapper/metadata/pkg/model"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errUnsuited is returned by a strategy that does not suit
+// the job, which is then encoded with the next strategy.
+var errUnsuited = errors.New("strategy unsuited to the job")
+
+// crfBufferSeconds is the size of the rate control buffer
+// of single-pass encoding, in seconds at the capped bitrate.
+const crfBufferSeconds = 2
+
+// remuxVideoCodecs and remuxAudioCodecs are the codecs that
+// are copied into an MP4 file as they are.
+var (
+	remuxVideoCodecs = map[string]bool{"h264": true}
+	remuxAudioCodecs = map[string]bool{"": true, "aac": true, "mp3": true}
+)
+
+// playableFormat is a container Discord plays, by file
+// extension and ffprobe format name, with the codecs it
+// plays in it.
+type playableFormat struct {
+	extension   string
+	format      string
+	videoCodecs map[string]bool
+	audioCodecs map[string]bool
+	// faststart is whether outputs in the format have an
+	// index to move before their media.
+	faststart bool
+}
+
+var playableFormats = []playableFormat{
+	{".mp4", "mp4", remuxVideoCodecs, remuxAudioCodecs, true},
+	{".webm", "webm", map[string]bool{"vp8": true, "vp9": true, "av1": true}, map[string]bool{"": true, "opus": true, "vorbis": true}, false},
+}
+
+// selectStrategy picks how a job is encoded from its output
+// format and the metadata of its source, following
+// encoding.strategy:
+//   - animated for animated images;
+//   - remux if the source fits the target and plays in
+//     Discord, with encoding.skipAlreadySmall, or has codecs
+//     MP4 files carry, with auto;
+//   - chunked if chunking is enabled and the video is long
+//     enough, unless crf is configured;
+//   - crf for videos up to encoding.crf.maxDuration with
+//     auto, and for all of them with crf;
+//   - two_pass otherwise.
+//
+// Rotated sources are not remuxed while rotations are
+// normalized.
+func (c *Controller) selectStrategy(meta metadataModel.Metadata, objectKey string, format compressionModel.OutputFormat, duration float64) compressionModel.Strategy {
+	if format.Animated() {
+		return compressionModel.StrategyAnimated
+	}
+	if c.remuxFormat(meta, objectKey) != "" {
+		return compressionModel.StrategyRemux
+	}
+	return c.encodeStrategy(duration)
+}
+
+// encodeStrategy returns the strategy of a job that is
+// encoded again.
+func (c *Controller) encodeStrategy(duration float64) compressionModel.Strategy {
+	switch {
+	case c.encoding.Strategy == "crf":
+		return compressionModel.StrategyCRF
+	case c.chunkable(duration):
+		return compressionModel.StrategyChunked
+	case c.encoding.Strategy == "auto" && duration <= c.encoding.CRF.MaxDuration.Seconds():
+		return compressionModel.StrategyCRF
+	default:
+		return compressionModel.StrategyTwoPass
+	}
+}
+
+// fallbackStrategy returns the strategy a job is encoded
+// with once strategy did not suit it.
+func (c *Controller) fallbackStrategy(strategy compressionModel.Strategy, duration float64) compressionModel.Strategy {
+	if strategy == compressionModel.StrategyRemux {
+		return c.encodeStrategy(duration)
+	}
+	return compressionModel.StrategyTwoPass
+}
+
+// remuxFormat returns the format the streams of the source
+// are copied into, and an empty string if they are not.
+func (c *Controller) remuxFormat(meta metadataModel.Metadata, objectKey string) string {
+	if !c.fitsTarget(meta) || (c.encoding.Output.NormalizeRotation && meta.Rotation != 0) {
+		return ""
+	}
+	if f, ok := c.alreadySmall(meta, objectKey); ok {
+		return f.format
+	}
+	if c.encoding.Strategy == "auto" && remuxVideoCodecs[meta.VideoCodec] && remuxAudioCodecs[meta.AudioCodec] {
+		return "mp4"
+	}
+	return ""
+}
+
+// alreadySmall returns the format of the source stored as
+// objectKey if it is not encoded again, with
+// encoding.skipAlreadySmall: it fits the target, plays in
+// Discord, and needs no rotation. Such a source is
+// returned or remuxed.
+func (c *Controller) alreadySmall(meta metadataModel.Metadata, objectKey string) (playableFormat, bool) {
+	if !c.encoding.SkipAlreadySmall || !c.fitsTarget(meta) || (c.encoding.Output.NormalizeRotation && meta.Rotation != 0) {
+		return playableFormat{}, false
+	}
+	return c.playableFormat(meta, objectKey)
+}
+
+// returnable reports whether the source stored as objectKey
+// is returned as it is: it is wanted as a video, is already
+// small, and the output options would not change it. As
+// the default options strip metadata, chapters and
+// subtitles, already small sources are remuxed unless
+// encoding.output keeps them all.
+func (c *Controller) returnable(meta metadataModel.Metadata, objectKey string, format compressionModel.OutputFormat) bool {
+	f, ok := c.alreadySmall(meta, objectKey)
+	o := c.encoding.Output
+	return ok && !format.Animated() &&
+		o.KeepMetadata && o.Chapters == "keep" && o.Subtitles == "keep" &&
+		(!o.Faststart || !f.faststart)
+}
+
+// playableFormat returns the format of the source stored as
+// objectKey if Discord plays it as it is.
+func (c *Controller) playableFormat(meta metadataModel.Metadata, objectKey string) (playableFormat, bool) {
+	ext := strings.ToLower(path.Ext(objectKey))
+	formats := strings.Split(meta.FormatName, ",")
+	for _, f := range playableFormats {
+		if ext == f.extension && slices.Contains(formats, f.format) &&
+			f.videoCodecs[meta.VideoCodec] && f.audioCodecs[meta.AudioCodec] {
+			return f, true
+		}
+	}
+	return playableFormat{}, false
+}
+
+func (c *Controller) fitsTarget(meta metadataModel.Metadata) bool {
+	size, err := strconv.ParseInt(meta.Size, 10, 64)
+	return err == nil && size > 0 && size <= c.targetBytes()
+}
+
+// targetBytes returns the size outputs must not exceed.
+func (c *Controller) targetBytes() int64 {
+	return int64((c.encoding.TargetVideoMB + c.encoding.TargetAudioMB) * (1 << 20))
+}
+
+// checkTarget returns errUnsuited if output exceeds the
+// target.
+func (c *Controller) checkTarget(output string) error {
+	fi, err := os.Stat(output)
+	if err != nil {
+		return failure(compressionModel.FailureReasonEncode, "failed to stat the output: %w", err)
+	}
+	if fi.Size() > c.targetBytes() {
+		return fmt.Errorf("%w: the output takes %d bytes, more than the target of %d", errUnsuited, fi.Size(), c.targetBytes())
+	}
+	return nil
+}
+
+// remux copies the first video and audio streams of input
+// into a file of the given format, with the output options.
+func (c *Controller) remux(ctx context.Context, cg *cgroup, timeout time.Duration, input string, format string, output string) error {
+	args := slices.Concat([]string{"-y", "-i", input, "-map", "0:v:0", "-c", "copy"}, c.outputArgs(format),
+		[]string{"-f", format, output})
+	if err := c.runTraced(ctx, "ffmpeg/Remux", c.ffmpegCommand(ctx, cg, args...)); err != nil {
+		return encodeFailure(ctx, cg, "remux", timeout, err)
+	}
+	return c.checkTarget(output)
+}
+
+// encodeCRF encodes input in a single pass at the quality
+// of encoding.crf. The bitrate is capped so that the video
+// fits its target with a full rate control buffer.
+func (c *Controller) encodeCRF(ctx context.Context, cg *cgroup, timeout time.Duration, input string, duration float64, output string) error {
+	videoBitrate, audioBitrate := CalculateBitrates(duration, c.encoding.TargetVideoMB, c.encoding.TargetAudioMB)
+	maxrate := videoBitrate * duration / (duration + crfBufferSeconds)
+	args := slices.Concat([]string{"-y"}, c.inputArgs(0), []string{
+		"-i", input,
+		"-map", "0:v:0",
+		"-c:v", c.encoding.VideoCodec,
+		"-preset", c.encoding.Preset,
+		"-crf", strconv.Itoa(c.encoding.CRF.Quality),
+		"-maxrate", strconv.FormatFloat(maxrate, 'f', 0, 64),
+		"-bufsize", strconv.FormatFloat(maxrate*crfBufferSeconds, 'f', 0, 64),
+		"-c:a", c.encoding.AudioCodec,
+		"-b:a", strconv.FormatFloat(audioBitrate, 'f', 0, 64),
+	}, c.outputArgs(outputFormat(output)), []string{output})
+	cmd := c.ffmpegCommand(ctx, cg, args...)
+	start := time.Now()
+	if err := c.runTraced(ctx, "ffmpeg/CRF", cmd); err != nil {
+		return encodeFailure(ctx, cg, "crf", timeout, err)
+	}
+	encodeSeconds.WithLabelValues("crf").Observe(time.Since(start).Seconds())
+	return c.checkTarget(output)
+}
@@ -0,0 +1,65 @@
+package ffmpeg
+
+import (
+	compressionModel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	metadataModel "ffmpeg/wrapper/metadata/pkg/model"
+	"testing"
+)
+
+func TestSelectStrategyAndOutputKey(t *testing.T) {
+	c := &Controller{encoding: config.Default().Encoding}
+	const (
+		mp4      = "mov,mp4,m4a,3gp,3g2,mj2"
+		matroska = "matroska,webm"
+		small    = "1048576"
+		large    = "104857600"
+	)
+	tests := []struct {
+		name       string
+		objectKey  string
+		meta       metadataModel.Metadata
+		format     compressionModel.OutputFormat
+		wantKey    string
+		wantRemux  string
+		wantChosen compressionModel.Strategy
+	}{
+		{"small mp4", "clip.mp4", metadataModel.Metadata{FormatName: mp4, Size: small, VideoCodec: "h264", AudioCodec: "aac"},
+			compressionModel.OutputFormatVideo, "compressed_clip.mp4", "mp4", compressionModel.StrategyRemux},
+		{"small h264 mov", "clip.mov", metadataModel.Metadata{FormatName: mp4, Size: small, VideoCodec: "h264", AudioCodec: "aac"},
+			compressionModel.OutputFormatVideo, "compressed_clip.mp4", "mp4", compressionModel.StrategyRemux},
+		{"small h264 mkv", "clip.mkv", metadataModel.Metadata{FormatName: matroska, Size: small, VideoCodec: "h264", AudioCodec: "aac"},
+			compressionModel.OutputFormatVideo, "compressed_clip.mp4", "mp4", compressionModel.StrategyRemux},
+		{"small vp9 webm", "clip.webm", metadataModel.Metadata{FormatName: matroska, Size: small, VideoCodec: "vp9", AudioCodec: "opus"},
+			compressionModel.OutputFormatVideo, "compressed_clip.webm", "webm", compressionModel.StrategyRemux},
+		{"small vp9 mkv", "clip.mkv", metadataModel.Metadata{FormatName: matroska, Size: small, VideoCodec: "vp9", AudioCodec: "opus"},
+			compressionModel.OutputFormatVideo, "compressed_clip.mkv", "", compressionModel.StrategyCRF},
+		{"large h264 mov", "clip.mov", metadataModel.Metadata{FormatName: mp4, Size: large, VideoCodec: "h264", AudioCodec: "aac"},
+			compressionModel.OutputFormatVideo, "compressed_clip.mov", "", compressionModel.StrategyCRF},
+		{"rotated h264 mov", "clip.mov", metadataModel.Metadata{FormatName: mp4, Size: small, VideoCodec: "h264", AudioCodec: "aac", Rotation: 90},
+			compressionModel.OutputFormatVideo, "compressed_clip.mov", "", compressionModel.StrategyCRF},
+		{"h264 mkv as gif", "clip.mkv", metadataModel.Metadata{FormatName: matroska, Size: small, VideoCodec: "h264", AudioCodec: "aac"},
+			compressionModel.OutputFormatGIF, "compressed_clip.gif", "", compressionModel.StrategyAnimated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.selectStrategy(tt.meta, tt.objectKey, tt.format, 30); got != tt.wantChosen {
+				t.Errorf("selectStrategy = %s, want %s", got, tt.wantChosen)
+			}
+			key := c.outputKey(tt.meta, tt.objectKey, tt.format)
+			if key != tt.wantKey {
+				t.Errorf("outputKey = %s, want %s", key, tt.wantKey)
+			}
+			if !tt.format.Animated() {
+				if got := c.remuxFormat(tt.meta, tt.objectKey); got != tt.wantRemux {
+					t.Errorf("remuxFormat = %q, want %q", got, tt.wantRemux)
+				}
+			}
+			// A remuxed output is written in the format of
+			// its key.
+			if tt.wantRemux != "" && outputFormat(key) != tt.wantRemux {
+				t.Errorf("output key %s is in format %s, but the source is remuxed into %s", key, outputFormat(key), tt.wantRemux)
+			}
+		})
+	}
+}
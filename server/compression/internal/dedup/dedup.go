@@ -0,0 +1,124 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Store keeps the cached outputs.
+type Store interface {
+	// Get returns the object key cached under key, and
+	// false on a miss.
+	Get(ctx context.Context, key string) (string, bool, error)
+	// Put caches objectKey under key for ttl.
+	Put(ctx context.Context, key string, objectKey string, ttl time.Duration) error
+	// Delete forgets the output cached under key.
+	Delete(ctx context.Context, key string) error
+}
+
+// Cache maps a source video, the encoding settings and the
+// output format to the object key of its compressed output. The outputs are
+// kept in the given store, e.g. Redis to share them between
+// workers. Store errors count as misses, as the job can
+// always be encoded instead.
+type Cache struct {
+	cfg      config.Dedup
+	store    Store
+	settings string
+	// lastError is the time the last store error was
+	// logged, in Unix seconds.
+	lastError atomic.Int64
+}
+
+// New creates a cache of the outputs encoded with enc.
+func New(cfg config.Dedup, store Store, enc config.Encoding) *Cache {
+	return &Cache{cfg: cfg, store: store, settings: Fingerprint(enc)}
+}
+
+// Fingerprint identifies the encoding settings that change
+// the output of a job. Every setting but the work directory
+// and the time and resource limits is listed, so a new
+// setting must be added here.
+func Fingerprint(enc config.Encoding) string {
+	o, a, ch := enc.Output, enc.Animated, enc.Chunking
+	h := sha256.New()
+	for _, v := range []any{
+		enc.TargetVideoMB, enc.TargetAudioMB, enc.VideoCodec, enc.AudioCodec, enc.Preset,
+		enc.SkipAlreadySmall, enc.Strategy, enc.CRF.Quality, enc.CRF.MaxDuration,
+		o.Faststart, o.KeepMetadata, o.Chapters, o.Subtitles, o.NormalizeRotation,
+		a.MaxDuration, a.MaxAttempts, a.FPS, a.MinFPS, a.Width, a.MinWidth,
+		a.Colors, a.MinColors, a.Quality, a.MinQuality,
+		ch.Enabled, ch.MinDuration, ch.SegmentDuration,
+	} {
+		fmt.Fprintf(h, "%#v\n", v)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// key returns the key of the output of a source. Videos
+// have no format in their keys.
+func (c *Cache) key(sourceSHA256 string, format model.OutputFormat) string {
+	key := "dedup:" + sourceSHA256 + ":" + c.settings
+	if format.Animated() {
+		key += ":" + string(format)
+	}
+	return key
+}
+
+// Get returns the output in the given format of a source
+// with the given hash, and false on a miss or without a
+// hash.
+func (c *Cache) Get(ctx context.Context, sourceSHA256 string, format model.OutputFormat) (string, bool) {
+	if !c.cfg.Enabled || sourceSHA256 == "" {
+		return "", false
+	}
+	objectKey, ok, err := c.store.Get(ctx, c.key(sourceSHA256, format))
+	if err != nil {
+		c.logError(err)
+		return "", false
+	}
+	if ok {
+		lookups.WithLabelValues("hit").Inc()
+	} else {
+		lookups.WithLabelValues("miss").Inc()
+	}
+	return objectKey, ok
+}
+
+// Put records objectKey as the output in the given format
+// of the source with the given hash.
+func (c *Cache) Put(ctx context.Context, sourceSHA256 string, format model.OutputFormat, objectKey string) {
+	if !c.cfg.Enabled || sourceSHA256 == "" {
+		return
+	}
+	if err := c.store.Put(ctx, c.key(sourceSHA256, format), objectKey, c.cfg.TTL); err != nil {
+		c.logError(err)
+	}
+}
+
+// Stale forgets the output in the given format of the
+// source with the given hash, once the output turned out
+// to be deleted.
+func (c *Cache) Stale(ctx context.Context, sourceSHA256 string, format model.OutputFormat) {
+	if !c.cfg.Enabled || sourceSHA256 == "" {
+		return
+	}
+	lookups.WithLabelValues("stale").Inc()
+	if err := c.store.Delete(ctx, c.key(sourceSHA256, format)); err != nil {
+		c.logError(err)
+	}
+}
+
+func (c *Cache) logError(err error) {
+	now := time.Now().Unix()
+	if last := c.lastError.Load(); now-last >= 60 && c.lastError.CompareAndSwap(last, now) {
+		log.Printf("dedup cache failed, encoding without it: %v", err)
+	}
+}
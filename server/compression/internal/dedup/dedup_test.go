@@ -0,0 +1,72 @@
+package dedup
+
+import (
+	"ffmpeg/wrapper/internal/config"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFingerprint changes every setting of the encoding in
+// turn, and checks that only the work directory and the
+// limits leave the fingerprint unchanged.
+func TestFingerprint(t *testing.T) {
+	base := config.Default().Encoding
+	want := Fingerprint(base)
+	if got := Fingerprint(base); got != want {
+		t.Fatalf("Fingerprint is not stable: %s != %s", got, want)
+	}
+	for _, path := range leafFields(reflect.TypeOf(base), "") {
+		t.Run(path, func(t *testing.T) {
+			enc := base
+			change(t, reflect.ValueOf(&enc).Elem(), path)
+			unchanged := Fingerprint(enc) == want
+			ignored := path == "WorkDir" || strings.HasPrefix(path, "Limits.")
+			switch {
+			case ignored && !unchanged:
+				t.Errorf("changing %s changed the fingerprint", path)
+			case !ignored && unchanged:
+				t.Errorf("changing %s did not change the fingerprint", path)
+			}
+		})
+	}
+}
+
+// leafFields returns the paths of the fields of typ that
+// are not structs, such as "Output.Chapters".
+func leafFields(typ reflect.Type, prefix string) []string {
+	var paths []string
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			paths = append(paths, leafFields(f.Type, prefix+f.Name+".")...)
+		} else {
+			paths = append(paths, prefix+f.Name)
+		}
+	}
+	return paths
+}
+
+// change sets the field at path of v to another value.
+func change(t *testing.T, v reflect.Value, path string) {
+	for _, name := range strings.Split(path, ".") {
+		v = v.FieldByName(name)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(!v.Bool())
+	case reflect.String:
+		v.SetString(v.String() + "x")
+	case reflect.Int, reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			v.SetInt(v.Int() + int64(time.Second))
+		} else {
+			v.SetInt(v.Int() + 1)
+		}
+	case reflect.Float64:
+		v.SetFloat(v.Float() + 1)
+	default:
+		t.Fatalf("cannot change %s of kind %s", path, v.Kind())
+	}
+}
@@ -0,0 +1,13 @@
+package dedup
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// lookups counts the cache lookups of jobs, by result:
+// hit, miss, or stale for hits whose output was deleted.
+var lookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "compression_dedup_lookups_total",
+	Help: "Total number of dedup cache lookups, by result.",
+}, []string{"result"})
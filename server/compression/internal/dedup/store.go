@@ -0,0 +1,104 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps the cached outputs in Redis, shared by
+// the compression workers.
+type RedisStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+// NewRedisStore creates a store on the given Redis client.
+// Each command is bounded by timeout.
+func NewRedisStore(client *redis.Client, timeout time.Duration) *RedisStore {
+	return &RedisStore{client: client, timeout: timeout}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	objectKey, err := s.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return objectKey, true, nil
+}
+
+func (s *RedisStore) Put(ctx context.Context, key string, objectKey string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.client.Set(ctx, key, objectKey, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.client.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// MemoryStore keeps the cached outputs of a single worker
+// in memory.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	objectKey string
+	expires   time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]entry)}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		delete(s.entries, key)
+		return "", false, nil
+	}
+	return e.objectKey, true, nil
+}
+
+func (s *MemoryStore) Put(_ context.Context, key string, objectKey string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// Expired entries are dropped on writes, so that the
+	// map does not grow with sources never seen again.
+	for k, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = entry{objectKey: objectKey, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
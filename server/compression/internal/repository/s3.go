@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -63,7 +66,7 @@ func (p S3) PutObject(ctx context.Context, bucketname string, objectKey string,
 	request, err := p.PresignClient.PresignPutObject(ctx, &s3.PutObjectInput{
 		Bucket:      aws.String(bucketname),
 		Key:         aws.String(objectKey),
-		ContentType: aws.String("video/mp4"),
+		ContentType: aws.String(contentType(objectKey)),
 	})
 	if err != nil {
 		log.Printf("Couldn't get a presigned request to put %v:%v. Here's why: %v\n",
@@ -86,12 +89,16 @@ func (presigner S3) DeleteObject(ctx context.Context, bucketName string, objectK
 	return request, err
 }
 
-func (p S3) DownloadObject(ctx context.Context, bucketName string, objectKey string, filename string) (string, error) {
+// DownloadObject downloads an object from a bucket to the
+// given local file path and returns the path.
+func (p S3) DownloadObject(ctx context.Context, bucketName string, objectKey string, filePath string) (string, error) {
+	_, span := otel.Tracer(tracerID).Start(ctx, "Repository/DownloadObject")
+	defer span.End()
 	result, err := p.S3Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket:                     aws.String(bucketName),
 		Key:                        aws.String(objectKey),
-		ResponseContentType:        aws.String("video/mp4"),
-		ResponseContentDisposition: aws.String("attachment; filename=\"" + filename + "\""),
+		ResponseContentType:        aws.String(contentType(objectKey)),
+		ResponseContentDisposition: aws.String("attachment; filename=\"" + filepath.Base(filePath) + "\""),
 	})
 
 	if err != nil {
@@ -107,8 +114,7 @@ func (p S3) DownloadObject(ctx context.Context, bucketName string, objectKey str
 
 	defer result.Body.Close()
 
-	tempFilePath := "/tmp/" + filename
-	file, err := os.Create(tempFilePath)
+	file, err := os.Create(filePath)
 	if err != nil {
 		log.Printf("Couldn't create file %v. Here's why: %v\n", objectKey, err)
 		return "", err
@@ -123,7 +129,7 @@ func (p S3) DownloadObject(ctx context.Context, bucketName string, objectKey str
 		log.Printf("failed to write file")
 		return "", err
 	}
-	return tempFilePath, nil
+	return filePath, nil
 }
 
 func (p S3) DownloadPartialObject(ctx context.Context, bucketName string, objectKey string, filename string, byteLimit int64) (string, error) {
@@ -177,8 +183,8 @@ func (p S3) UploadObject(ctx context.Context, bucketName string, objectKey strin
 		Bucket:             aws.String(bucketName),
 		Key:                aws.String(objectKey),
 		Body:               f,
-		ContentType:        aws.String("video/mp4"),
-		ContentDisposition: aws.String("attachment; filename=\"" + filename + "\""),
+		ContentType:        aws.String(contentType(objectKey)),
+		ContentDisposition: aws.String("attachment; filename=\"" + filepath.Base(filename) + "\""),
 	})
 
 	if err != nil {
@@ -194,3 +200,57 @@ func (p S3) UploadObject(ctx context.Context, bucketName string, objectKey strin
 	log.Println(result)
 	return nil
 }
+
+// CopyObject copies an object within a bucket, so that the
+// copy is retained and downloaded as a file of its own.
+func (p S3) CopyObject(ctx context.Context, bucketName string, srcKey string, dstKey string, filename string) error {
+	_, span := otel.Tracer(tracerID).Start(ctx, "Repository/CopyObject")
+	defer span.End()
+	source := (&url.URL{Path: bucketName + "/" + srcKey}).EscapedPath()
+	_, err := p.S3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:             aws.String(bucketName),
+		Key:                aws.String(dstKey),
+		CopySource:         aws.String(source),
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		ContentType:        aws.String(contentType(dstKey)),
+		ContentDisposition: aws.String("attachment; filename=\"" + filepath.Base(filename) + "\""),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// RemoveObject deletes an object from a bucket. Deleting a
+// missing object succeeds.
+func (p S3) RemoveObject(ctx context.Context, bucketName string, objectKey string) error {
+	_, span := otel.Tracer(tracerID).Start(ctx, "Repository/RemoveObject")
+	defer span.End()
+	_, err := p.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", objectKey, err)
+	}
+	return nil
+}
+
+// contentType returns the media type of an object, from
+// the extension of its key.
+func contentType(key string) string {
+	switch strings.ToLower(filepath.Ext(key)) {
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	case ".webm":
+		return "video/webm"
+	case ".mov":
+		return "video/quicktime"
+	case ".mkv":
+		return "video/x-matroska"
+	default:
+		return "video/mp4"
+	}
+}
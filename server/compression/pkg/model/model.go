@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type Duration float64
 type VideoLink string
@@ -13,6 +16,149 @@ type CompressionResultEvent struct {
 	ObjectKey            string                   `json:"object_key"`
 	PresignedDownloadUrl *PresignedRequestPayload `json:"presigned_download_url"`
 	Expiry               time.Time                `json:"expiry_date"`
+	// UserID is the user the job was submitted by.
+	UserID string `json:"user_id,omitempty"`
+	// Callback is copied from the job, for the webhook
+	// dispatcher of the video service.
+	Callback *Callback `json:"callback,omitempty"`
+	// JobType is copied from the job. Events published
+	// before job types have none.
+	JobType JobType `json:"job_type,omitempty"`
+	// Strategy is how a succeeded job was encoded. Outputs
+	// that were not encoded have none.
+	Strategy Strategy `json:"strategy,omitempty"`
+	// Outcome is how a succeeded job produced its output.
+	Outcome Outcome `json:"outcome,omitempty"`
+	// Reason is why a failed job failed.
+	Reason FailureReason `json:"reason,omitempty"`
+}
+
+// Outcome is how a succeeded job produced its output.
+type Outcome string
+
+const (
+	// OutcomeCompressed is a source that was encoded.
+	OutcomeCompressed = Outcome("compressed")
+	// OutcomeReused is the output of an identical source.
+	OutcomeReused = Outcome("reused")
+	// OutcomeSkippedAlreadySmall is a source that already
+	// fit the target and played in Discord, returned as it
+	// was or with its streams copied into an MP4 file.
+	OutcomeSkippedAlreadySmall = Outcome("skipped_already_small")
+)
+
+// Strategy is how a job is encoded.
+type Strategy string
+
+const (
+	// StrategyTwoPass encodes at the target bitrate in two
+	// passes.
+	StrategyTwoPass = Strategy("two_pass")
+	// StrategyChunked encodes in two passes, split into
+	// segments encoded by several workers.
+	StrategyChunked = Strategy("chunked")
+	// StrategyCRF encodes in a single pass at a constant
+	// quality, capped at the target bitrate.
+	StrategyCRF = Strategy("crf")
+	// StrategyRemux copies the streams of a source that fits
+	// the target into an MP4 file.
+	StrategyRemux = Strategy("remux")
+	// StrategyAnimated encodes into an animated image, with
+	// lower frame rates, widths and qualities until it fits
+	// the target.
+	StrategyAnimated = Strategy("animated")
+)
+
+// OutputFormat is the kind of file a job produces.
+type OutputFormat string
+
+const (
+	// OutputFormatVideo is a video in the container of its
+	// source. It is the format of jobs published without
+	// one.
+	OutputFormatVideo = OutputFormat("video")
+	// OutputFormatGIF is an animated GIF image, without
+	// audio.
+	OutputFormatGIF = OutputFormat("gif")
+	// OutputFormatWebP is an animated WebP image, without
+	// audio.
+	OutputFormatWebP = OutputFormat("webp")
+)
+
+// OutputFormats lists the output formats.
+var OutputFormats = []OutputFormat{OutputFormatVideo, OutputFormatGIF, OutputFormatWebP}
+
+// ParseOutputFormat returns the output format named s,
+// video for an empty s, and false for unknown names.
+func ParseOutputFormat(s string) (OutputFormat, bool) {
+	if s == "" {
+		return OutputFormatVideo, true
+	}
+	for _, f := range OutputFormats {
+		if string(f) == s {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Animated reports whether f is an animated image.
+func (f OutputFormat) Animated() bool {
+	return f == OutputFormatGIF || f == OutputFormatWebP
+}
+
+// Extension returns the file extension of outputs in the
+// format, or an empty string if they keep the extension of
+// their source.
+func (f OutputFormat) Extension() string {
+	if f.Animated() {
+		return "." + string(f)
+	}
+	return ""
+}
+
+// JobType is the kind of work a job does. The retention of
+// the objects of a job depends on it.
+type JobType string
+
+const (
+	JobTypeCompress = JobType("compress")
+)
+
+// Priority orders the jobs waiting for a worker. Each
+// priority is published to a topic of its own.
+type Priority string
+
+const (
+	// PriorityPremium is for the jobs of premium users.
+	PriorityPremium = Priority("premium")
+	// PriorityInteractive is for jobs a user waits for. It
+	// is the priority of jobs published without one.
+	PriorityInteractive = Priority("interactive")
+	// PriorityBatch is for jobs nobody waits for.
+	PriorityBatch = Priority("batch")
+)
+
+// Priorities lists the job priorities.
+var Priorities = []Priority{PriorityPremium, PriorityInteractive, PriorityBatch}
+
+// ParsePriority returns the priority named s, interactive
+// for an empty s, and false for unknown names.
+func ParsePriority(s string) (Priority, bool) {
+	if s == "" {
+		return PriorityInteractive, true
+	}
+	for _, p := range Priorities {
+		if string(p) == s {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// Callback is the webhook notified of the result of a job.
+type Callback struct {
+	URL string `json:"url"`
 }
 
 type CompressionEventType string
@@ -21,10 +167,83 @@ const (
 	CompressionEventTypeSuccess    = CompressionEventType("success")
 	CompressionEventTypeFail       = CompressionEventType("fail")
 	CompressionEventTypeProcessing = CompressionEventType("processing")
+	CompressionEventTypeCancelled  = CompressionEventType("cancelled")
 )
 
+// CancellationEvent asks the compression workers to stop a
+// job. Every worker reads every cancellation, as any of
+// them may be running or later receive the job.
+type CancellationEvent struct {
+	JobID int64 `json:"job_id"`
+	// UserID is the user that cancelled the job.
+	UserID      string    `json:"user_id,omitempty"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// SegmentEvent asks a compression worker to encode a
+// segment of a chunked job. Segments are encoded without
+// audio, which the worker splitting the job encodes once.
+type SegmentEvent struct {
+	JobID int64 `json:"job_id"`
+	// Prefix is where the segments of the job are stored,
+	// unique to each attempt at the job.
+	Prefix   string  `json:"prefix"`
+	Index    int     `json:"index"`
+	Duration float64 `json:"duration"`
+	// TargetVideoMB is the share of the video target of
+	// the job that the segment may use.
+	TargetVideoMB float64 `json:"target_video_mb"`
+	// Rotation is the rotation of the source, which its
+	// segments may lose when split.
+	Rotation int `json:"rotation,omitempty"`
+}
+
+// SourceKey returns the key of the source of the segment.
+func (e SegmentEvent) SourceKey() string {
+	return fmt.Sprintf("%s/source_%04d.mkv", e.Prefix, e.Index)
+}
+
+// OutputKey returns the key of the encoded segment.
+func (e SegmentEvent) OutputKey() string {
+	return fmt.Sprintf("%s/encoded_%04d.mp4", e.Prefix, e.Index)
+}
+
+// SegmentResultEvent reports an encoded segment to the
+// worker that split the job.
+type SegmentResultEvent struct {
+	JobID  int64  `json:"job_id"`
+	Prefix string `json:"prefix"`
+	Index  int    `json:"index"`
+	// Reason is set if the segment failed.
+	Reason FailureReason `json:"reason,omitempty"`
+}
+
 type PresignedRequestPayload struct {
 	URL     string            `json:"url"`
 	Method  string            `json:"method"`
 	Headers map[string]string `json:"headers"`
 }
+
+// FailureReason describes why a compression job failed.
+type FailureReason string
+
+const (
+	FailureReasonInvalidEvent = FailureReason("invalid_event")
+	FailureReasonDownload     = FailureReason("download")
+	FailureReasonEncode       = FailureReason("encode")
+	FailureReasonUpload       = FailureReason("upload")
+	FailureReasonPresign      = FailureReason("presign")
+	// FailureReasonTimeout is a job whose encoding took
+	// longer than its timeout.
+	FailureReasonTimeout = FailureReason("timeout")
+	// FailureReasonResourceLimit is a job whose ffmpeg was
+	// killed for using more memory than allowed.
+	FailureReasonResourceLimit = FailureReason("resource_limit")
+	// FailureReasonTooLarge is a job whose output does not
+	// fit the target even at the lowest settings, such as
+	// a long video encoded into an animated image.
+	FailureReasonTooLarge = FailureReason("too_large")
+	// FailureReasonInterrupted is a job still running when
+	// its worker stopped draining.
+	FailureReasonInterrupted = FailureReason("interrupted")
+)
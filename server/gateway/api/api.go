@@ -0,0 +1,10 @@
+// Package api holds the OpenAPI document of the gateway
+// REST API.
+package api
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3 document of the /v1 API.
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
@@ -1,23 +0,0 @@
-package main
-
-type configuration struct {
-	API              apiConfig              `yaml:"api"`
-	ServiceDiscovery serviceDiscoveryConfig `yaml:"serviceDiscovery"`
-	Jaeger           jaegerConfig           `yaml:"jaeger"`
-}
-
-type apiConfig struct {
-	Port int `yaml:"port"`
-}
-
-type serviceDiscoveryConfig struct {
-	Consul consulConfig `yaml:"consul"`
-}
-
-type consulConfig struct {
-	Address string `yaml:"address"`
-}
-
-type jaegerConfig struct {
-	URL string `yaml:"url"`
-}
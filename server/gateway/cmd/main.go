@@ -2,30 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
+	"ffmpeg/wrapper/gateway/internal/auth"
 	"ffmpeg/wrapper/gateway/internal/controller"
 	"ffmpeg/wrapper/gateway/internal/handler"
-	"ffmpeg/wrapper/gateway/internal/repository"
+	"ffmpeg/wrapper/gateway/internal/quota"
+	"ffmpeg/wrapper/gateway/internal/ratelimit"
 	"ffmpeg/wrapper/gen"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/discoveryutil"
 	"ffmpeg/wrapper/internal/grpcutil"
+	"ffmpeg/wrapper/internal/lifecycle"
+	"ffmpeg/wrapper/internal/metrics"
 	"ffmpeg/wrapper/pkg/discovery"
-	"ffmpeg/wrapper/pkg/discovery/consul"
 	"ffmpeg/wrapper/pkg/discovery/tracing"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/cors"
 	"github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 	"go.uber.org/zap"
-	"gopkg.in/yaml.v3"
 )
 
 const serviceName = "browser"
@@ -34,110 +36,167 @@ func main() {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	f, err := os.Open("../configs/default.yaml")
-	if err != nil {
-		logger.Fatal("Failed to open configuration", zap.Error(err))
-	}
-	var cfg configuration
-	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
-		logger.Fatal("Failed to parse configuration", zap.Error(err))
+	cfg, err := config.Load("gateway", os.Args[1:], config.RequireMetrics, config.RequireKafka, config.RequireAuth, config.RequireRateLimit)
+	if errors.Is(err, config.ErrConfigPrinted) {
+		return
+	} else if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 	port := cfg.API.Port
 	logger.Info("Starting the metadata service", zap.Int("port", port))
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	lc := lifecycle.New(logger, cfg.Limits.ShutdownTimeout)
+	ctx := lc.Context()
 
 	tp, err := tracing.NewJaegerProvider(ctx, cfg.Jaeger.URL, serviceName)
 	if err != nil {
 		logger.Fatal("Failed to initialize Jaeger provider", zap.Error(err))
 	}
-	defer func() {
-		if err := tp.Shutdown(ctx); err != nil {
-			logger.Fatal("Failed to shutdown Jaeger provider", zap.Error(err))
-		}
-	}()
+	lc.OnShutdown("tracing", 0, tp.Shutdown)
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(propagation.TraceContext{})
 
 	instanceID := discovery.GenerateInstanceID(serviceName)
+	metricsInstanceID := discovery.GenerateInstanceID(serviceName + "-metrics")
 
-	registry, err := consul.NewRegistry(cfg.ServiceDiscovery.Consul.Address)
+	registry, err := discoveryutil.NewRegistry(lc, cfg.ServiceDiscovery)
 	if err != nil {
 		panic(err)
 	}
 
-	if err := registry.Register(ctx, instanceID, serviceName, fmt.Sprintf("gateway:%d", port)); err != nil {
+	if err := registry.Register(ctx, instanceID, serviceName, cfg.ServiceDiscovery.HostPort("gateway", port), cfg.ServiceDiscovery.RegisterOptions()...); err != nil {
+		panic(err)
+	}
+	if err := registry.Register(ctx, metricsInstanceID, serviceName+"-metrics", cfg.ServiceDiscovery.HostPort("gateway", cfg.Prometheus.MetricsPort),
+		append(cfg.ServiceDiscovery.RegisterOptions(), discovery.WithTags("metrics"))...); err != nil {
 		panic(err)
 	}
 
-	go func() {
+	lc.OnShutdown("service discovery", 0, func(ctx context.Context) error {
+		return errors.Join(
+			registry.Deregister(ctx, instanceID, serviceName),
+			registry.Deregister(ctx, metricsInstanceID, serviceName+"-metrics"),
+		)
+	})
+	lc.Go("health reporting", 0, func(ctx context.Context) error {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
 		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
 			if err := registry.ReportHealthyState(instanceID, serviceName); err != nil {
 				logger.Error("Failed to report healthy state", zap.Error(err))
 			}
-			time.Sleep(1 * time.Second)
+			if err := registry.ReportHealthyState(metricsInstanceID, serviceName+"-metrics"); err != nil {
+				logger.Error("Failed to report healthy state", zap.Error(err))
+			}
 		}
-	}()
-	defer registry.Deregister(ctx, instanceID, serviceName)
-	conn, err := grpcutil.ServiceConnection(ctx, "video", registry)
+	})
+	pool, err := grpcutil.NewPool(registry, cfg.GRPC.Client)
+	if err != nil {
+		logger.Fatal("Failed to create connection pool", zap.Error(err))
+	}
+	lc.OnShutdown("grpc connection pool", 0, func(context.Context) error { return pool.Close() })
+	conn, err := pool.Conn("video")
 	if err != nil {
 		logger.Fatal("Failed to connect to VideoService", zap.Error(err))
 	}
-	defer conn.Close()
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:   []string{os.Getenv("kafkaBroker")},
-		Topic:     "compression-job",
-		Partition: 0,
-		MaxBytes:  10e6,
+	var limitStore ratelimit.Store
+	var quotaStore quota.Store
+	if cfg.Redis.Address != "" {
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:         cfg.Redis.Address,
+			Password:     cfg.Redis.Password,
+			DB:           cfg.Redis.DB,
+			DialTimeout:  cfg.Redis.Timeout,
+			ReadTimeout:  cfg.Redis.Timeout,
+			WriteTimeout: cfg.Redis.Timeout,
+		})
+		lc.OnShutdown("redis client", 0, func(context.Context) error { return redisClient.Close() })
+		limitStore = ratelimit.NewRedisStore(redisClient, cfg.Redis.Timeout)
+		quotaStore = quota.NewRedisStore(redisClient, cfg.Redis.Timeout)
+	} else {
+		if cfg.RateLimit.Enabled {
+			logger.Warn("No Redis configured, rate limits are enforced per gateway instance")
+		}
+		logger.Warn("No Redis configured, quotas and job owners are kept in memory and only one replica may run")
+		memoryStore := quota.NewMemoryStore()
+		lc.Go("quota pruning", 0, memoryStore.Run)
+		quotaStore = memoryStore
+	}
+	quotas := quota.New(cfg.Auth.Quotas, quotaStore)
+
+	// The replicas share a consumer group, so that each
+	// result frees the slot of its job once.
+	resultReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     cfg.Kafka.Brokers,
+		Topic:       cfg.Kafka.Topics.Results,
+		GroupID:     cfg.Auth.Quotas.ConsumerGroup,
+		MaxBytes:    10e6,
+		StartOffset: kafka.LastOffset,
 	})
-	var accountId = os.Getenv("accountId")
-	var accessKeyId = os.Getenv("accessKeyId")
-	var accessKey = os.Getenv("secretKey")
+	lc.OnShutdown("kafka results reader", 0, func(context.Context) error { return resultReader.Close() })
+	prometheus.MustRegister(metrics.NewKafkaReaderCollector(cfg.Auth.Quotas.ConsumerGroup, resultReader))
+	lc.Go("kafka results consumer", 0, func(ctx context.Context) error { return quotas.Consume(ctx, resultReader) })
 
-	AWScfg, err := config.LoadDefaultConfig(ctx,
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyId, accessKey, "")),
-		config.WithRegion("auto"),
-	)
+	ctrl := controller.NewVideoGatewayController(gen.NewVideoServiceClient(conn), quotas, cfg)
+	h, err := handler.NewHandler(ctx, ctrl)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("Failed to register the VideoService proxy", zap.Error(err))
 	}
-	s3Client := s3.NewFromConfig(AWScfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountId))
-		o.UsePathStyle = true
-	})
 
-	repo := repository.New(s3Client)
-	ctrl := controller.NewVideoGatewayController(gen.NewVideoServiceClient(conn))
-	h := handler.NewHandler(ctrl, reader, repo)
-
-	mux := http.NewServeMux()
+	authn, err := auth.New(cfg.Auth, cfg.CORS.AllowedOrigins)
+	if err != nil {
+		logger.Fatal("Failed to configure authentication", zap.Error(err))
+	}
+	if !cfg.Auth.Required {
+		logger.Warn("Authentication is not required, anonymous requests share the anonymous user's quotas")
+	}
 
-	// handleFunc := func(pattern string, handlerFunc func(http.ResponseWriter, *http.Request)) {
-	// 	handler := otelhttp.WithMetricAttributesFn(http.HandlerFunc(handlerFunc))
-	// 	mux.Handle(pattern, handler)
-	// }
+	limiter, err := ratelimit.New(cfg.RateLimit, limitStore)
+	if err != nil {
+		logger.Fatal("Failed to configure rate limits", zap.Error(err))
+	}
+	lc.Go("rate limit pruning", 0, limiter.Run)
 
-	// handleFunc("/upload", h.PostUploadURL)
-	// handleFunc("/jobs/status", h.GetJobStatus)
-	// handleFunc("/jobs/upload", h.PostUploadStatus)
+	// api limits a route per client IP before authenticating
+	// it and per user after.
+	api := func(cost int, h http.Handler) http.Handler {
+		return limiter.PerIP(cost, authn.Middleware(limiter.PerUser(cost, h)))
+	}
 
-	mux.Handle("/upload", http.HandlerFunc(h.PostUploadURL))
-	mux.Handle("/jobs/status", http.HandlerFunc(h.GetJobStatus))
-	mux.Handle("/jobs/upload", http.HandlerFunc(h.PostUploadStatus))
+	mux := handler.NewMux(h, authn,
+		func(h http.Handler) http.Handler { return limiter.PerIP(1, h) },
+		func(h http.Handler) http.Handler { return api(1, h) },
+	)
 
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"http://127.0.0.1:5173", "http://localhost:5173"},
-		AllowedMethods:   []string{"GET", "PUT", "POST", "OPTIONS"},
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
+		ExposedHeaders:   []string{"Retry-After"},
 		AllowCredentials: true,
 		AllowedHeaders:   []string{"*"},
 	})
 
 	// handlerWithInstruments := otelhttp.NewHandler(mux, "/")
 	// handlerWithCORS := c.Handler(handlerWithInstruments)
-	handlerWithCORS := c.Handler(mux)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), handlerWithCORS); err != nil {
-		logger.Fatal("Failed to startup listen and serve", zap.Error(err))
+	handlerWithCORS := c.Handler(tracing.HTTPMiddleware(serviceName, metrics.HTTPMiddleware(mux)))
+
+	metricsSrv := metrics.NewServer(fmt.Sprintf(":%d", cfg.Prometheus.MetricsPort))
+	lc.Serve("metrics server", metricsSrv.ListenAndServe, lifecycle.HTTPStopper(metricsSrv))
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", cfg.API.Host, port),
+		Handler: handlerWithCORS,
+	}
+	lc.Serve("http server", srv.ListenAndServe, lifecycle.HTTPStopper(srv))
+
+	if err := lc.Wait(); err != nil {
+		logger.Fatal("Gateway stopped", zap.Error(err))
 	}
+	logger.Info("Gateway stopped")
 }
@@ -0,0 +1,97 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Code is a stable, machine-readable error code. Clients
+// should branch on the code, never on the message.
+type Code string
+
+const (
+	CodeInvalidRequest      = Code("invalid_request")
+	CodeValidationFailed    = Code("validation_failed")
+	CodeUnauthenticated     = Code("unauthenticated")
+	CodeNotFound            = Code("not_found")
+	CodeMethodNotAllowed    = Code("method_not_allowed")
+	CodeConflict            = Code("conflict")
+	CodePayloadTooLarge     = Code("payload_too_large")
+	CodeQuotaExceeded       = Code("quota_exceeded")
+	CodeRateLimited         = Code("rate_limited")
+	CodeUpstreamUnavailable = Code("upstream_unavailable")
+	CodeInternal            = Code("internal")
+)
+
+// FieldError describes an invalid request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is the error body of every API response, sent as
+// {"error": {...}}.
+type Error struct {
+	Status  int          `json:"-"`
+	Code    Code         `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	// RetryAfter is in seconds, and also sent as the
+	// Retry-After header.
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// New creates an error with the given status and code.
+func New(status int, code Code, format string, a ...any) *Error {
+	return &Error{Status: status, Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Write sends err as the JSON error body.
+func Write(w http.ResponseWriter, err *Error) {
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(err.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(err.Status)
+	json.NewEncoder(w).Encode(struct {
+		Error *Error `json:"error"`
+	}{err})
+}
+
+// FromGRPC converts the error of a gRPC call to an API
+// error. Internal details are logged, not sent.
+func FromGRPC(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.InvalidArgument:
+		return New(http.StatusBadRequest, CodeValidationFailed, "%s", st.Message())
+	case codes.NotFound:
+		return New(http.StatusNotFound, CodeNotFound, "%s", st.Message())
+	case codes.FailedPrecondition:
+		return New(http.StatusConflict, CodeConflict, "%s", st.Message())
+	case codes.ResourceExhausted:
+		return New(http.StatusTooManyRequests, CodeQuotaExceeded, "%s", st.Message())
+	case codes.Unavailable, codes.DeadlineExceeded:
+		log.Printf("upstream call failed: %v", err)
+		return New(http.StatusServiceUnavailable, CodeUpstreamUnavailable, "a backend service is unavailable, try again later")
+	default:
+		log.Printf("upstream call failed: %v", err)
+		return New(http.StatusInternalServerError, CodeInternal, "internal error")
+	}
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"ffmpeg/wrapper/gateway/internal/apierror"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/user"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidKey is returned for requests carrying an API
+// key that is not configured.
+var ErrInvalidKey = errors.New("invalid API key")
+
+// Authenticator identifies the user of gateway requests,
+// either by an API key sent as "Authorization: Bearer
+// <key>" or "X-API-Key: <key>", or by the session cookie
+// set after logging in with Discord.
+type Authenticator struct {
+	required bool
+	keys     []apiKey
+	sessions *sessions
+	discord  *discord
+}
+
+type apiKey struct {
+	userID string
+	hash   []byte
+}
+
+// New creates an authenticator from the auth configuration.
+// allowedOrigins are the origins the Discord login may
+// redirect back to.
+func New(cfg config.Auth, allowedOrigins []string) (*Authenticator, error) {
+	a := &Authenticator{required: cfg.Required}
+	for i, k := range cfg.APIKeys {
+		userID, h, _ := strings.Cut(k, ":")
+		hash, err := hex.DecodeString(h)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("auth.apiKeys[%d]: invalid SHA-256", i)
+		}
+		a.keys = append(a.keys, apiKey{userID: userID, hash: hash})
+	}
+	if cfg.SessionSecret != "" {
+		a.sessions = &sessions{secret: []byte(cfg.SessionSecret), lifetime: cfg.SessionLifetime}
+	}
+	if cfg.Discord.ClientID != "" {
+		if a.sessions == nil {
+			return nil, errors.New("auth.sessionSecret: must be set to log in with Discord")
+		}
+		a.discord = newDiscord(cfg.Discord, a.sessions, allowedOrigins)
+	}
+	return a, nil
+}
+
+// Prefix is the path the auth endpoints are served under.
+const Prefix = "/v1/auth"
+
+// RegisterRoutes registers the login, logout and current
+// user endpoints on mux under Prefix, each wrapped by
+// middleware.
+func (a *Authenticator) RegisterRoutes(mux *http.ServeMux, middleware func(http.Handler) http.Handler) {
+	if a.discord != nil {
+		mux.Handle("GET "+Prefix+"/discord/login", middleware(http.HandlerFunc(a.discord.login)))
+		mux.Handle("GET "+Prefix+"/discord/callback", middleware(http.HandlerFunc(a.discord.callback)))
+	}
+	mux.Handle("POST "+Prefix+"/logout", middleware(http.HandlerFunc(a.logout)))
+	mux.Handle("GET "+Prefix+"/me", middleware(a.Middleware(http.HandlerFunc(me))))
+}
+
+// Middleware puts the ID of the authenticated user into
+// the request context, see user.ID. Requests with an
+// invalid API key are rejected, and so are anonymous
+// requests when authentication is required. Otherwise
+// anonymous requests are made as user.Anonymous.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.authenticate(r)
+		if err != nil {
+			unauthorized(w, err.Error())
+			return
+		}
+		if id == "" {
+			if a.required {
+				unauthorized(w, "authentication required")
+				return
+			}
+			id = user.Anonymous
+		}
+		next.ServeHTTP(w, r.WithContext(user.WithID(r.Context(), id)))
+	})
+}
+
+// authenticate returns the user of the request, or "" for
+// anonymous requests.
+func (a *Authenticator) authenticate(r *http.Request) (string, error) {
+	key := r.Header.Get("X-API-Key")
+	if h := r.Header.Get("Authorization"); key == "" && h != "" {
+		scheme, token, _ := strings.Cut(h, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return "", errors.New("unsupported authorization scheme")
+		}
+		key = strings.TrimSpace(token)
+	}
+	if key != "" {
+		return a.userForKey(key)
+	}
+	if a.sessions != nil {
+		if id, ok := a.sessions.user(r); ok {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// userForKey compares the hash of the key with every
+// configured hash in constant time.
+func (a *Authenticator) userForKey(key string) (string, error) {
+	sum := sha256.Sum256([]byte(key))
+	var id string
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.hash) == 1 {
+			id = k.userID
+		}
+	}
+	if id == "" {
+		return "", ErrInvalidKey
+	}
+	return id, nil
+}
+
+// POST /v1/auth/logout
+func (a *Authenticator) logout(w http.ResponseWriter, r *http.Request) {
+	if a.sessions != nil {
+		a.sessions.clear(w, r)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /v1/auth/me
+func me(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"user_id": user.ID(r.Context())})
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="gateway"`)
+	apierror.Write(w, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "%s", msg))
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"ffmpeg/wrapper/gateway/internal/apierror"
+	"ffmpeg/wrapper/internal/config"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	discordAuthorizeURL = "https://discord.com/oauth2/authorize"
+	discordTokenURL     = "https://discord.com/api/oauth2/token"
+	discordUserURL      = "https://discord.com/api/users/@me"
+
+	stateCookie = "oauth_state"
+)
+
+// discord implements "Login with Discord" through the
+// OAuth2 authorization code flow. Users are identified as
+// "discord:<Discord user ID>".
+type discord struct {
+	cfg            config.Discord
+	sessions       *sessions
+	allowedOrigins []string
+	client         *http.Client
+}
+
+func newDiscord(cfg config.Discord, sessions *sessions, allowedOrigins []string) *discord {
+	return &discord{
+		cfg:            cfg,
+		sessions:       sessions,
+		allowedOrigins: allowedOrigins,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GET /v1/auth/discord/login?redirect=<url>
+func (d *discord) login(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "failed to start login"))
+		return
+	}
+	state := hex.EncodeToString(b)
+	// Only redirect back to the frontends allowed by CORS,
+	// so that the login cannot be used as an open redirect.
+	redirect := r.URL.Query().Get("redirect")
+	if !d.allowedRedirect(redirect) {
+		redirect = ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state + "|" + url.QueryEscape(redirect),
+		Path:     Prefix + "/discord",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   secure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {d.cfg.ClientID},
+		"scope":         {"identify"},
+		"state":         {state},
+		"redirect_uri":  {d.cfg.RedirectURL},
+	}
+	http.Redirect(w, r, discordAuthorizeURL+"?"+q.Encode(), http.StatusFound)
+}
+
+// GET /v1/auth/discord/callback?code=<code>&state=<state>
+func (d *discord) callback(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(stateCookie)
+	if err != nil {
+		apierror.Write(w, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "login expired, try again"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: Prefix + "/discord", MaxAge: -1})
+	state, redirect, _ := strings.Cut(c.Value, "|")
+	q := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+		apierror.Write(w, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "invalid login state"))
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		apierror.Write(w, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "login denied: %s", e))
+		return
+	}
+	code := q.Get("code")
+	if code == "" {
+		apierror.Write(w, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "missing code"))
+		return
+	}
+
+	token, err := d.exchange(r.Context(), code)
+	if err != nil {
+		log.Printf("discord token exchange failed: %v", err)
+		apierror.Write(w, apierror.New(http.StatusBadGateway, apierror.CodeUpstreamUnavailable, "login failed"))
+		return
+	}
+	discordID, err := d.userID(r.Context(), token)
+	if err != nil {
+		log.Printf("discord user lookup failed: %v", err)
+		apierror.Write(w, apierror.New(http.StatusBadGateway, apierror.CodeUpstreamUnavailable, "login failed"))
+		return
+	}
+	userID := "discord:" + discordID
+	if err := d.sessions.set(w, r, userID); err != nil {
+		apierror.Write(w, apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "login failed"))
+		return
+	}
+
+	if redirect, _ = url.QueryUnescape(redirect); redirect != "" && d.allowedRedirect(redirect) {
+		http.Redirect(w, r, redirect, http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"user_id": userID})
+}
+
+// exchange trades an authorization code for an access
+// token.
+func (d *discord) exchange(ctx context.Context, code string) (string, error) {
+	form := url.Values{
+		"client_id":     {d.cfg.ClientID},
+		"client_secret": {d.cfg.ClientSecret},
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {d.cfg.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discordTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := d.do(req, &resp); err != nil {
+		return "", err
+	}
+	if resp.AccessToken == "" {
+		return "", fmt.Errorf("no access token in response")
+	}
+	return resp.AccessToken, nil
+}
+
+// userID returns the Discord ID of the user the access
+// token was issued for.
+func (d *discord) userID(ctx context.Context, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discordUserURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := d.do(req, &resp); err != nil {
+		return "", err
+	}
+	if resp.ID == "" {
+		return "", fmt.Errorf("no user ID in response")
+	}
+	return resp.ID, nil
+}
+
+func (d *discord) do(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("discord returned %s: %s", resp.Status, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// allowedRedirect reports whether u points to an allowed
+// frontend origin.
+func (d *discord) allowedRedirect(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return false
+	}
+	return slices.Contains(d.allowedOrigins, parsed.Scheme+"://"+parsed.Host)
+}
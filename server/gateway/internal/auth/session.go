@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const sessionCookie = "session"
+
+// sessions issues stateless session cookies holding the
+// user ID and expiry, signed with HMAC-SHA256.
+type sessions struct {
+	secret   []byte
+	lifetime time.Duration
+}
+
+type sessionPayload struct {
+	UserID  string `json:"sub"`
+	Expires int64  `json:"exp"`
+}
+
+// set issues a session cookie for the given user.
+func (s *sessions) set(w http.ResponseWriter, r *http.Request, userID string) error {
+	payload, err := json.Marshal(sessionPayload{UserID: userID, Expires: time.Now().Add(s.lifetime).Unix()})
+	if err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value + "." + s.sign(value),
+		Path:     "/",
+		MaxAge:   int(s.lifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   secure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// clear removes the session cookie.
+func (s *sessions) clear(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// user returns the user of a valid, unexpired session
+// cookie.
+func (s *sessions) user(r *http.Request) (string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	value, sig, ok := strings.Cut(c.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(value))) {
+		return "", false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", false
+	}
+	var p sessionPayload
+	if err := json.Unmarshal(b, &p); err != nil || p.UserID == "" || time.Now().Unix() > p.Expires {
+		return "", false
+	}
+	return p.UserID, true
+}
+
+func (s *sessions) sign(value string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// secure reports whether the request reached the gateway,
+// or the proxy in front of it, over HTTPS.
+func secure(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
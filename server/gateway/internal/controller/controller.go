@@ -2,26 +2,151 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"ffmpeg/wrapper/gateway/internal/apierror"
+	"ffmpeg/wrapper/gateway/internal/quota"
 	"ffmpeg/wrapper/gen"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/user"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// VideoGatewayController is the VideoService client the
+// HTTP proxy calls. It validates requests and enforces the
+// quotas and job ownership of the calling user before
+// calling VideoService.
 type VideoGatewayController struct {
 	videoClient gen.VideoServiceClient
+	limits      config.Limits
+	quotas      *quota.Tracker
+	// premium holds the users that may submit premium jobs.
+	premium map[string]bool
+}
+
+var _ gen.VideoServiceClient = (*VideoGatewayController)(nil)
+
+func NewVideoGatewayController(client gen.VideoServiceClient, quotas *quota.Tracker, cfg *config.Config) *VideoGatewayController {
+	premium := map[string]bool{}
+	for _, id := range cfg.Auth.Quotas.PremiumUsers {
+		premium[id] = true
+	}
+	return &VideoGatewayController{
+		videoClient: client,
+		limits:      cfg.Limits,
+		quotas:      quotas,
+		premium:     premium,
+	}
+}
+
+// GetVideoDetails only describes objects the user uploaded.
+func (c *VideoGatewayController) GetVideoDetails(ctx context.Context, in *gen.GetVideoDetailsRequest, opts ...grpc.CallOption) (*gen.GetVideoDetailsResponse, error) {
+	if err := c.quotas.OwnsObject(ctx, user.ID(ctx), in.Path); errors.Is(err, quota.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "video not found")
+	} else if err != nil {
+		return nil, err
+	}
+	return c.videoClient.GetVideoDetails(ctx, in, opts...)
 }
 
-func NewVideoGatewayController(conn gen.VideoServiceClient) *VideoGatewayController {
-	return &VideoGatewayController{videoClient: conn}
+// GetUploadURL counts the upload against the daily upload
+// quota of the user, and records the job as theirs.
+func (c *VideoGatewayController) GetUploadURL(ctx context.Context, in *gen.GetUploadURLRequest, opts ...grpc.CallOption) (*gen.GetUploadURLResponse, error) {
+	if err := validateUpload(in, c.limits.MaxUploadBytes); err != nil {
+		return nil, err
+	}
+	userID := user.ID(ctx)
+	if err := c.quotas.ReserveUpload(ctx, userID, in.Size); err != nil {
+		return nil, err
+	}
+	resp, err := c.videoClient.GetUploadURL(ctx, in, opts...)
+	if err != nil {
+		c.quotas.ReleaseUpload(ctx, userID, in.Size)
+		return nil, err
+	}
+	if err := c.quotas.AddJob(ctx, userID, resp.JobId, resp.ObjectKey); err != nil {
+		c.quotas.ReleaseUpload(ctx, userID, in.Size)
+		return nil, err
+	}
+	return resp, nil
 }
 
-// GetUploadURL wraps gRPC call to VideoService
-func (c *VideoGatewayController) GetUploadURL(ctx context.Context, filename string) (*gen.GetUploadURLResponse, error) {
-	return c.videoClient.GetUploadURL(ctx, &gen.GetUploadURLRequest{Filename: filename})
+// GetJobStatus only reports jobs of the user. Its objects
+// are deleted by the retention of the metadata service.
+func (c *VideoGatewayController) GetJobStatus(ctx context.Context, in *gen.GetJobStatusRequest, opts ...grpc.CallOption) (*gen.GetJobStatusResponse, error) {
+	if err := c.quotas.Owns(ctx, user.ID(ctx), in.JobId); err != nil {
+		return nil, err
+	}
+	return c.videoClient.GetJobStatus(ctx, in, opts...)
 }
 
-// GetJobStatus wraps gRPC call to VideoService
-func (c *VideoGatewayController) GetJobStatus(ctx context.Context, jobID int64) (*gen.GetJobStatusResponse, error) {
-	return c.videoClient.GetJobStatus(ctx, &gen.GetJobStatusRequest{JobId: jobID})
+// GetCompressionJob counts the job against the job quotas
+// of the user. The object to compress is the one uploaded
+// for the job, whatever the request says.
+func (c *VideoGatewayController) GetCompressionJob(ctx context.Context, in *gen.GetCompressionJobRequest, opts ...grpc.CallOption) (*gen.GetCompressionJobResponse, error) {
+	if in.JobId == 0 {
+		return nil, validationError(apierror.FieldError{Field: "job_id", Message: "must be a job ID returned by POST /v1/uploads"})
+	}
+	if err := validateCallback(in); err != nil {
+		return nil, err
+	}
+	if err := validatePriority(in, c.premium[user.ID(ctx)]); err != nil {
+		return nil, err
+	}
+	if err := validateOutputFormat(in); err != nil {
+		return nil, err
+	}
+	objectKey, err := c.quotas.StartJob(ctx, user.ID(ctx), in.JobId)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.videoClient.GetCompressionJob(ctx, &gen.GetCompressionJobRequest{
+		JobId:          in.JobId,
+		ObjectKey:      objectKey,
+		CallbackUrl:    in.CallbackUrl,
+		CallbackSecret: in.CallbackSecret,
+		Priority:       in.Priority,
+		OutputFormat:   in.OutputFormat,
+	}, opts...)
+	if err != nil {
+		c.quotas.FinishJob(ctx, in.JobId)
+		return nil, err
+	}
+	return resp, nil
 }
-func (c *VideoGatewayController) GetCompressionJob(ctx context.Context, jobID int64, objectKey string) (*gen.GetCompressionJobResponse, error) {
-	return c.videoClient.GetCompressionJob(ctx, &gen.GetCompressionJobRequest{JobId: jobID, ObjectKey: objectKey})
+
+// GetWebhookDeliveries only lists deliveries of jobs of
+// the user.
+func (c *VideoGatewayController) GetWebhookDeliveries(ctx context.Context, in *gen.GetWebhookDeliveriesRequest, opts ...grpc.CallOption) (*gen.GetWebhookDeliveriesResponse, error) {
+	if err := c.quotas.Owns(ctx, user.ID(ctx), in.JobId); err != nil {
+		return nil, err
+	}
+	return c.videoClient.GetWebhookDeliveries(ctx, in, opts...)
+}
+
+// GetDownloadURL only presigns downloads of jobs of the
+// user. The object keys are set by the video service.
+func (c *VideoGatewayController) GetDownloadURL(ctx context.Context, in *gen.GetDownloadURLRequest, opts ...grpc.CallOption) (*gen.GetDownloadURLResponse, error) {
+	if in.LifetimeSeconds < 0 {
+		return nil, validationError(apierror.FieldError{Field: "lifetime_seconds", Message: "must not be negative"})
+	}
+	if err := c.quotas.Owns(ctx, user.ID(ctx), in.JobId); err != nil {
+		return nil, err
+	}
+	return c.videoClient.GetDownloadURL(ctx, &gen.GetDownloadURLRequest{
+		JobId:           in.JobId,
+		LifetimeSeconds: in.LifetimeSeconds,
+	}, opts...)
+}
+
+// CancelJob only cancels jobs of the user. The concurrency
+// slot of the job is freed once its cancelled result is
+// read.
+func (c *VideoGatewayController) CancelJob(ctx context.Context, in *gen.CancelJobRequest, opts ...grpc.CallOption) (*gen.CancelJobResponse, error) {
+	if err := c.quotas.Owns(ctx, user.ID(ctx), in.JobId); err != nil {
+		return nil, err
+	}
+	return c.videoClient.CancelJob(ctx, &gen.CancelJobRequest{JobId: in.JobId}, opts...)
 }
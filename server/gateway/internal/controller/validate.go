@@ -0,0 +1,88 @@
+package controller
+
+import (
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/gateway/internal/apierror"
+	"ffmpeg/wrapper/gen"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// maxFilenameBytes bounds uploaded file names, which end
+// up in object keys.
+const maxFilenameBytes = 255
+
+func validateUpload(req *gen.GetUploadURLRequest, maxUploadBytes int64) *apierror.Error {
+	var fields []apierror.FieldError
+	switch {
+	case req.Filename == "":
+		fields = append(fields, apierror.FieldError{Field: "filename", Message: "must be set"})
+	case len(req.Filename) > maxFilenameBytes:
+		fields = append(fields, apierror.FieldError{Field: "filename", Message: fmt.Sprintf("must be at most %d bytes", maxFilenameBytes)})
+	case strings.ContainsAny(req.Filename, `/\`) || strings.IndexFunc(req.Filename, unicode.IsControl) >= 0:
+		fields = append(fields, apierror.FieldError{Field: "filename", Message: "must not contain path separators or control characters"})
+	}
+	if req.Size <= 0 {
+		fields = append(fields, apierror.FieldError{Field: "size", Message: "must be positive"})
+	}
+	if len(fields) > 0 {
+		return validationError(fields...)
+	}
+	if maxUploadBytes > 0 && req.Size > maxUploadBytes {
+		apiErr := apierror.New(http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "file larger than %d bytes", maxUploadBytes)
+		apiErr.Fields = []apierror.FieldError{{Field: "size", Message: fmt.Sprintf("must be at most %d", maxUploadBytes)}}
+		return apiErr
+	}
+	return nil
+}
+
+// validateCallback checks the form of the callback of a
+// job. VideoService checks that it may be called.
+func validateCallback(req *gen.GetCompressionJobRequest) *apierror.Error {
+	var fields []apierror.FieldError
+	if req.CallbackUrl != "" {
+		u, err := url.Parse(req.CallbackUrl)
+		if err != nil || u.Host == "" || u.Scheme != "https" && u.Scheme != "http" {
+			fields = append(fields, apierror.FieldError{Field: "callback_url", Message: "must be an absolute HTTPS URL"})
+		}
+		if req.CallbackSecret == "" {
+			fields = append(fields, apierror.FieldError{Field: "callback_secret", Message: "must be set with callback_url"})
+		}
+	} else if req.CallbackSecret != "" {
+		fields = append(fields, apierror.FieldError{Field: "callback_url", Message: "must be set with callback_secret"})
+	}
+	if len(fields) > 0 {
+		return validationError(fields...)
+	}
+	return nil
+}
+
+// validatePriority checks the priority of a job. Only
+// premium users may submit premium jobs.
+func validatePriority(req *gen.GetCompressionJobRequest, premium bool) *apierror.Error {
+	p, ok := compressionmodel.ParsePriority(req.Priority)
+	switch {
+	case !ok:
+		return validationError(apierror.FieldError{Field: "priority", Message: "must be one of premium, interactive, batch"})
+	case p == compressionmodel.PriorityPremium && !premium:
+		return validationError(apierror.FieldError{Field: "priority", Message: "premium is only available to premium users"})
+	}
+	return nil
+}
+
+// validateOutputFormat checks the output format of a job.
+func validateOutputFormat(req *gen.GetCompressionJobRequest) *apierror.Error {
+	if _, ok := compressionmodel.ParseOutputFormat(req.OutputFormat); !ok {
+		return validationError(apierror.FieldError{Field: "output_format", Message: "must be one of video, gif, webp"})
+	}
+	return nil
+}
+
+func validationError(fields ...apierror.FieldError) *apierror.Error {
+	apiErr := apierror.New(http.StatusBadRequest, apierror.CodeValidationFailed, "invalid request fields")
+	apiErr.Fields = fields
+	return apiErr
+}
@@ -2,124 +2,129 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	compressionModel "ffmpeg/wrapper/compression/pkg/model"
-	"ffmpeg/wrapper/gateway/internal/controller"
-	"ffmpeg/wrapper/gateway/internal/repository"
+	"ffmpeg/wrapper/gateway/api"
+	"ffmpeg/wrapper/gateway/internal/apierror"
+	"ffmpeg/wrapper/gateway/internal/auth"
+	"ffmpeg/wrapper/gateway/internal/quota"
+	"ffmpeg/wrapper/gen"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
-	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/segmentio/kafka-go"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
+// Handler serves VideoService over HTTP/JSON, through the
+// proxy generated from the google.api.http options of
+// api/video.proto. Fields are named as in the proto file.
 type Handler struct {
-	ctrl        *controller.VideoGatewayController
-	kafkaReader *kafka.Reader
-	repo        repository.S3Actions
+	mux *runtime.ServeMux
 }
 
-func NewHandler(ctrl *controller.VideoGatewayController, reader *kafka.Reader, repo repository.S3Actions) *Handler {
-	return &Handler{
-		ctrl:        ctrl,
-		kafkaReader: reader,
-		repo:        repo,
+// NewHandler creates a handler calling client, which is
+// expected to enforce the quotas of the calling user.
+func NewHandler(ctx context.Context, client gen.VideoServiceClient) (*Handler, error) {
+	mux := runtime.NewServeMux(
+		// Unlike the default marshaler, this one rejects
+		// unknown fields instead of discarding them.
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: false},
+		}),
+		// The user is passed in the context, and the
+		// headers of the client are not forwarded.
+		runtime.WithIncomingHeaderMatcher(func(string) (string, bool) { return "", false }),
+		runtime.WithOutgoingHeaderMatcher(func(string) (string, bool) { return "", false }),
+		runtime.WithForwardResponseOption(forwardResponse),
+		runtime.WithErrorHandler(handleError),
+		runtime.WithRoutingErrorHandler(handleRoutingError),
+	)
+	if err := gen.RegisterVideoServiceHandlerClient(ctx, mux, client); err != nil {
+		return nil, err
 	}
+	return &Handler{mux: mux}, nil
 }
 
-var bucketName = os.Getenv("bucketname")
+// ServeHTTP implements http.Handler. It must run after
+// authentication, and drops the credentials of the request
+// so that they do not reach the backend services.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Header.Del("Authorization")
+	r.Header.Del("X-API-Key")
+	r.Header.Del("Cookie")
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	h.mux.ServeHTTP(w, r)
+}
 
-// POST /upload
-func (h *Handler) PostUploadURL(w http.ResponseWriter, r *http.Request) {
-	var req struct{ Filename string }
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
-		return
-	}
-	resp, err := h.ctrl.GetUploadURL(r.Context(), req.Filename)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	json.NewEncoder(w).Encode(resp)
+// NewMux routes the auth endpoints, the OpenAPI document
+// and every other /v1 route, the VideoService RPCs, to h.
+// public wraps the routes served without authentication,
+// and api the routes of h.
+func NewMux(h *Handler, authn *auth.Authenticator, public, api func(http.Handler) http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	authn.RegisterRoutes(mux, public)
+	mux.Handle("GET /v1/openapi.yaml", http.HandlerFunc(h.OpenAPI))
+	mux.Handle("/v1/", api(h))
+	return JSONErrors(mux)
 }
 
-// GET /jobs/status?job_id=xxx
-func (h *Handler) GetJobStatus(w http.ResponseWriter, r *http.Request) {
-	jobID := r.URL.Query().Get("job_id")
-	if jobID == "" {
-		http.Error(w, "missing job_id", http.StatusBadRequest)
-		return
+// GET /v1/openapi.yaml
+func (h *Handler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(api.OpenAPI)
+}
+
+// forwardResponse answers 201 Created for new uploads and
+// 202 Accepted for started jobs.
+func forwardResponse(_ context.Context, w http.ResponseWriter, m proto.Message) error {
+	switch m.(type) {
+	case *gen.GetUploadURLResponse:
+		w.WriteHeader(http.StatusCreated)
+	case *gen.GetCompressionJobResponse:
+		w.WriteHeader(http.StatusAccepted)
 	}
-	jobIDint, err := strconv.ParseInt(jobID, 10, 64)
-	if err != nil {
-		http.Error(w, "failed convert", http.StatusInternalServerError)
+	return nil
+}
+
+func handleError(_ context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, _ *http.Request, err error) {
+	var qErr *quota.Error
+	if errors.As(err, &qErr) || errors.Is(err, quota.ErrNotFound) || errors.Is(err, quota.ErrUnavailable) {
+		apierror.Write(w, quotaError(err))
 		return
 	}
+	apierror.Write(w, apierror.FromGRPC(err))
+}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	h.kafkaReader.SetOffset(kafka.FirstOffset)
-	for {
-		m, err := h.kafkaReader.ReadMessage(ctx)
-		if err != nil {
-			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-				json.NewEncoder(w).Encode(map[string]string{
-					"status": "processing",
-				})
-				return
-			}
-
-			log.Printf("kafka reading error: %v", err)
-			http.Error(w, "error reading Kafka message", http.StatusInternalServerError)
-			return
-		}
-		var result compressionModel.CompressionResultEvent
-		if err := json.Unmarshal(m.Value, &result); err != nil {
-			continue // skip malformed messages
-		}
-		if result.JobID == jobIDint && result.CompressionEventType != "" {
-			json.NewEncoder(w).Encode((result))
-
-			go func(expiry time.Time, objectKeys []string) {
-				log.Print(expiry, objectKeys)
-				duration := time.Until(expiry)
-				log.Print(duration)
-				if duration > 0 {
-					time.Sleep(duration)
-				}
-				objs := make([]types.ObjectIdentifier, 0, len(objectKeys))
-				for _, k := range objectKeys {
-					objs = append(objs, types.ObjectIdentifier{Key: aws.String(k)})
-				}
-				bgCtx := context.Background()
-				h.repo.DeleteObjects(bgCtx, bucketName, objs, false)
-			}(result.Expiry, []string{result.ObjectKey, result.CompressedKey})
-
-			return
-		}
+func handleRoutingError(_ context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, _ *http.Request, code int) {
+	switch code {
+	case http.StatusMethodNotAllowed:
+		apierror.Write(w, apierror.New(code, apierror.CodeMethodNotAllowed, "method not allowed, see /v1/openapi.yaml"))
+	default:
+		apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "no such endpoint, see /v1/openapi.yaml"))
 	}
 }
 
-func (h *Handler) PostUploadStatus(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		JobID     int64  `json:"job_id"`
-		ObjectKey string `json:"object_key"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request", http.StatusBadRequest)
-	}
-	resp, err := h.ctrl.GetCompressionJob(r.Context(), req.JobID, req.ObjectKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// quotaError converts a quota tracker error to an API
+// error, 429 Too Many Requests for exhausted quotas and
+// 503 Service Unavailable when the quota store fails.
+func quotaError(err error) *apierror.Error {
+	var qErr *quota.Error
+	switch {
+	case errors.As(err, &qErr):
+		apiErr := apierror.New(http.StatusTooManyRequests, apierror.CodeQuotaExceeded, "%s", err)
+		if qErr.RetryAfter > 0 {
+			apiErr.RetryAfter = int(qErr.RetryAfter.Seconds()) + 1
+		}
+		return apiErr
+	case errors.Is(err, quota.ErrNotFound):
+		return apierror.New(http.StatusNotFound, apierror.CodeNotFound, "%s", err)
+	case errors.Is(err, quota.ErrUnavailable):
+		log.Printf("quota check failed: %v", err)
+		return apierror.New(http.StatusServiceUnavailable, apierror.CodeUpstreamUnavailable, "a backend service is unavailable, try again later")
+	default:
+		log.Printf("quota check failed: %v", err)
+		return apierror.New(http.StatusInternalServerError, apierror.CodeInternal, "internal error")
 	}
-	log.Println(resp)
-	json.NewEncoder(w).Encode(resp)
 }
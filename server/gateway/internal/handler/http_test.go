@@ -0,0 +1,633 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"ffmpeg/wrapper/gateway/api"
+	"ffmpeg/wrapper/gateway/internal/auth"
+	"ffmpeg/wrapper/gateway/internal/controller"
+	"ffmpeg/wrapper/gateway/internal/quota"
+	"ffmpeg/wrapper/gateway/internal/ratelimit"
+	"ffmpeg/wrapper/gen"
+	"ffmpeg/wrapper/internal/config"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeVideo answers the VideoService RPCs the gateway
+// forwards. Uploads named unavailable.mp4 and the jobs in
+// errs fail with the given errors.
+type fakeVideo struct {
+	mu      sync.Mutex
+	nextJob int64
+	errs    map[int64]error
+}
+
+func (f *fakeVideo) GetVideoDetails(ctx context.Context, in *gen.GetVideoDetailsRequest, opts ...grpc.CallOption) (*gen.GetVideoDetailsResponse, error) {
+	return &gen.GetVideoDetailsResponse{
+		Link:        "https://r2.example.com/" + in.Path,
+		OldMetadata: &gen.Metadata{Filename: in.Path, NbStreams: 2, FormatName: "mov,mp4,m4a,3gp,3g2,mj2", Duration: "12.5"},
+	}, nil
+}
+
+func (f *fakeVideo) GetUploadURL(ctx context.Context, in *gen.GetUploadURLRequest, opts ...grpc.CallOption) (*gen.GetUploadURLResponse, error) {
+	if in.Filename == "unavailable.mp4" {
+		return nil, status.Error(codes.Unavailable, "metadata service is down")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextJob++
+	return &gen.GetUploadURLResponse{
+		JobId:        f.nextJob,
+		ObjectKey:    fmt.Sprintf("uploads/%d/%s", f.nextJob, in.Filename),
+		PresignedUrl: presigned("PUT"),
+		ExpiresAt:    timestamppb.New(time.Now().Add(time.Hour)),
+	}, nil
+}
+
+func (f *fakeVideo) GetJobStatus(ctx context.Context, in *gen.GetJobStatusRequest, opts ...grpc.CallOption) (*gen.GetJobStatusResponse, error) {
+	if err := f.err(in.JobId); err != nil {
+		return nil, err
+	}
+	return &gen.GetJobStatusResponse{
+		JobId:         in.JobId,
+		Status:        "succeeded",
+		DownloadUrl:   presigned("GET"),
+		ExpiresAt:     timestamppb.New(time.Now().Add(24 * time.Hour)),
+		ObjectKey:     "uploads/clip.mp4",
+		CompressedKey: "compressed/clip.gif",
+		Strategy:      "animated",
+		Outcome:       "compressed",
+	}, nil
+}
+
+func (f *fakeVideo) GetCompressionJob(ctx context.Context, in *gen.GetCompressionJobRequest, opts ...grpc.CallOption) (*gen.GetCompressionJobResponse, error) {
+	if err := f.err(in.JobId); err != nil {
+		return nil, err
+	}
+	return &gen.GetCompressionJobResponse{JobId: in.JobId, Status: "processing"}, nil
+}
+
+func (f *fakeVideo) GetWebhookDeliveries(ctx context.Context, in *gen.GetWebhookDeliveriesRequest, opts ...grpc.CallOption) (*gen.GetWebhookDeliveriesResponse, error) {
+	if err := f.err(in.JobId); err != nil {
+		return nil, err
+	}
+	return &gen.GetWebhookDeliveriesResponse{Deliveries: []*gen.WebhookDelivery{{
+		Id:            "whk_1",
+		JobId:         in.JobId,
+		Attempt:       1,
+		Url:           "https://hooks.example.com/compressed",
+		AttemptedAt:   timestamppb.Now(),
+		StatusCode:    500,
+		NextAttemptAt: timestamppb.New(time.Now().Add(time.Minute)),
+	}}}, nil
+}
+
+func (f *fakeVideo) GetDownloadURL(ctx context.Context, in *gen.GetDownloadURLRequest, opts ...grpc.CallOption) (*gen.GetDownloadURLResponse, error) {
+	if err := f.err(in.JobId); err != nil {
+		return nil, err
+	}
+	return &gen.GetDownloadURLResponse{
+		DownloadUrl: presigned("GET"),
+		ExpiresAt:   timestamppb.New(time.Now().Add(time.Hour)),
+		Filename:    "clip_compressed.mp4",
+	}, nil
+}
+
+func (f *fakeVideo) CancelJob(ctx context.Context, in *gen.CancelJobRequest, opts ...grpc.CallOption) (*gen.CancelJobResponse, error) {
+	if err := f.err(in.JobId); err != nil {
+		return nil, err
+	}
+	return &gen.CancelJobResponse{JobId: in.JobId, Status: "processing"}, nil
+}
+
+func (f *fakeVideo) err(jobID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.errs[jobID]
+}
+
+func presigned(method string) *gen.PresignedRequest {
+	return &gen.PresignedRequest{
+		Method:  method,
+		Url:     "https://r2.example.com/bucket/object?X-Amz-Signature=abc",
+		Headers: map[string]string{"Content-Type": "video/mp4"},
+	}
+}
+
+func apiKey(userID string, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return userID + ":" + hex.EncodeToString(sum[:])
+}
+
+// newServer wires the gateway like its main, with api
+// wrapping the VideoService routes after authentication.
+func newServer(t *testing.T, video *fakeVideo, api func(authn *auth.Authenticator, h http.Handler) http.Handler) http.Handler {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Limits.MaxUploadBytes = 2000
+	cfg.Auth = config.Auth{
+		Required:        true,
+		APIKeys:         []string{apiKey("alice", "alice-key"), apiKey("bob", "bob-key")},
+		SessionSecret:   "0123456789abcdef0123456789abcdef",
+		SessionLifetime: time.Hour,
+		Discord:         config.Discord{ClientID: "client", ClientSecret: "secret", RedirectURL: "https://api.example.com/v1/auth/discord/callback"},
+		Quotas:          config.Quotas{DailyJobs: 10, DailyUploadBytes: 1000, ConcurrentJobs: 1, JobTimeout: time.Hour},
+	}
+	authn, err := auth.New(cfg.Auth, []string{"https://app.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotas := quota.New(cfg.Auth.Quotas, quota.NewMemoryStore())
+	h, err := NewHandler(context.Background(), controller.NewVideoGatewayController(video, quotas, cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewMux(h, authn,
+		func(h http.Handler) http.Handler { return h },
+		func(h http.Handler) http.Handler { return api(authn, h) },
+	)
+}
+
+// spec is the embedded OpenAPI document.
+type spec struct {
+	doc map[string]any
+}
+
+func loadSpec(t *testing.T) *spec {
+	t.Helper()
+	var doc map[string]any
+	if err := yaml.Unmarshal(api.OpenAPI, &doc); err != nil {
+		t.Fatalf("failed to parse openapi.yaml: %v", err)
+	}
+	return &spec{doc: doc}
+}
+
+// operation is a method of a path of the spec.
+type operation struct {
+	id     string
+	method string
+	path   string
+	def    map[string]any
+}
+
+func (s *spec) operations() []operation {
+	var ops []operation
+	for path, item := range s.doc["paths"].(map[string]any) {
+		for method, def := range item.(map[string]any) {
+			def := def.(map[string]any)
+			ops = append(ops, operation{id: def["operationId"].(string), method: strings.ToUpper(method), path: path, def: def})
+		}
+	}
+	slices.SortFunc(ops, func(a, b operation) int { return strings.Compare(a.id, b.id) })
+	return ops
+}
+
+// find returns the operation serving the request path.
+// Path parameters match a segment, and {path} the rest.
+func (s *spec) find(method string, path string) (operation, bool) {
+	for _, op := range s.operations() {
+		pattern := regexp.QuoteMeta(op.path)
+		pattern = strings.ReplaceAll(pattern, `\{path\}`, `.+`)
+		pattern = regexp.MustCompile(`\\\{\w+\\\}`).ReplaceAllString(pattern, `[^/]+`)
+		if op.method == method && regexp.MustCompile("^"+pattern+"$").MatchString(path) {
+			return op, true
+		}
+	}
+	return operation{}, false
+}
+
+// resolve follows the $ref of v, if any.
+func (s *spec) resolve(v map[string]any) map[string]any {
+	ref, ok := v["$ref"].(string)
+	if !ok {
+		return v
+	}
+	var node any = s.doc
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		node = node.(map[string]any)[part]
+	}
+	return s.resolve(node.(map[string]any))
+}
+
+func (s *spec) schema(name string) map[string]any {
+	return s.doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+}
+
+// validate checks v, decoded from JSON, against schema and
+// returns the violations.
+func (s *spec) validate(schema map[string]any, v any, at string) []string {
+	schema = s.resolve(schema)
+	var errs []string
+	fail := func(format string, a ...any) {
+		errs = append(errs, at+": "+fmt.Sprintf(format, a...))
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return fmt.Sprint(e) == fmt.Sprint(v) }) {
+		fail("%v is not one of %v", v, enum)
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("%T is not an object", v)
+			break
+		}
+		for _, name := range asSlice(schema["required"]) {
+			if _, ok := obj[name.(string)]; !ok {
+				fail("missing required property %s", name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for name, value := range obj {
+			if prop, ok := props[name]; ok {
+				errs = append(errs, s.validate(prop.(map[string]any), value, at+"."+name)...)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					fail("unexpected property %s", name)
+				}
+			case map[string]any:
+				errs = append(errs, s.validate(extra, value, at+"."+name)...)
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			fail("%T is not an array", v)
+			break
+		}
+		for i, item := range arr {
+			errs = append(errs, s.validate(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("%T is not a string", v)
+			break
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			fail("%q does not match %s", str, pattern)
+		}
+		if n, ok := number(schema["minLength"]); ok && float64(len(str)) < n {
+			fail("%q is shorter than %v", str, n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && float64(len(str)) > n {
+			fail("%q is longer than %v", str, n)
+		}
+		switch schema["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				fail("%q is not a date-time", str)
+			}
+		case "uri":
+			if u, err := url.Parse(str); err != nil || u.Scheme == "" {
+				fail("%q is not a URI", str)
+			}
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			fail("%T is not a number", v)
+			break
+		}
+		if schema["type"] == "integer" && n != math.Trunc(n) {
+			fail("%v is not an integer", n)
+		}
+		if min, ok := number(schema["minimum"]); ok && n < min {
+			fail("%v is below %v", n, min)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("%T is not a boolean", v)
+		}
+	}
+	return errs
+}
+
+func asSlice(v any) []any {
+	s, _ := v.([]any)
+	return s
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// check verifies that the response is documented for the
+// operation serving the request and matches its schema.
+// Responses of requests matching no operation must be
+// JSON errors. It returns the decoded JSON body, if any.
+func (s *spec) check(t *testing.T, method string, path string, resp *http.Response, body []byte) (string, any) {
+	t.Helper()
+	var decoded any
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Fatalf("invalid JSON body %q: %v", body, err)
+		}
+	}
+	op, ok := s.find(method, path)
+	if !ok {
+		if errs := s.validate(s.schema("Error"), decoded, "body"); len(errs) > 0 {
+			t.Errorf("undocumented route answered %d with %s: %v", resp.StatusCode, body, errs)
+		}
+		return "", decoded
+	}
+	responses := op.def["responses"].(map[string]any)
+	def, ok := responses[strconv.Itoa(resp.StatusCode)].(map[string]any)
+	if !ok {
+		t.Fatalf("%s answered %d, which is not documented: %s", op.id, resp.StatusCode, body)
+	}
+	def = s.resolve(def)
+	for name, header := range asMap(def["headers"]) {
+		value := resp.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		schema := asMap(asMap(header)["schema"])
+		if schema["type"] == "integer" {
+			if _, err := strconv.Atoi(value); err != nil {
+				t.Errorf("%s header %s = %q, want an integer", op.id, name, value)
+			}
+		}
+	}
+	content := asMap(def["content"])
+	if len(content) == 0 {
+		return op.id, decoded
+	}
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	media, ok := content[mediaType].(map[string]any)
+	if !ok {
+		t.Fatalf("%s answered %d as %q, want one of %v", op.id, resp.StatusCode, mediaType, content)
+	}
+	switch mediaType {
+	case "application/json":
+		if errs := s.validate(asMap(media["schema"]), decoded, "body"); len(errs) > 0 {
+			t.Errorf("%s answered %d with %s, which does not match its schema: %v", op.id, resp.StatusCode, body, errs)
+		}
+	case "application/yaml":
+		var doc any
+		if err := yaml.Unmarshal(body, &doc); err != nil {
+			t.Errorf("%s answered invalid YAML: %v", op.id, err)
+		}
+	}
+	return op.id, decoded
+}
+
+func asMap(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+type request struct {
+	method string
+	path   string
+	body   string
+	// key is the API key sent, if any.
+	key string
+}
+
+func (r request) do(t *testing.T, h http.Handler) (*http.Response, []byte) {
+	t.Helper()
+	req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+	if r.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.key != "" {
+		req.Header.Set("X-API-Key", r.key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result(), rec.Body.Bytes()
+}
+
+// jobID reads the job ID of an upload response.
+func jobID(t *testing.T, body any) int64 {
+	t.Helper()
+	id, err := strconv.ParseInt(asMap(body)["job_id"].(string), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// TestContract drives every operation of the OpenAPI
+// document through the gateway mux, and checks that every
+// response is documented and matches its schema.
+func TestContract(t *testing.T) {
+	s := loadSpec(t)
+	video := &fakeVideo{nextJob: 1 << 60, errs: map[int64]error{}}
+	mux := newServer(t, video, func(authn *auth.Authenticator, h http.Handler) http.Handler { return authn.Middleware(h) })
+	exercised := map[string]bool{}
+
+	upload := func(key string, filename string) (int64, string) {
+		t.Helper()
+		r := request{method: "POST", path: "/v1/uploads", body: fmt.Sprintf(`{"filename": %q, "size": 100}`, filename), key: key}
+		resp, body := r.do(t, mux)
+		id, decoded := s.check(t, r.method, r.path, resp, body)
+		exercised[id] = true
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("upload answered %d: %s", resp.StatusCode, body)
+		}
+		return jobID(t, decoded), asMap(decoded)["object_key"].(string)
+	}
+	job, object := upload("alice-key", "clip.mp4")
+	conflicting, _ := upload("alice-key", "conflict.mp4")
+	unavailable, _ := upload("alice-key", "unavailable-job.mp4")
+	other, otherObject := upload("bob-key", "clip.mp4")
+	video.errs[conflicting] = status.Error(codes.FailedPrecondition, "job has not succeeded")
+	video.errs[unavailable] = status.Error(codes.Unavailable, "compression service is down")
+
+	jobPath := func(id int64, suffix string) string {
+		return "/v1/jobs/" + strconv.FormatInt(id, 10) + suffix
+	}
+	startJob := func(id int64) string {
+		return fmt.Sprintf(`{"job_id": "%d"}`, id)
+	}
+	tests := []struct {
+		name       string
+		req        request
+		wantStatus int
+		wantCode   string
+	}{
+		{"upload", request{"POST", "/v1/uploads", `{"filename": "a.mp4", "size": 100}`, "alice-key"}, 201, ""},
+		{"upload without credentials", request{"POST", "/v1/uploads", `{"filename": "a.mp4", "size": 100}`, ""}, 401, "unauthenticated"},
+		{"upload with invalid key", request{"POST", "/v1/uploads", `{"filename": "a.mp4", "size": 100}`, "nobody-key"}, 401, "unauthenticated"},
+		{"upload with malformed JSON", request{"POST", "/v1/uploads", `{"filename": `, "alice-key"}, 400, "validation_failed"},
+		{"upload with unknown field", request{"POST", "/v1/uploads", `{"filename": "a.mp4", "size": 100, "bitrate": 1}`, "alice-key"}, 400, "validation_failed"},
+		{"upload without filename", request{"POST", "/v1/uploads", `{"size": 100}`, "alice-key"}, 400, "validation_failed"},
+		{"upload too large", request{"POST", "/v1/uploads", `{"filename": "a.mp4", "size": 5000}`, "alice-key"}, 413, "payload_too_large"},
+		{"upload over daily bytes", request{"POST", "/v1/uploads", `{"filename": "a.mp4", "size": 1000}`, "alice-key"}, 429, "quota_exceeded"},
+		{"upload with backend down", request{"POST", "/v1/uploads", `{"filename": "unavailable.mp4", "size": 100}`, "alice-key"}, 503, "upstream_unavailable"},
+
+		{"start with backend down", request{"POST", "/v1/jobs", startJob(unavailable), "alice-key"}, 503, "upstream_unavailable"},
+		{"start", request{"POST", "/v1/jobs", startJob(job), "alice-key"}, 202, ""},
+		{"start over concurrent jobs", request{"POST", "/v1/jobs", startJob(conflicting), "alice-key"}, 429, "quota_exceeded"},
+		{"start without job", request{"POST", "/v1/jobs", `{}`, "alice-key"}, 400, "validation_failed"},
+		{"start with unknown format", request{"POST", "/v1/jobs", fmt.Sprintf(`{"job_id": "%d", "output_format": "avi"}`, job), "alice-key"}, 400, "validation_failed"},
+		{"start job of other user", request{"POST", "/v1/jobs", startJob(other), "alice-key"}, 404, "not_found"},
+		{"start without credentials", request{"POST", "/v1/jobs", startJob(job), ""}, 401, "unauthenticated"},
+
+		{"get job", request{"GET", jobPath(job, ""), "", "alice-key"}, 200, ""},
+		{"get job with invalid ID", request{"GET", "/v1/jobs/abc", "", "alice-key"}, 400, "validation_failed"},
+		{"get job of other user", request{"GET", jobPath(other, ""), "", "alice-key"}, 404, "not_found"},
+		{"get job with backend down", request{"GET", jobPath(unavailable, ""), "", "alice-key"}, 503, "upstream_unavailable"},
+		{"get job without credentials", request{"GET", jobPath(job, ""), "", ""}, 401, "unauthenticated"},
+
+		{"deliveries", request{"GET", jobPath(job, "/deliveries"), "", "alice-key"}, 200, ""},
+		{"deliveries of other user", request{"GET", jobPath(other, "/deliveries"), "", "alice-key"}, 404, "not_found"},
+		{"deliveries with backend down", request{"GET", jobPath(unavailable, "/deliveries"), "", "alice-key"}, 503, "upstream_unavailable"},
+
+		{"download", request{"GET", jobPath(job, "/download?lifetime_seconds=60"), "", "alice-key"}, 200, ""},
+		{"download with negative lifetime", request{"GET", jobPath(job, "/download?lifetime_seconds=-1"), "", "alice-key"}, 400, "validation_failed"},
+		{"download of unfinished job", request{"GET", jobPath(conflicting, "/download"), "", "alice-key"}, 409, "conflict"},
+		{"download of other user", request{"GET", jobPath(other, "/download"), "", "alice-key"}, 404, "not_found"},
+
+		{"cancel", request{"POST", jobPath(job, "/cancel"), "", "alice-key"}, 200, ""},
+		{"cancel finished job", request{"POST", jobPath(conflicting, "/cancel"), "", "alice-key"}, 409, "conflict"},
+		{"cancel job of other user", request{"POST", jobPath(other, "/cancel"), "", "alice-key"}, 404, "not_found"},
+
+		{"video details", request{"GET", "/v1/videos/" + object, "", "alice-key"}, 200, ""},
+		{"video details of other user", request{"GET", "/v1/videos/" + otherObject, "", "alice-key"}, 404, "not_found"},
+		{"video details without credentials", request{"GET", "/v1/videos/" + object, "", ""}, 401, "unauthenticated"},
+
+		{"discord login", request{"GET", "/v1/auth/discord/login?redirect=https://app.example.com/done", "", ""}, 302, ""},
+		{"discord callback without login", request{"GET", "/v1/auth/discord/callback?code=abc&state=def", "", ""}, 400, "invalid_request"},
+		{"logout", request{"POST", "/v1/auth/logout", "", ""}, 204, ""},
+		{"me", request{"GET", "/v1/auth/me", "", "alice-key"}, 200, ""},
+		{"me without credentials", request{"GET", "/v1/auth/me", "", ""}, 401, "unauthenticated"},
+		{"openapi", request{"GET", "/v1/openapi.yaml", "", ""}, 200, ""},
+
+		{"unknown route", request{"GET", "/v1/nothing", "", "alice-key"}, 404, "not_found"},
+		{"route outside the API", request{"GET", "/metrics", "", ""}, 404, "not_found"},
+		{"wrong method", request{"DELETE", jobPath(job, ""), "", "alice-key"}, 405, "method_not_allowed"},
+		{"wrong method on auth route", request{"PUT", "/v1/auth/logout", "", "alice-key"}, 404, "not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := tt.req.do(t, mux)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s answered %d, want %d: %s", tt.req.method, tt.req.path, resp.StatusCode, tt.wantStatus, body)
+			}
+			path, _, _ := strings.Cut(tt.req.path, "?")
+			id, decoded := s.check(t, tt.req.method, path, resp, body)
+			exercised[id] = true
+			if tt.wantCode == "" {
+				return
+			}
+			if code := asMap(asMap(decoded)["error"])["code"]; code != tt.wantCode {
+				t.Errorf("error code = %v, want %s: %s", code, tt.wantCode, body)
+			}
+		})
+	}
+
+	for _, op := range s.operations() {
+		if !exercised[op.id] {
+			t.Errorf("%s %s (%s) was not exercised", op.method, op.path, op.id)
+		}
+	}
+}
+
+// TestContractRateLimited checks the rate limit errors of
+// the routes limited per user.
+func TestContractRateLimited(t *testing.T) {
+	s := loadSpec(t)
+	limiter, err := ratelimit.New(config.RateLimit{
+		Enabled: true,
+		PerIP:   config.Bucket{Rate: 100, Burst: 100},
+		PerUser: config.Bucket{Rate: 0.001, Burst: 1},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := newServer(t, &fakeVideo{errs: map[int64]error{}}, func(authn *auth.Authenticator, h http.Handler) http.Handler {
+		return limiter.PerIP(1, authn.Middleware(limiter.PerUser(1, h)))
+	})
+	r := request{method: "POST", path: "/v1/uploads", body: `{"filename": "a.mp4", "size": 100}`, key: "alice-key"}
+	if resp, body := r.do(t, mux); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("first upload answered %d: %s", resp.StatusCode, body)
+	}
+	resp, body := r.do(t, mux)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second upload answered %d, want 429: %s", resp.StatusCode, body)
+	}
+	_, decoded := s.check(t, r.method, r.path, resp, body)
+	if code := asMap(asMap(decoded)["error"])["code"]; code != "rate_limited" {
+		t.Errorf("error code = %v, want rate_limited", code)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+}
+
+// TestOpenAPIMatchesProto checks that the document lists
+// exactly the routes of the google.api.http options of
+// VideoService, besides the auth routes and itself.
+func TestOpenAPIMatchesProto(t *testing.T) {
+	s := loadSpec(t)
+	documented := map[string]bool{}
+	for _, op := range s.operations() {
+		if strings.HasPrefix(op.path, auth.Prefix+"/") || op.path == "/v1/openapi.yaml" {
+			continue
+		}
+		documented[op.method+" "+op.path] = true
+	}
+	wildcard := regexp.MustCompile(`\{(\w+)=[^}]*\}`)
+	methods := gen.File_video_proto.Services().ByName("VideoService").Methods()
+	for i := range methods.Len() {
+		m := methods.Get(i)
+		rule, _ := proto.GetExtension(m.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if rule == nil {
+			t.Errorf("%s has no google.api.http option", m.Name())
+			continue
+		}
+		var route string
+		switch p := rule.GetPattern().(type) {
+		case *annotations.HttpRule_Get:
+			route = "GET " + p.Get
+		case *annotations.HttpRule_Post:
+			route = "POST " + p.Post
+		case *annotations.HttpRule_Put:
+			route = "PUT " + p.Put
+		case *annotations.HttpRule_Patch:
+			route = "PATCH " + p.Patch
+		case *annotations.HttpRule_Delete:
+			route = "DELETE " + p.Delete
+		}
+		route = wildcard.ReplaceAllString(route, "{$1}")
+		if !documented[route] {
+			t.Errorf("%s is served as %s, which is not documented", m.Name(), route)
+		}
+		delete(documented, route)
+	}
+	for route := range documented {
+		t.Errorf("%s is documented but served by no RPC", route)
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"ffmpeg/wrapper/gateway/internal/apierror"
+	"net/http"
+)
+
+// maxBodyBytes bounds the JSON bodies of API requests.
+const maxBodyBytes = 1 << 20
+
+// JSONErrors answers requests that match no route of mux,
+// or match it with another method, with JSON errors
+// instead of the plain text of http.ServeMux.
+func JSONErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern == "" {
+			w = &errorWriter{ResponseWriter: w}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// errorWriter replaces the 404 and 405 responses of
+// http.ServeMux. The Allow header it sets is kept.
+type errorWriter struct {
+	http.ResponseWriter
+	replaced bool
+}
+
+func (w *errorWriter) WriteHeader(code int) {
+	switch code {
+	case http.StatusNotFound:
+		w.replaced = true
+		apierror.Write(w.ResponseWriter, apierror.New(code, apierror.CodeNotFound, "no such endpoint, see /v1/openapi.yaml"))
+	case http.StatusMethodNotAllowed:
+		w.replaced = true
+		apierror.Write(w.ResponseWriter, apierror.New(code, apierror.CodeMethodNotAllowed, "method not allowed, see the Allow header"))
+	default:
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
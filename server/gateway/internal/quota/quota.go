@@ -0,0 +1,147 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"ffmpeg/wrapper/internal/config"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrNotFound is returned for jobs that do not exist or
+// belong to another user.
+var ErrNotFound = errors.New("job not found")
+
+// ErrUnavailable is returned when the quota store fails.
+var ErrUnavailable = errors.New("quota store unavailable")
+
+// Error reports an exhausted quota.
+type Error struct {
+	// Quota is "daily_jobs", "daily_upload_bytes" or
+	// "concurrent_jobs".
+	Quota string
+	Limit int64
+	// RetryAfter is when the quota frees up, or zero if
+	// it depends on running jobs finishing.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s quota of %d exceeded", e.Quota, e.Limit)
+}
+
+// Tracker enforces the per-user quotas on daily jobs,
+// daily upload bytes and concurrent jobs, and remembers
+// which user each job belongs to. Days are UTC days.
+//
+// The state is kept in the given store, e.g. Redis to
+// share it between gateway replicas and keep it across
+// restarts.
+type Tracker struct {
+	cfg   config.Quotas
+	store Store
+}
+
+// New creates a quota tracker keeping its state in store.
+func New(cfg config.Quotas, store Store) *Tracker {
+	return &Tracker{cfg: cfg, store: store}
+}
+
+// ReserveUpload counts size bytes against the daily upload
+// quota of the user.
+func (t *Tracker) ReserveUpload(ctx context.Context, userID string, size int64) error {
+	now := time.Now()
+	ok, err := t.store.AddUpload(ctx, userID, today(now), size, t.cfg.DailyUploadBytes)
+	if err != nil {
+		return unavailable(err)
+	}
+	if !ok {
+		return &Error{Quota: "daily_upload_bytes", Limit: t.cfg.DailyUploadBytes, RetryAfter: untilTomorrow(now)}
+	}
+	return nil
+}
+
+// ReleaseUpload gives back bytes reserved for an upload
+// that could not be started.
+func (t *Tracker) ReleaseUpload(ctx context.Context, userID string, size int64) {
+	if _, err := t.store.AddUpload(ctx, userID, today(time.Now()), -size, 0); err != nil {
+		log.Printf("failed to release upload quota of user %s: %v", userID, err)
+	}
+}
+
+// AddJob records that the job, compressing the object
+// under objectKey, belongs to the user. The record is
+// kept a day longer than the job may run.
+func (t *Tracker) AddJob(ctx context.Context, userID string, jobID int64, objectKey string) error {
+	j := Job{ID: jobID, UserID: userID, ObjectKey: objectKey}
+	if err := t.store.AddJob(ctx, j, 24*time.Hour+t.cfg.JobTimeout); err != nil {
+		return unavailable(err)
+	}
+	return nil
+}
+
+// Owns returns nil if the job belongs to the user, and
+// ErrNotFound otherwise.
+func (t *Tracker) Owns(ctx context.Context, userID string, jobID int64) error {
+	j, err := t.store.Job(ctx, jobID)
+	if errors.Is(err, ErrNotFound) || err == nil && j.UserID != userID {
+		return ErrNotFound
+	} else if err != nil {
+		return unavailable(err)
+	}
+	return nil
+}
+
+// OwnsObject returns nil if the object was uploaded for a
+// job of the user, and ErrNotFound otherwise.
+func (t *Tracker) OwnsObject(ctx context.Context, userID string, objectKey string) error {
+	owner, err := t.store.ObjectOwner(ctx, objectKey)
+	if errors.Is(err, ErrNotFound) || err == nil && owner != userID {
+		return ErrNotFound
+	} else if err != nil {
+		return unavailable(err)
+	}
+	return nil
+}
+
+// StartJob counts the job against the daily and concurrent
+// job quotas of its user, and returns the key of the
+// object to compress. Starting a running job again counts
+// nothing.
+func (t *Tracker) StartJob(ctx context.Context, userID string, jobID int64) (string, error) {
+	now := time.Now()
+	objectKey, exceeded, err := t.store.StartJob(ctx, userID, jobID, today(now), t.cfg)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "", ErrNotFound
+	case err != nil:
+		return "", unavailable(err)
+	case exceeded == "daily_jobs":
+		return "", &Error{Quota: exceeded, Limit: int64(t.cfg.DailyJobs), RetryAfter: untilTomorrow(now)}
+	case exceeded != "":
+		return "", &Error{Quota: exceeded, Limit: int64(t.cfg.ConcurrentJobs)}
+	}
+	return objectKey, nil
+}
+
+// FinishJob frees the concurrency slot of the job.
+func (t *Tracker) FinishJob(ctx context.Context, jobID int64) {
+	if err := t.store.FinishJob(ctx, jobID); err != nil {
+		log.Printf("failed to finish job %d, its slot is freed after the job timeout: %v", jobID, err)
+	}
+}
+
+func unavailable(err error) error {
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
+
+func today(now time.Time) string {
+	return now.UTC().Format(time.DateOnly)
+}
+
+func untilTomorrow(now time.Time) time.Duration {
+	now = now.UTC()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return tomorrow.Sub(now)
+}
@@ -0,0 +1,177 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"ffmpeg/wrapper/internal/config"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// usageTTL keeps the usage of a day until it is over in
+// every time zone.
+const usageTTL = 48 * time.Hour
+
+// uploadScript adds to the upload bytes of a day unless
+// they would exceed the limit.
+var uploadScript = redis.NewScript(`
+local size = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local bytes = tonumber(redis.call('HGET', KEYS[1], 'bytes')) or 0
+if size > 0 and limit > 0 and bytes + size > limit then
+  return 0
+end
+redis.call('HSET', KEYS[1], 'bytes', string.format('%d', math.max(0, bytes + size)))
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// startScript checks the owner and quotas of a job and
+// starts it atomically. The running jobs of a user are a
+// sorted set scored by their start in Redis milliseconds,
+// from which the jobs older than the job timeout are
+// dropped.
+var startScript = redis.NewScript(`
+local job = redis.call('HMGET', KEYS[1], 'user', 'object')
+if job[1] ~= ARGV[1] then
+  return {'', 'not_found'}
+end
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local timeout = tonumber(ARGV[5])
+redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', now - timeout)
+if redis.call('ZSCORE', KEYS[3], ARGV[2]) then
+  return {job[2], ''}
+end
+local daily = tonumber(ARGV[3])
+if daily > 0 and (tonumber(redis.call('HGET', KEYS[2], 'jobs')) or 0) >= daily then
+  return {'', 'daily_jobs'}
+end
+local concurrent = tonumber(ARGV[4])
+if concurrent > 0 and redis.call('ZCARD', KEYS[3]) >= concurrent then
+  return {'', 'concurrent_jobs'}
+end
+redis.call('HINCRBY', KEYS[2], 'jobs', 1)
+redis.call('PEXPIRE', KEYS[2], ARGV[6])
+redis.call('ZADD', KEYS[3], now, ARGV[2])
+redis.call('PEXPIRE', KEYS[3], timeout)
+return {job[2], ''}
+`)
+
+// RedisStore keeps the usage and jobs in Redis, shared by
+// the gateway replicas.
+type RedisStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+// NewRedisStore creates a store on the given Redis client.
+// Each command is bounded by timeout.
+func NewRedisStore(client *redis.Client, timeout time.Duration) *RedisStore {
+	return &RedisStore{client: client, timeout: timeout}
+}
+
+func (s *RedisStore) AddUpload(ctx context.Context, userID string, day string, size int64, limit int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	res, err := uploadScript.Run(ctx, s.client, []string{usageKey(userID, day)}, size, limit, usageTTL.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("failed to add upload of user %s: %w", userID, err)
+	}
+	return res == 1, nil
+}
+
+func (s *RedisStore) AddJob(ctx context.Context, j Job, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, jobKey(j.ID), "user", j.UserID, "object", j.ObjectKey)
+		p.PExpire(ctx, jobKey(j.ID), ttl)
+		p.Set(ctx, objectKey(j.ObjectKey), j.UserID, ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record job %d: %w", j.ID, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Job(ctx context.Context, jobID int64) (Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	fields, err := s.client.HMGet(ctx, jobKey(jobID), "user", "object").Result()
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to read job %d: %w", jobID, err)
+	}
+	userID, _ := fields[0].(string)
+	object, _ := fields[1].(string)
+	if userID == "" {
+		return Job{}, ErrNotFound
+	}
+	return Job{ID: jobID, UserID: userID, ObjectKey: object}, nil
+}
+
+func (s *RedisStore) ObjectOwner(ctx context.Context, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	userID, err := s.client.Get(ctx, objectKey(key)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read owner of %s: %w", key, err)
+	}
+	return userID, nil
+}
+
+func (s *RedisStore) StartJob(ctx context.Context, userID string, jobID int64, day string, q config.Quotas) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	keys := []string{jobKey(jobID), usageKey(userID, day), runningKey(userID)}
+	res, err := startScript.Run(ctx, s.client, keys, userID, jobID, q.DailyJobs, q.ConcurrentJobs,
+		q.JobTimeout.Milliseconds(), usageTTL.Milliseconds()).StringSlice()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to start job %d: %w", jobID, err)
+	}
+	if len(res) != 2 {
+		return "", "", fmt.Errorf("unexpected start script result %v", res)
+	}
+	if res[1] == "not_found" {
+		return "", "", ErrNotFound
+	}
+	return res[0], res[1], nil
+}
+
+func (s *RedisStore) FinishJob(ctx context.Context, jobID int64) error {
+	j, err := s.Job(ctx, jobID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.client.ZRem(ctx, runningKey(j.UserID), strconv.FormatInt(jobID, 10)).Err(); err != nil {
+		return fmt.Errorf("failed to finish job %d: %w", jobID, err)
+	}
+	return nil
+}
+
+func usageKey(userID string, day string) string {
+	return "quota:usage:" + userID + ":" + day
+}
+
+func jobKey(jobID int64) string {
+	return "quota:job:" + strconv.FormatInt(jobID, 10)
+}
+
+// objectKey indexes the owner of each uploaded object.
+func objectKey(key string) string {
+	return "quota:object:" + key
+}
+
+func runningKey(userID string) string {
+	return "quota:running:" + userID
+}
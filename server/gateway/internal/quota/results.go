@@ -0,0 +1,61 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Consume frees the concurrency slot of every job whose
+// result is read from reader, until ctx is done. A result
+// is committed once its slot is freed.
+func (t *Tracker) Consume(ctx context.Context, reader *kafka.Reader) error {
+	for {
+		m, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("kafka reading error: %v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		var event compressionmodel.CompressionResultEvent
+		if err := json.Unmarshal(m.Value, &event); err == nil && event.CompressionEventType != "" &&
+			event.CompressionEventType != compressionmodel.CompressionEventTypeProcessing {
+			if !t.finish(ctx, event.JobID) {
+				return nil
+			}
+		}
+		if err := reader.CommitMessages(context.WithoutCancel(ctx), m); err != nil {
+			log.Printf("failed to commit offset %d of %s/%d: %v", m.Offset, m.Topic, m.Partition, err)
+		}
+	}
+}
+
+// finish frees the slot of the job, retrying while the
+// store fails. It returns false if ctx is done first.
+func (t *Tracker) finish(ctx context.Context, jobID int64) bool {
+	backoff := 250 * time.Millisecond
+	for {
+		err := t.store.FinishJob(ctx, jobID)
+		if err == nil {
+			return true
+		}
+		log.Printf("failed to free the slot of job %d: %v", jobID, err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
@@ -0,0 +1,202 @@
+package quota
+
+import (
+	"context"
+	"ffmpeg/wrapper/internal/config"
+	"sync"
+	"time"
+)
+
+// Store keeps the quota usage of every user and the owner
+// of every job.
+type Store interface {
+	// AddUpload adds size bytes to the upload usage of the
+	// user on day, unless it would exceed limit. A negative
+	// size gives bytes back, and a zero limit disables the
+	// check. It reports whether the bytes were added.
+	AddUpload(ctx context.Context, userID string, day string, size int64, limit int64) (bool, error)
+	// AddJob records the owner of the job and the object
+	// it compresses, for ttl.
+	AddJob(ctx context.Context, j Job, ttl time.Duration) error
+	// Job returns the job, or ErrNotFound.
+	Job(ctx context.Context, jobID int64) (Job, error)
+	// ObjectOwner returns the user of the job uploading the
+	// object under objectKey, or ErrNotFound.
+	ObjectOwner(ctx context.Context, objectKey string) (string, error)
+	// StartJob counts the job of the user against its daily
+	// and concurrent job quotas on day, and returns the key
+	// of its object. It returns the name of the exhausted
+	// quota instead of starting the job, and ErrNotFound if
+	// the job is not the user's. Starting a running job
+	// again counts nothing.
+	StartJob(ctx context.Context, userID string, jobID int64, day string, q config.Quotas) (string, string, error)
+	// FinishJob frees the concurrency slot of the job.
+	FinishJob(ctx context.Context, jobID int64) error
+}
+
+// Job is a job recorded by the tracker.
+type Job struct {
+	ID        int64
+	UserID    string
+	ObjectKey string
+}
+
+// MemoryStore keeps the usage and jobs in memory, for a
+// single gateway replica. They are lost on restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	usage   map[string]*usage
+	jobs    map[int64]*job
+	objects map[string]int64
+}
+
+type usage struct {
+	day   string
+	jobs  int
+	bytes int64
+}
+
+type job struct {
+	Job
+	expires time.Time
+	// started is set once the job is enqueued, and cleared
+	// once it finishes.
+	started time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		usage:   map[string]*usage{},
+		jobs:    map[int64]*job{},
+		objects: map[string]int64{},
+	}
+}
+
+func (s *MemoryStore) AddUpload(_ context.Context, userID string, day string, size int64, limit int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.today(userID, day)
+	if size > 0 && limit > 0 && u.bytes+size > limit {
+		return false, nil
+	}
+	u.bytes = max(0, u.bytes+size)
+	return true, nil
+}
+
+func (s *MemoryStore) AddJob(_ context.Context, j Job, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.ID] = &job{Job: j, expires: time.Now().Add(ttl)}
+	s.objects[j.ObjectKey] = j.ID
+	return nil
+}
+
+func (s *MemoryStore) Job(_ context.Context, jobID int64) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[jobID]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return j.Job, nil
+}
+
+func (s *MemoryStore) ObjectOwner(_ context.Context, objectKey string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[s.objects[objectKey]]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return j.UserID, nil
+}
+
+func (s *MemoryStore) StartJob(_ context.Context, userID string, jobID int64, day string, q config.Quotas) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[jobID]
+	if !ok || j.UserID != userID {
+		return "", "", ErrNotFound
+	}
+	now := time.Now()
+	if j.running(now, q.JobTimeout) {
+		return j.ObjectKey, "", nil
+	}
+	u := s.today(userID, day)
+	if q.DailyJobs > 0 && u.jobs >= q.DailyJobs {
+		return "", "daily_jobs", nil
+	}
+	if q.ConcurrentJobs > 0 {
+		n := 0
+		for _, other := range s.jobs {
+			if other.UserID == userID && other.running(now, q.JobTimeout) {
+				n++
+			}
+		}
+		if n >= q.ConcurrentJobs {
+			return "", "concurrent_jobs", nil
+		}
+	}
+	u.jobs++
+	j.started = now
+	return j.ObjectKey, "", nil
+}
+
+func (s *MemoryStore) FinishJob(_ context.Context, jobID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[jobID]; ok {
+		j.started = time.Time{}
+	}
+	return nil
+}
+
+// Run forgets the usage of past days and expired jobs
+// every hour, until ctx is done.
+func (s *MemoryStore) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		s.prune(time.Now())
+	}
+}
+
+func (s *MemoryStore) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day := today(now)
+	for userID, u := range s.usage {
+		if u.day != day {
+			delete(s.usage, userID)
+		}
+	}
+	for jobID, j := range s.jobs {
+		if now.After(j.expires) {
+			delete(s.jobs, jobID)
+			delete(s.objects, j.ObjectKey)
+		}
+	}
+}
+
+// today returns the usage of the user on day. It must be
+// called with the store locked.
+func (s *MemoryStore) today(userID string, day string) *usage {
+	u, ok := s.usage[userID]
+	if !ok || u.day != day {
+		u = &usage{day: day}
+		s.usage[userID] = u
+	}
+	return u
+}
+
+// running reports whether the job holds a concurrency
+// slot. Jobs that never finish give it back after timeout.
+func (j *job) running(now time.Time, timeout time.Duration) bool {
+	return !j.started.IsZero() && now.Sub(j.started) < timeout
+}
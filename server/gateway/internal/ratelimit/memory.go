@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"ffmpeg/wrapper/internal/config"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps token buckets in memory, so each
+// gateway replica limits the requests it serves.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket is full again, after which it
+	// can be forgotten.
+	full time.Time
+}
+
+// NewMemoryStore creates an in-memory bucket store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+// Take removes cost tokens from the bucket under key if it
+// holds enough.
+func (s *MemoryStore) Take(ctx context.Context, key string, b config.Bucket, cost int) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	bk, ok := s.buckets[key]
+	if !ok {
+		bk = &bucket{tokens: float64(b.Burst), last: now}
+		s.buckets[key] = bk
+	}
+	bk.tokens = min(float64(b.Burst), bk.tokens+now.Sub(bk.last).Seconds()*b.Rate)
+	bk.last = now
+	if bk.tokens < float64(cost) {
+		return false, seconds((float64(cost) - bk.tokens) / b.Rate), nil
+	}
+	bk.tokens -= float64(cost)
+	bk.full = now.Add(seconds((float64(b.Burst) - bk.tokens) / b.Rate))
+	return true, 0, nil
+}
+
+// Run forgets buckets that are full again every minute,
+// until ctx is done.
+func (s *MemoryStore) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		now := time.Now()
+		s.mu.Lock()
+		for key, bk := range s.buckets {
+			if now.After(bk.full) {
+				delete(s.buckets, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,17 @@
+package ratelimit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_rate_limited_requests_total",
+		Help: "Total number of requests rejected by rate limits, by scope.",
+	}, []string{"scope"})
+	rateLimitFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_rate_limit_fallbacks_total",
+		Help: "Total number of rate limit checks made in memory because the shared store failed.",
+	})
+)
@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"context"
+	"ffmpeg/wrapper/gateway/internal/apierror"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/user"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Store keeps the state of token buckets.
+type Store interface {
+	// Take removes cost tokens from the bucket under key if
+	// it holds enough. Otherwise it returns false and how
+	// long until the bucket holds enough tokens.
+	Take(ctx context.Context, key string, b config.Bucket, cost int) (bool, time.Duration, error)
+}
+
+// Limiter limits requests with token buckets per client IP
+// and per authenticated user. The buckets are kept in the
+// given store, e.g. Redis to share them between gateway
+// replicas, and in memory while the store fails.
+type Limiter struct {
+	cfg      config.RateLimit
+	store    Store
+	fallback *MemoryStore
+	trusted  []*net.IPNet
+	// lastFallback is the time the last fallback to memory
+	// was logged, in Unix seconds.
+	lastFallback atomic.Int64
+}
+
+// New creates a limiter keeping its buckets in store, or
+// only in memory if store is nil.
+func New(cfg config.RateLimit, store Store) (*Limiter, error) {
+	l := &Limiter{cfg: cfg, store: store, fallback: NewMemoryStore()}
+	for _, p := range cfg.TrustedProxies {
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", p, err)
+		}
+		l.trusted = append(l.trusted, n)
+	}
+	return l, nil
+}
+
+// Run forgets idle in-memory buckets until ctx is done.
+func (l *Limiter) Run(ctx context.Context) error {
+	return l.fallback.Run(ctx)
+}
+
+// PerIP limits the requests of each client IP, taking cost
+// tokens per request. IPv6 clients are limited per /64.
+func (l *Limiter) PerIP(cost int, next http.Handler) http.Handler {
+	if !l.cfg.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := l.clientIP(r)
+		if ip == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if l.allow(w, r, "ip", "ratelimit:ip:"+ip, l.cfg.PerIP, cost) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// PerUser limits the requests of each user, taking cost
+// tokens per request. It must run after authentication.
+// Anonymous requests are only limited per IP, as they all
+// share the anonymous user.
+func (l *Limiter) PerUser(cost int, next http.Handler) http.Handler {
+	if !l.cfg.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := user.ID(r.Context())
+		if id == "" || id == user.Anonymous {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if l.allow(w, r, "user", "ratelimit:user:"+id, l.cfg.PerUser, cost) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allow takes cost tokens from the bucket, and answers 429
+// Too Many Requests if it does not hold enough.
+func (l *Limiter) allow(w http.ResponseWriter, r *http.Request, scope string, key string, b config.Bucket, cost int) bool {
+	// A cost above the burst could never be paid.
+	cost = min(cost, b.Burst)
+	ok, retryAfter, err := l.take(r.Context(), key, b, cost)
+	if err != nil {
+		// Failing open keeps the gateway up when both stores
+		// fail, which only happens on a cancelled request.
+		return true
+	}
+	if ok {
+		return true
+	}
+	rateLimited.WithLabelValues(scope).Inc()
+	apiErr := apierror.New(http.StatusTooManyRequests, apierror.CodeRateLimited, "rate limit exceeded")
+	apiErr.RetryAfter = int(math.Ceil(max(retryAfter, time.Second).Seconds()))
+	apierror.Write(w, apiErr)
+	return false
+}
+
+func (l *Limiter) take(ctx context.Context, key string, b config.Bucket, cost int) (bool, time.Duration, error) {
+	if l.store != nil {
+		ok, retryAfter, err := l.store.Take(ctx, key, b, cost)
+		if err == nil {
+			return ok, retryAfter, nil
+		}
+		rateLimitFallbacks.Inc()
+		now := time.Now().Unix()
+		if last := l.lastFallback.Load(); now-last >= 60 && l.lastFallback.CompareAndSwap(last, now) {
+			log.Printf("rate limit store failed, limiting in memory: %v", err)
+		}
+	}
+	return l.fallback.Take(ctx, key, b, cost)
+}
+
+// clientIP returns the IP of the client, read from the
+// X-Forwarded-For header when the request comes through a
+// trusted proxy. The header is read from the right, so
+// that clients cannot choose their IP by sending it.
+func (l *Limiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if l.isTrusted(ip) {
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !l.isTrusted(hop) {
+				break
+			}
+		}
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return ip.String()
+}
+
+func (l *Limiter) isTrusted(ip net.IP) bool {
+	for _, n := range l.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"ffmpeg/wrapper/internal/config"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills and takes from a bucket atomically.
+// It uses the Redis clock, so that replicas with skewed
+// clocks agree. Buckets expire once they are full again.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed, retry = 0, 0
+if tokens >= cost then
+  tokens = tokens - cost
+  allowed = 1
+else
+  retry = math.ceil((cost - tokens) * 1000 / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+return {allowed, retry}
+`)
+
+// RedisStore keeps token buckets in Redis, so that the
+// limits hold across gateway replicas.
+type RedisStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+// NewRedisStore creates a bucket store on the given Redis
+// client. Each command is bounded by timeout.
+func NewRedisStore(client *redis.Client, timeout time.Duration) *RedisStore {
+	return &RedisStore{client: client, timeout: timeout}
+}
+
+// Take removes cost tokens from the bucket under key if it
+// holds enough.
+func (s *RedisStore) Take(ctx context.Context, key string, b config.Bucket, cost int) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	res, err := takeScript.Run(ctx, s.client, []string{key}, b.Rate, b.Burst, cost).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take from bucket %s: %w", key, err)
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected bucket script result %v", res)
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
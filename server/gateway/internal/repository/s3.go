@@ -1,75 +0,0 @@
-package repository
-
-import (
-	"context"
-	"errors"
-	"fmt"
-	"log"
-	"time"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"go.opentelemetry.io/otel"
-)
-
-// S3Actions wraps S3 service actions.
-type S3Actions struct {
-	S3Client *s3.Client
-}
-
-func New(client *s3.Client) S3Actions {
-	return S3Actions{
-		S3Client: client,
-	}
-}
-
-const tracerID = "gateway-repository-s3"
-
-// DeleteObjects deletes a list of objects from a bucket.
-func (actor S3Actions) DeleteObjects(ctx context.Context, bucket string, objects []types.ObjectIdentifier, bypassGovernance bool) error {
-	_, span := otel.Tracer(tracerID).Start(ctx, "Repository/DeleteObjects")
-	defer span.End()
-
-	if len(objects) == 0 {
-		return nil
-	}
-
-	input := s3.DeleteObjectsInput{
-		Bucket: aws.String(bucket),
-		Delete: &types.Delete{
-			Objects: objects,
-			Quiet:   aws.Bool(true),
-		},
-	}
-	if bypassGovernance {
-		input.BypassGovernanceRetention = aws.Bool(true)
-	}
-	delOut, err := actor.S3Client.DeleteObjects(ctx, &input)
-	if err != nil || len(delOut.Errors) > 0 {
-		log.Printf("Error deleting objects from bucket %s %v.\n", bucket, err)
-		if err != nil {
-			var noBucket *types.NoSuchBucket
-			if errors.As(err, &noBucket) {
-				log.Printf("Bucket %s does not exist.\n", bucket)
-				err = noBucket
-			}
-		} else if len(delOut.Errors) > 0 {
-			for _, outErr := range delOut.Errors {
-				log.Printf("%s: %s\n", *outErr.Key, *outErr.Message)
-			}
-			err = fmt.Errorf("%s", *delOut.Errors[0].Message)
-		}
-	} else {
-		for _, delObjs := range delOut.Deleted {
-			err = s3.NewObjectNotExistsWaiter(actor.S3Client).Wait(
-				ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: delObjs.Key}, time.Minute)
-			if err != nil {
-				log.Printf("Failed attempt to wait for object %s to be deleted.\n", *delObjs.Key)
-			} else {
-				log.Printf("Deleted %s.\n", *delObjs.Key)
-			}
-		}
-	}
-	return err
-}
@@ -7,8 +7,10 @@
 package gen
 
 import (
+	_ "google.golang.org/genproto/googleapis/api/annotations"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -444,7 +446,7 @@ func (x *GetVideoDetailsRequest) GetPath() string {
 type GetVideoDetailsResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Link          string                 `protobuf:"bytes,1,opt,name=link,proto3" json:"link,omitempty"`
-	OldMetadata   *Metadata              `protobuf:"bytes,2,opt,name=oldMetadata,proto3" json:"oldMetadata,omitempty"`
+	OldMetadata   *Metadata              `protobuf:"bytes,2,opt,name=old_metadata,json=oldMetadata,proto3" json:"old_metadata,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -555,7 +557,7 @@ func (x *PresignedRequest) GetHeaders() map[string]string {
 
 type GetCompressionJobResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	JobId         int64                  `protobuf:"varint,1,opt,name=jobId,proto3" json:"jobId,omitempty"`
+	JobId         int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
 	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -606,9 +608,17 @@ func (x *GetCompressionJobResponse) GetStatus() string {
 }
 
 type GetCompressionJobRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	JobId         int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
-	ObjectKey     string                 `protobuf:"bytes,2,opt,name=object_key,json=objectKey,proto3" json:"object_key,omitempty"`
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	JobId     int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	ObjectKey string                 `protobuf:"bytes,2,opt,name=object_key,json=objectKey,proto3" json:"object_key,omitempty"`
+	// When set, the result of the job is POSTed to this URL,
+	// signed with callback_secret.
+	CallbackUrl    string `protobuf:"bytes,3,opt,name=callback_url,json=callbackUrl,proto3" json:"callback_url,omitempty"`
+	CallbackSecret string `protobuf:"bytes,4,opt,name=callback_secret,json=callbackSecret,proto3" json:"callback_secret,omitempty"`
+	// premium, interactive or batch, interactive when unset.
+	Priority string `protobuf:"bytes,5,opt,name=priority,proto3" json:"priority,omitempty"`
+	// video, gif or webp, video when unset.
+	OutputFormat  string `protobuf:"bytes,6,opt,name=output_format,json=outputFormat,proto3" json:"output_format,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -657,9 +667,40 @@ func (x *GetCompressionJobRequest) GetObjectKey() string {
 	return ""
 }
 
+func (x *GetCompressionJobRequest) GetCallbackUrl() string {
+	if x != nil {
+		return x.CallbackUrl
+	}
+	return ""
+}
+
+func (x *GetCompressionJobRequest) GetCallbackSecret() string {
+	if x != nil {
+		return x.CallbackSecret
+	}
+	return ""
+}
+
+func (x *GetCompressionJobRequest) GetPriority() string {
+	if x != nil {
+		return x.Priority
+	}
+	return ""
+}
+
+func (x *GetCompressionJobRequest) GetOutputFormat() string {
+	if x != nil {
+		return x.OutputFormat
+	}
+	return ""
+}
+
 type GetUploadURLRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Filename      string                 `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Filename string                 `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	// Size of the upload in bytes. When set, the presigned
+	// URL only accepts a body of exactly this size.
+	Size          int64 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -701,11 +742,20 @@ func (x *GetUploadURLRequest) GetFilename() string {
 	return ""
 }
 
+func (x *GetUploadURLRequest) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
 type GetUploadURLResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	JobId         int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
-	PresignedUrl  *PresignedRequest      `protobuf:"bytes,2,opt,name=presigned_url,json=presignedUrl,proto3" json:"presigned_url,omitempty"`
-	ObjectKey     string                 `protobuf:"bytes,3,opt,name=object_key,json=objectKey,proto3" json:"object_key,omitempty"`
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	JobId        int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	PresignedUrl *PresignedRequest      `protobuf:"bytes,2,opt,name=presigned_url,json=presignedUrl,proto3" json:"presigned_url,omitempty"`
+	ObjectKey    string                 `protobuf:"bytes,3,opt,name=object_key,json=objectKey,proto3" json:"object_key,omitempty"`
+	// When the presigned URL expires.
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -761,6 +811,13 @@ func (x *GetUploadURLResponse) GetObjectKey() string {
 	return ""
 }
 
+func (x *GetUploadURLResponse) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
 type GetJobStatusRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	JobId         int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
@@ -806,12 +863,31 @@ func (x *GetJobStatusRequest) GetJobId() int64 {
 }
 
 type GetJobStatusResponse struct {
-	state                  protoimpl.MessageState `protogen:"open.v1"`
-	JobId                  int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
-	Status                 string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
-	CompressedPresignedUrl *PresignedRequest      `protobuf:"bytes,3,opt,name=compressed_presigned_url,json=compressedPresignedUrl,proto3" json:"compressed_presigned_url,omitempty"`
-	unknownFields          protoimpl.UnknownFields
-	sizeCache              protoimpl.SizeCache
+	state protoimpl.MessageState `protogen:"open.v1"`
+	JobId int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	// processing, succeeded, failed or cancelled.
+	Status      string            `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	DownloadUrl *PresignedRequest `protobuf:"bytes,3,opt,name=download_url,json=downloadUrl,proto3" json:"download_url,omitempty"`
+	// When the compressed file is deleted.
+	ExpiresAt     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	ObjectKey     string                 `protobuf:"bytes,5,opt,name=object_key,json=objectKey,proto3" json:"object_key,omitempty"`
+	CompressedKey string                 `protobuf:"bytes,6,opt,name=compressed_key,json=compressedKey,proto3" json:"compressed_key,omitempty"`
+	// Whether the job waits for a worker, and the estimated
+	// number of queued jobs that start before it.
+	Queued        bool  `protobuf:"varint,7,opt,name=queued,proto3" json:"queued,omitempty"`
+	QueuePosition int32 `protobuf:"varint,8,opt,name=queue_position,json=queuePosition,proto3" json:"queue_position,omitempty"`
+	// How a succeeded job was encoded: two_pass, chunked, crf,
+	// remux, or animated for gif and webp outputs. Empty for
+	// reused outputs.
+	Strategy string `protobuf:"bytes,9,opt,name=strategy,proto3" json:"strategy,omitempty"`
+	// How a succeeded job produced its output: compressed,
+	// reused or skipped_already_small.
+	Outcome string `protobuf:"bytes,10,opt,name=outcome,proto3" json:"outcome,omitempty"`
+	// Why a failed job failed, e.g. timeout, resource_limit
+	// or too_large.
+	FailureReason string `protobuf:"bytes,11,opt,name=failure_reason,json=failureReason,proto3" json:"failure_reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *GetJobStatusResponse) Reset() {
@@ -858,18 +934,508 @@ func (x *GetJobStatusResponse) GetStatus() string {
 	return ""
 }
 
-func (x *GetJobStatusResponse) GetCompressedPresignedUrl() *PresignedRequest {
+func (x *GetJobStatusResponse) GetDownloadUrl() *PresignedRequest {
+	if x != nil {
+		return x.DownloadUrl
+	}
+	return nil
+}
+
+func (x *GetJobStatusResponse) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *GetJobStatusResponse) GetObjectKey() string {
+	if x != nil {
+		return x.ObjectKey
+	}
+	return ""
+}
+
+func (x *GetJobStatusResponse) GetCompressedKey() string {
+	if x != nil {
+		return x.CompressedKey
+	}
+	return ""
+}
+
+func (x *GetJobStatusResponse) GetQueued() bool {
+	if x != nil {
+		return x.Queued
+	}
+	return false
+}
+
+func (x *GetJobStatusResponse) GetQueuePosition() int32 {
+	if x != nil {
+		return x.QueuePosition
+	}
+	return 0
+}
+
+func (x *GetJobStatusResponse) GetStrategy() string {
+	if x != nil {
+		return x.Strategy
+	}
+	return ""
+}
+
+func (x *GetJobStatusResponse) GetOutcome() string {
+	if x != nil {
+		return x.Outcome
+	}
+	return ""
+}
+
+func (x *GetJobStatusResponse) GetFailureReason() string {
+	if x != nil {
+		return x.FailureReason
+	}
+	return ""
+}
+
+type GetWebhookDeliveriesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	JobId         int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetWebhookDeliveriesRequest) Reset() {
+	*x = GetWebhookDeliveriesRequest{}
+	mi := &file_video_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetWebhookDeliveriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetWebhookDeliveriesRequest) ProtoMessage() {}
+
+func (x *GetWebhookDeliveriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_video_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetWebhookDeliveriesRequest.ProtoReflect.Descriptor instead.
+func (*GetWebhookDeliveriesRequest) Descriptor() ([]byte, []int) {
+	return file_video_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *GetWebhookDeliveriesRequest) GetJobId() int64 {
+	if x != nil {
+		return x.JobId
+	}
+	return 0
+}
+
+type GetWebhookDeliveriesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Deliveries    []*WebhookDelivery     `protobuf:"bytes,1,rep,name=deliveries,proto3" json:"deliveries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetWebhookDeliveriesResponse) Reset() {
+	*x = GetWebhookDeliveriesResponse{}
+	mi := &file_video_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetWebhookDeliveriesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetWebhookDeliveriesResponse) ProtoMessage() {}
+
+func (x *GetWebhookDeliveriesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_video_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetWebhookDeliveriesResponse.ProtoReflect.Descriptor instead.
+func (*GetWebhookDeliveriesResponse) Descriptor() ([]byte, []int) {
+	return file_video_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *GetWebhookDeliveriesResponse) GetDeliveries() []*WebhookDelivery {
+	if x != nil {
+		return x.Deliveries
+	}
+	return nil
+}
+
+// WebhookDelivery is an attempt to POST the result of a
+// job to its callback URL.
+type WebhookDelivery struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The same for all attempts of a delivery, and sent as
+	// the X-Webhook-ID header.
+	Id          string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	JobId       int64                  `protobuf:"varint,2,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	Attempt     int32                  `protobuf:"varint,3,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	Url         string                 `protobuf:"bytes,4,opt,name=url,proto3" json:"url,omitempty"`
+	AttemptedAt *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=attempted_at,json=attemptedAt,proto3" json:"attempted_at,omitempty"`
+	// The HTTP status of the response, if any.
+	StatusCode int32  `protobuf:"varint,6,opt,name=status_code,json=statusCode,proto3" json:"status_code,omitempty"`
+	Error      string `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+	Succeeded  bool   `protobuf:"varint,8,opt,name=succeeded,proto3" json:"succeeded,omitempty"`
+	// When the next attempt is made, unset after the last.
+	NextAttemptAt *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=next_attempt_at,json=nextAttemptAt,proto3" json:"next_attempt_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WebhookDelivery) Reset() {
+	*x = WebhookDelivery{}
+	mi := &file_video_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WebhookDelivery) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WebhookDelivery) ProtoMessage() {}
+
+func (x *WebhookDelivery) ProtoReflect() protoreflect.Message {
+	mi := &file_video_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WebhookDelivery.ProtoReflect.Descriptor instead.
+func (*WebhookDelivery) Descriptor() ([]byte, []int) {
+	return file_video_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *WebhookDelivery) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *WebhookDelivery) GetJobId() int64 {
+	if x != nil {
+		return x.JobId
+	}
+	return 0
+}
+
+func (x *WebhookDelivery) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *WebhookDelivery) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *WebhookDelivery) GetAttemptedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.AttemptedAt
+	}
+	return nil
+}
+
+func (x *WebhookDelivery) GetStatusCode() int32 {
+	if x != nil {
+		return x.StatusCode
+	}
+	return 0
+}
+
+func (x *WebhookDelivery) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *WebhookDelivery) GetSucceeded() bool {
+	if x != nil {
+		return x.Succeeded
+	}
+	return false
+}
+
+func (x *WebhookDelivery) GetNextAttemptAt() *timestamppb.Timestamp {
 	if x != nil {
-		return x.CompressedPresignedUrl
+		return x.NextAttemptAt
 	}
 	return nil
 }
 
+type GetDownloadURLRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	JobId int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	// Lifetime of the URL, at most and by default
+	// limits.downloadURLLifetime.
+	LifetimeSeconds int64 `protobuf:"varint,2,opt,name=lifetime_seconds,json=lifetimeSeconds,proto3" json:"lifetime_seconds,omitempty"`
+	// The output and the upload of the job, set by the video
+	// service for the metadata service.
+	ObjectKey     string `protobuf:"bytes,3,opt,name=object_key,json=objectKey,proto3" json:"object_key,omitempty"`
+	SourceKey     string `protobuf:"bytes,4,opt,name=source_key,json=sourceKey,proto3" json:"source_key,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDownloadURLRequest) Reset() {
+	*x = GetDownloadURLRequest{}
+	mi := &file_video_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDownloadURLRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDownloadURLRequest) ProtoMessage() {}
+
+func (x *GetDownloadURLRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_video_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDownloadURLRequest.ProtoReflect.Descriptor instead.
+func (*GetDownloadURLRequest) Descriptor() ([]byte, []int) {
+	return file_video_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GetDownloadURLRequest) GetJobId() int64 {
+	if x != nil {
+		return x.JobId
+	}
+	return 0
+}
+
+func (x *GetDownloadURLRequest) GetLifetimeSeconds() int64 {
+	if x != nil {
+		return x.LifetimeSeconds
+	}
+	return 0
+}
+
+func (x *GetDownloadURLRequest) GetObjectKey() string {
+	if x != nil {
+		return x.ObjectKey
+	}
+	return ""
+}
+
+func (x *GetDownloadURLRequest) GetSourceKey() string {
+	if x != nil {
+		return x.SourceKey
+	}
+	return ""
+}
+
+type GetDownloadURLResponse struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	DownloadUrl *PresignedRequest      `protobuf:"bytes,1,opt,name=download_url,json=downloadUrl,proto3" json:"download_url,omitempty"`
+	// When the URL expires, at most when the output is
+	// deleted.
+	ExpiresAt *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	// The name the file is saved as, after the upload.
+	Filename      string `protobuf:"bytes,3,opt,name=filename,proto3" json:"filename,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDownloadURLResponse) Reset() {
+	*x = GetDownloadURLResponse{}
+	mi := &file_video_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDownloadURLResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDownloadURLResponse) ProtoMessage() {}
+
+func (x *GetDownloadURLResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_video_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDownloadURLResponse.ProtoReflect.Descriptor instead.
+func (*GetDownloadURLResponse) Descriptor() ([]byte, []int) {
+	return file_video_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *GetDownloadURLResponse) GetDownloadUrl() *PresignedRequest {
+	if x != nil {
+		return x.DownloadUrl
+	}
+	return nil
+}
+
+func (x *GetDownloadURLResponse) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *GetDownloadURLResponse) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+type CancelJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	JobId         int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelJobRequest) Reset() {
+	*x = CancelJobRequest{}
+	mi := &file_video_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelJobRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelJobRequest) ProtoMessage() {}
+
+func (x *CancelJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_video_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelJobRequest.ProtoReflect.Descriptor instead.
+func (*CancelJobRequest) Descriptor() ([]byte, []int) {
+	return file_video_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *CancelJobRequest) GetJobId() int64 {
+	if x != nil {
+		return x.JobId
+	}
+	return 0
+}
+
+type CancelJobResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	JobId int64                  `protobuf:"varint,1,opt,name=job_id,json=jobId,proto3" json:"job_id,omitempty"`
+	// processing until a worker stopped the job.
+	Status        string `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelJobResponse) Reset() {
+	*x = CancelJobResponse{}
+	mi := &file_video_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelJobResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelJobResponse) ProtoMessage() {}
+
+func (x *CancelJobResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_video_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelJobResponse.ProtoReflect.Descriptor instead.
+func (*CancelJobResponse) Descriptor() ([]byte, []int) {
+	return file_video_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *CancelJobResponse) GetJobId() int64 {
+	if x != nil {
+		return x.JobId
+	}
+	return 0
+}
+
+func (x *CancelJobResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
 var File_video_proto protoreflect.FileDescriptor
 
 const file_video_proto_rawDesc = "" +
 	"\n" +
-	"\vvideo.proto\"Q\n" +
+	"\vvideo.proto\x1a\x1cgoogle/api/annotations.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"Q\n" +
 	"\x15GetCompressionRequest\x12\x1c\n" +
 	"\tvideolink\x18\x01 \x01(\tR\tvideolink\x12\x1a\n" +
 	"\bduration\x18\x02 \x01(\tR\bduration\",\n" +
@@ -904,48 +1470,104 @@ const file_video_proto_rawDesc = "" +
 	"\x13GetMetadataResponse\x12%\n" +
 	"\bmetadata\x18\x01 \x01(\v2\t.MetadataR\bmetadata\",\n" +
 	"\x16GetVideoDetailsRequest\x12\x12\n" +
-	"\x04path\x18\x01 \x01(\tR\x04path\"Z\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\"[\n" +
 	"\x17GetVideoDetailsResponse\x12\x12\n" +
-	"\x04link\x18\x01 \x01(\tR\x04link\x12+\n" +
-	"\voldMetadata\x18\x02 \x01(\v2\t.MetadataR\voldMetadata\"\xb2\x01\n" +
+	"\x04link\x18\x01 \x01(\tR\x04link\x12,\n" +
+	"\fold_metadata\x18\x02 \x01(\v2\t.MetadataR\voldMetadata\"\xb2\x01\n" +
 	"\x10PresignedRequest\x12\x16\n" +
 	"\x06method\x18\x01 \x01(\tR\x06method\x12\x10\n" +
 	"\x03url\x18\x02 \x01(\tR\x03url\x128\n" +
 	"\aheaders\x18\x03 \x03(\v2\x1e.PresignedRequest.HeadersEntryR\aheaders\x1a:\n" +
 	"\fHeadersEntry\x12\x10\n" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
-	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"I\n" +
-	"\x19GetCompressionJobResponse\x12\x14\n" +
-	"\x05jobId\x18\x01 \x01(\x03R\x05jobId\x12\x16\n" +
-	"\x06status\x18\x02 \x01(\tR\x06status\"P\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"J\n" +
+	"\x19GetCompressionJobResponse\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\"\xdd\x01\n" +
 	"\x18GetCompressionJobRequest\x12\x15\n" +
 	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\x12\x1d\n" +
 	"\n" +
-	"object_key\x18\x02 \x01(\tR\tobjectKey\"1\n" +
+	"object_key\x18\x02 \x01(\tR\tobjectKey\x12!\n" +
+	"\fcallback_url\x18\x03 \x01(\tR\vcallbackUrl\x12'\n" +
+	"\x0fcallback_secret\x18\x04 \x01(\tR\x0ecallbackSecret\x12\x1a\n" +
+	"\bpriority\x18\x05 \x01(\tR\bpriority\x12#\n" +
+	"\routput_format\x18\x06 \x01(\tR\foutputFormat\"E\n" +
 	"\x13GetUploadURLRequest\x12\x1a\n" +
-	"\bfilename\x18\x01 \x01(\tR\bfilename\"\x84\x01\n" +
+	"\bfilename\x18\x01 \x01(\tR\bfilename\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\"\xbf\x01\n" +
 	"\x14GetUploadURLResponse\x12\x15\n" +
 	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\x126\n" +
 	"\rpresigned_url\x18\x02 \x01(\v2\x11.PresignedRequestR\fpresignedUrl\x12\x1d\n" +
 	"\n" +
-	"object_key\x18\x03 \x01(\tR\tobjectKey\",\n" +
+	"object_key\x18\x03 \x01(\tR\tobjectKey\x129\n" +
+	"\n" +
+	"expires_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\",\n" +
 	"\x13GetJobStatusRequest\x12\x15\n" +
-	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\"\x92\x01\n" +
+	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\"\x98\x03\n" +
 	"\x14GetJobStatusResponse\x12\x15\n" +
 	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\x12\x16\n" +
-	"\x06status\x18\x02 \x01(\tR\x06status\x12K\n" +
-	"\x18compressed_presigned_url\x18\x03 \x01(\v2\x11.PresignedRequestR\x16compressedPresignedUrl2W\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x124\n" +
+	"\fdownload_url\x18\x03 \x01(\v2\x11.PresignedRequestR\vdownloadUrl\x129\n" +
+	"\n" +
+	"expires_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12\x1d\n" +
+	"\n" +
+	"object_key\x18\x05 \x01(\tR\tobjectKey\x12%\n" +
+	"\x0ecompressed_key\x18\x06 \x01(\tR\rcompressedKey\x12\x16\n" +
+	"\x06queued\x18\a \x01(\bR\x06queued\x12%\n" +
+	"\x0equeue_position\x18\b \x01(\x05R\rqueuePosition\x12\x1a\n" +
+	"\bstrategy\x18\t \x01(\tR\bstrategy\x12\x18\n" +
+	"\aoutcome\x18\n" +
+	" \x01(\tR\aoutcome\x12%\n" +
+	"\x0efailure_reason\x18\v \x01(\tR\rfailureReason\"4\n" +
+	"\x1bGetWebhookDeliveriesRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\"P\n" +
+	"\x1cGetWebhookDeliveriesResponse\x120\n" +
+	"\n" +
+	"deliveries\x18\x01 \x03(\v2\x10.WebhookDeliveryR\n" +
+	"deliveries\"\xbc\x02\n" +
+	"\x0fWebhookDelivery\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x15\n" +
+	"\x06job_id\x18\x02 \x01(\x03R\x05jobId\x12\x18\n" +
+	"\aattempt\x18\x03 \x01(\x05R\aattempt\x12\x10\n" +
+	"\x03url\x18\x04 \x01(\tR\x03url\x12=\n" +
+	"\fattempted_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\vattemptedAt\x12\x1f\n" +
+	"\vstatus_code\x18\x06 \x01(\x05R\n" +
+	"statusCode\x12\x14\n" +
+	"\x05error\x18\a \x01(\tR\x05error\x12\x1c\n" +
+	"\tsucceeded\x18\b \x01(\bR\tsucceeded\x12B\n" +
+	"\x0fnext_attempt_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\rnextAttemptAt\"\x97\x01\n" +
+	"\x15GetDownloadURLRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\x12)\n" +
+	"\x10lifetime_seconds\x18\x02 \x01(\x03R\x0flifetimeSeconds\x12\x1d\n" +
+	"\n" +
+	"object_key\x18\x03 \x01(\tR\tobjectKey\x12\x1d\n" +
+	"\n" +
+	"source_key\x18\x04 \x01(\tR\tsourceKey\"\xa5\x01\n" +
+	"\x16GetDownloadURLResponse\x124\n" +
+	"\fdownload_url\x18\x01 \x01(\v2\x11.PresignedRequestR\vdownloadUrl\x129\n" +
+	"\n" +
+	"expires_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x12\x1a\n" +
+	"\bfilename\x18\x03 \x01(\tR\bfilename\")\n" +
+	"\x10CancelJobRequest\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\"B\n" +
+	"\x11CancelJobResponse\x12\x15\n" +
+	"\x06job_id\x18\x01 \x01(\x03R\x05jobId\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status2W\n" +
 	"\x12CompressionService\x12A\n" +
-	"\x0eGetCompression\x12\x16.GetCompressionRequest\x1a\x17.GetCompressionResponse2\xd4\x01\n" +
+	"\x0eGetCompression\x12\x16.GetCompressionRequest\x1a\x17.GetCompressionResponse2\x97\x02\n" +
 	"\x0fMetadataService\x128\n" +
 	"\vGetMetadata\x12\x13.GetMetadataRequest\x1a\x14.GetMetadataResponse\x12;\n" +
 	"\fGetUploadURL\x12\x14.GetUploadURLRequest\x1a\x15.GetUploadURLResponse\x12J\n" +
-	"\x11GetCompressionJob\x12\x19.GetCompressionJobRequest\x1a\x1a.GetCompressionJobResponse2\x9a\x02\n" +
-	"\fVideoService\x12D\n" +
-	"\x0fGetVideoDetails\x12\x17.GetVideoDetailsRequest\x1a\x18.GetVideoDetailsResponse\x12;\n" +
-	"\fGetUploadURL\x12\x14.GetUploadURLRequest\x1a\x15.GetUploadURLResponse\x12;\n" +
-	"\fGetJobStatus\x12\x14.GetJobStatusRequest\x1a\x15.GetJobStatusResponse\x12J\n" +
-	"\x11GetCompressionJob\x12\x19.GetCompressionJobRequest\x1a\x1a.GetCompressionJobResponseB\x06Z\x04/genb\x06proto3"
+	"\x11GetCompressionJob\x12\x19.GetCompressionJobRequest\x1a\x1a.GetCompressionJobResponse\x12A\n" +
+	"\x0eGetDownloadURL\x12\x16.GetDownloadURLRequest\x1a\x17.GetDownloadURLResponse2\xb8\x05\n" +
+	"\fVideoService\x12b\n" +
+	"\x0fGetVideoDetails\x12\x17.GetVideoDetailsRequest\x1a\x18.GetVideoDetailsResponse\"\x1c\x82\xd3\xe4\x93\x02\x16\x12\x14/v1/videos/{path=**}\x12S\n" +
+	"\fGetUploadURL\x12\x14.GetUploadURLRequest\x1a\x15.GetUploadURLResponse\"\x16\x82\xd3\xe4\x93\x02\x10:\x01*\"\v/v1/uploads\x12V\n" +
+	"\fGetJobStatus\x12\x14.GetJobStatusRequest\x1a\x15.GetJobStatusResponse\"\x19\x82\xd3\xe4\x93\x02\x13\x12\x11/v1/jobs/{job_id}\x12_\n" +
+	"\x11GetCompressionJob\x12\x19.GetCompressionJobRequest\x1a\x1a.GetCompressionJobResponse\"\x13\x82\xd3\xe4\x93\x02\r:\x01*\"\b/v1/jobs\x12y\n" +
+	"\x14GetWebhookDeliveries\x12\x1c.GetWebhookDeliveriesRequest\x1a\x1d.GetWebhookDeliveriesResponse\"$\x82\xd3\xe4\x93\x02\x1e\x12\x1c/v1/jobs/{job_id}/deliveries\x12e\n" +
+	"\x0eGetDownloadURL\x12\x16.GetDownloadURLRequest\x1a\x17.GetDownloadURLResponse\"\"\x82\xd3\xe4\x93\x02\x1c\x12\x1a/v1/jobs/{job_id}/download\x12T\n" +
+	"\tCancelJob\x12\x11.CancelJobRequest\x1a\x12.CancelJobResponse\" \x82\xd3\xe4\x93\x02\x1a\"\x18/v1/jobs/{job_id}/cancelB\x06Z\x04/genb\x06proto3"
 
 var (
 	file_video_proto_rawDescOnce sync.Once
@@ -959,53 +1581,76 @@ func file_video_proto_rawDescGZIP() []byte {
 	return file_video_proto_rawDescData
 }
 
-var file_video_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_video_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
 var file_video_proto_goTypes = []any{
-	(*GetCompressionRequest)(nil),     // 0: GetCompressionRequest
-	(*GetCompressionResponse)(nil),    // 1: GetCompressionResponse
-	(*Tags)(nil),                      // 2: Tags
-	(*Metadata)(nil),                  // 3: Metadata
-	(*GetMetadataRequest)(nil),        // 4: GetMetadataRequest
-	(*GetMetadataResponse)(nil),       // 5: GetMetadataResponse
-	(*GetVideoDetailsRequest)(nil),    // 6: GetVideoDetailsRequest
-	(*GetVideoDetailsResponse)(nil),   // 7: GetVideoDetailsResponse
-	(*PresignedRequest)(nil),          // 8: PresignedRequest
-	(*GetCompressionJobResponse)(nil), // 9: GetCompressionJobResponse
-	(*GetCompressionJobRequest)(nil),  // 10: GetCompressionJobRequest
-	(*GetUploadURLRequest)(nil),       // 11: GetUploadURLRequest
-	(*GetUploadURLResponse)(nil),      // 12: GetUploadURLResponse
-	(*GetJobStatusRequest)(nil),       // 13: GetJobStatusRequest
-	(*GetJobStatusResponse)(nil),      // 14: GetJobStatusResponse
-	nil,                               // 15: PresignedRequest.HeadersEntry
+	(*GetCompressionRequest)(nil),        // 0: GetCompressionRequest
+	(*GetCompressionResponse)(nil),       // 1: GetCompressionResponse
+	(*Tags)(nil),                         // 2: Tags
+	(*Metadata)(nil),                     // 3: Metadata
+	(*GetMetadataRequest)(nil),           // 4: GetMetadataRequest
+	(*GetMetadataResponse)(nil),          // 5: GetMetadataResponse
+	(*GetVideoDetailsRequest)(nil),       // 6: GetVideoDetailsRequest
+	(*GetVideoDetailsResponse)(nil),      // 7: GetVideoDetailsResponse
+	(*PresignedRequest)(nil),             // 8: PresignedRequest
+	(*GetCompressionJobResponse)(nil),    // 9: GetCompressionJobResponse
+	(*GetCompressionJobRequest)(nil),     // 10: GetCompressionJobRequest
+	(*GetUploadURLRequest)(nil),          // 11: GetUploadURLRequest
+	(*GetUploadURLResponse)(nil),         // 12: GetUploadURLResponse
+	(*GetJobStatusRequest)(nil),          // 13: GetJobStatusRequest
+	(*GetJobStatusResponse)(nil),         // 14: GetJobStatusResponse
+	(*GetWebhookDeliveriesRequest)(nil),  // 15: GetWebhookDeliveriesRequest
+	(*GetWebhookDeliveriesResponse)(nil), // 16: GetWebhookDeliveriesResponse
+	(*WebhookDelivery)(nil),              // 17: WebhookDelivery
+	(*GetDownloadURLRequest)(nil),        // 18: GetDownloadURLRequest
+	(*GetDownloadURLResponse)(nil),       // 19: GetDownloadURLResponse
+	(*CancelJobRequest)(nil),             // 20: CancelJobRequest
+	(*CancelJobResponse)(nil),            // 21: CancelJobResponse
+	nil,                                  // 22: PresignedRequest.HeadersEntry
+	(*timestamppb.Timestamp)(nil),        // 23: google.protobuf.Timestamp
 }
 var file_video_proto_depIdxs = []int32{
 	2,  // 0: Metadata.tags:type_name -> Tags
 	3,  // 1: GetMetadataResponse.metadata:type_name -> Metadata
-	3,  // 2: GetVideoDetailsResponse.oldMetadata:type_name -> Metadata
-	15, // 3: PresignedRequest.headers:type_name -> PresignedRequest.HeadersEntry
+	3,  // 2: GetVideoDetailsResponse.old_metadata:type_name -> Metadata
+	22, // 3: PresignedRequest.headers:type_name -> PresignedRequest.HeadersEntry
 	8,  // 4: GetUploadURLResponse.presigned_url:type_name -> PresignedRequest
-	8,  // 5: GetJobStatusResponse.compressed_presigned_url:type_name -> PresignedRequest
-	0,  // 6: CompressionService.GetCompression:input_type -> GetCompressionRequest
-	4,  // 7: MetadataService.GetMetadata:input_type -> GetMetadataRequest
-	11, // 8: MetadataService.GetUploadURL:input_type -> GetUploadURLRequest
-	10, // 9: MetadataService.GetCompressionJob:input_type -> GetCompressionJobRequest
-	6,  // 10: VideoService.GetVideoDetails:input_type -> GetVideoDetailsRequest
-	11, // 11: VideoService.GetUploadURL:input_type -> GetUploadURLRequest
-	13, // 12: VideoService.GetJobStatus:input_type -> GetJobStatusRequest
-	10, // 13: VideoService.GetCompressionJob:input_type -> GetCompressionJobRequest
-	1,  // 14: CompressionService.GetCompression:output_type -> GetCompressionResponse
-	5,  // 15: MetadataService.GetMetadata:output_type -> GetMetadataResponse
-	12, // 16: MetadataService.GetUploadURL:output_type -> GetUploadURLResponse
-	9,  // 17: MetadataService.GetCompressionJob:output_type -> GetCompressionJobResponse
-	7,  // 18: VideoService.GetVideoDetails:output_type -> GetVideoDetailsResponse
-	12, // 19: VideoService.GetUploadURL:output_type -> GetUploadURLResponse
-	14, // 20: VideoService.GetJobStatus:output_type -> GetJobStatusResponse
-	9,  // 21: VideoService.GetCompressionJob:output_type -> GetCompressionJobResponse
-	14, // [14:22] is the sub-list for method output_type
-	6,  // [6:14] is the sub-list for method input_type
-	6,  // [6:6] is the sub-list for extension type_name
-	6,  // [6:6] is the sub-list for extension extendee
-	0,  // [0:6] is the sub-list for field type_name
+	23, // 5: GetUploadURLResponse.expires_at:type_name -> google.protobuf.Timestamp
+	8,  // 6: GetJobStatusResponse.download_url:type_name -> PresignedRequest
+	23, // 7: GetJobStatusResponse.expires_at:type_name -> google.protobuf.Timestamp
+	17, // 8: GetWebhookDeliveriesResponse.deliveries:type_name -> WebhookDelivery
+	23, // 9: WebhookDelivery.attempted_at:type_name -> google.protobuf.Timestamp
+	23, // 10: WebhookDelivery.next_attempt_at:type_name -> google.protobuf.Timestamp
+	8,  // 11: GetDownloadURLResponse.download_url:type_name -> PresignedRequest
+	23, // 12: GetDownloadURLResponse.expires_at:type_name -> google.protobuf.Timestamp
+	0,  // 13: CompressionService.GetCompression:input_type -> GetCompressionRequest
+	4,  // 14: MetadataService.GetMetadata:input_type -> GetMetadataRequest
+	11, // 15: MetadataService.GetUploadURL:input_type -> GetUploadURLRequest
+	10, // 16: MetadataService.GetCompressionJob:input_type -> GetCompressionJobRequest
+	18, // 17: MetadataService.GetDownloadURL:input_type -> GetDownloadURLRequest
+	6,  // 18: VideoService.GetVideoDetails:input_type -> GetVideoDetailsRequest
+	11, // 19: VideoService.GetUploadURL:input_type -> GetUploadURLRequest
+	13, // 20: VideoService.GetJobStatus:input_type -> GetJobStatusRequest
+	10, // 21: VideoService.GetCompressionJob:input_type -> GetCompressionJobRequest
+	15, // 22: VideoService.GetWebhookDeliveries:input_type -> GetWebhookDeliveriesRequest
+	18, // 23: VideoService.GetDownloadURL:input_type -> GetDownloadURLRequest
+	20, // 24: VideoService.CancelJob:input_type -> CancelJobRequest
+	1,  // 25: CompressionService.GetCompression:output_type -> GetCompressionResponse
+	5,  // 26: MetadataService.GetMetadata:output_type -> GetMetadataResponse
+	12, // 27: MetadataService.GetUploadURL:output_type -> GetUploadURLResponse
+	9,  // 28: MetadataService.GetCompressionJob:output_type -> GetCompressionJobResponse
+	19, // 29: MetadataService.GetDownloadURL:output_type -> GetDownloadURLResponse
+	7,  // 30: VideoService.GetVideoDetails:output_type -> GetVideoDetailsResponse
+	12, // 31: VideoService.GetUploadURL:output_type -> GetUploadURLResponse
+	14, // 32: VideoService.GetJobStatus:output_type -> GetJobStatusResponse
+	9,  // 33: VideoService.GetCompressionJob:output_type -> GetCompressionJobResponse
+	16, // 34: VideoService.GetWebhookDeliveries:output_type -> GetWebhookDeliveriesResponse
+	19, // 35: VideoService.GetDownloadURL:output_type -> GetDownloadURLResponse
+	21, // 36: VideoService.CancelJob:output_type -> CancelJobResponse
+	25, // [25:37] is the sub-list for method output_type
+	13, // [13:25] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
 }
 
 func init() { file_video_proto_init() }
@@ -1019,7 +1664,7 @@ func file_video_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_video_proto_rawDesc), len(file_video_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   16,
+			NumMessages:   23,
 			NumExtensions: 0,
 			NumServices:   3,
 		},
@@ -124,6 +124,7 @@ const (
 	MetadataService_GetMetadata_FullMethodName       = "/MetadataService/GetMetadata"
 	MetadataService_GetUploadURL_FullMethodName      = "/MetadataService/GetUploadURL"
 	MetadataService_GetCompressionJob_FullMethodName = "/MetadataService/GetCompressionJob"
+	MetadataService_GetDownloadURL_FullMethodName    = "/MetadataService/GetDownloadURL"
 )
 
 // MetadataServiceClient is the client API for MetadataService service.
@@ -133,6 +134,9 @@ type MetadataServiceClient interface {
 	GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*GetMetadataResponse, error)
 	GetUploadURL(ctx context.Context, in *GetUploadURLRequest, opts ...grpc.CallOption) (*GetUploadURLResponse, error)
 	GetCompressionJob(ctx context.Context, in *GetCompressionJobRequest, opts ...grpc.CallOption) (*GetCompressionJobResponse, error)
+	// GetDownloadURL presigns the download of object_key
+	// while it is retained.
+	GetDownloadURL(ctx context.Context, in *GetDownloadURLRequest, opts ...grpc.CallOption) (*GetDownloadURLResponse, error)
 }
 
 type metadataServiceClient struct {
@@ -173,6 +177,16 @@ func (c *metadataServiceClient) GetCompressionJob(ctx context.Context, in *GetCo
 	return out, nil
 }
 
+func (c *metadataServiceClient) GetDownloadURL(ctx context.Context, in *GetDownloadURLRequest, opts ...grpc.CallOption) (*GetDownloadURLResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetDownloadURLResponse)
+	err := c.cc.Invoke(ctx, MetadataService_GetDownloadURL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetadataServiceServer is the server API for MetadataService service.
 // All implementations must embed UnimplementedMetadataServiceServer
 // for forward compatibility.
@@ -180,6 +194,9 @@ type MetadataServiceServer interface {
 	GetMetadata(context.Context, *GetMetadataRequest) (*GetMetadataResponse, error)
 	GetUploadURL(context.Context, *GetUploadURLRequest) (*GetUploadURLResponse, error)
 	GetCompressionJob(context.Context, *GetCompressionJobRequest) (*GetCompressionJobResponse, error)
+	// GetDownloadURL presigns the download of object_key
+	// while it is retained.
+	GetDownloadURL(context.Context, *GetDownloadURLRequest) (*GetDownloadURLResponse, error)
 	mustEmbedUnimplementedMetadataServiceServer()
 }
 
@@ -199,6 +216,9 @@ func (UnimplementedMetadataServiceServer) GetUploadURL(context.Context, *GetUplo
 func (UnimplementedMetadataServiceServer) GetCompressionJob(context.Context, *GetCompressionJobRequest) (*GetCompressionJobResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetCompressionJob not implemented")
 }
+func (UnimplementedMetadataServiceServer) GetDownloadURL(context.Context, *GetDownloadURLRequest) (*GetDownloadURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDownloadURL not implemented")
+}
 func (UnimplementedMetadataServiceServer) mustEmbedUnimplementedMetadataServiceServer() {}
 func (UnimplementedMetadataServiceServer) testEmbeddedByValue()                         {}
 
@@ -274,6 +294,24 @@ func _MetadataService_GetCompressionJob_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetadataService_GetDownloadURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDownloadURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).GetDownloadURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetadataService_GetDownloadURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).GetDownloadURL(ctx, req.(*GetDownloadURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MetadataService_ServiceDesc is the grpc.ServiceDesc for MetadataService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -293,26 +331,49 @@ var MetadataService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetCompressionJob",
 			Handler:    _MetadataService_GetCompressionJob_Handler,
 		},
+		{
+			MethodName: "GetDownloadURL",
+			Handler:    _MetadataService_GetDownloadURL_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "video.proto",
 }
 
 const (
-	VideoService_GetVideoDetails_FullMethodName   = "/VideoService/GetVideoDetails"
-	VideoService_GetUploadURL_FullMethodName      = "/VideoService/GetUploadURL"
-	VideoService_GetJobStatus_FullMethodName      = "/VideoService/GetJobStatus"
-	VideoService_GetCompressionJob_FullMethodName = "/VideoService/GetCompressionJob"
+	VideoService_GetVideoDetails_FullMethodName      = "/VideoService/GetVideoDetails"
+	VideoService_GetUploadURL_FullMethodName         = "/VideoService/GetUploadURL"
+	VideoService_GetJobStatus_FullMethodName         = "/VideoService/GetJobStatus"
+	VideoService_GetCompressionJob_FullMethodName    = "/VideoService/GetCompressionJob"
+	VideoService_GetWebhookDeliveries_FullMethodName = "/VideoService/GetWebhookDeliveries"
+	VideoService_GetDownloadURL_FullMethodName       = "/VideoService/GetDownloadURL"
+	VideoService_CancelJob_FullMethodName            = "/VideoService/CancelJob"
 )
 
 // VideoServiceClient is the client API for VideoService service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// VideoService is also served over HTTP/JSON by the
+// gateway, following the google.api.http options.
 type VideoServiceClient interface {
 	GetVideoDetails(ctx context.Context, in *GetVideoDetailsRequest, opts ...grpc.CallOption) (*GetVideoDetailsResponse, error)
+	// GetUploadURL creates an upload and its compression job.
 	GetUploadURL(ctx context.Context, in *GetUploadURLRequest, opts ...grpc.CallOption) (*GetUploadURLResponse, error)
+	// GetJobStatus waits up to limits.statusPollTimeout for
+	// the result of the job, then reports it as processing.
 	GetJobStatus(ctx context.Context, in *GetJobStatusRequest, opts ...grpc.CallOption) (*GetJobStatusResponse, error)
+	// GetCompressionJob starts compressing an uploaded file.
 	GetCompressionJob(ctx context.Context, in *GetCompressionJobRequest, opts ...grpc.CallOption) (*GetCompressionJobResponse, error)
+	// GetWebhookDeliveries lists the attempts to notify the
+	// callback URL of the job.
+	GetWebhookDeliveries(ctx context.Context, in *GetWebhookDeliveriesRequest, opts ...grpc.CallOption) (*GetWebhookDeliveriesResponse, error)
+	// GetDownloadURL presigns a new download URL of the
+	// output of a succeeded job, while it is retained.
+	GetDownloadURL(ctx context.Context, in *GetDownloadURLRequest, opts ...grpc.CallOption) (*GetDownloadURLResponse, error)
+	// CancelJob stops a job that has not finished. The job is
+	// reported as cancelled once a worker stopped it.
+	CancelJob(ctx context.Context, in *CancelJobRequest, opts ...grpc.CallOption) (*CancelJobResponse, error)
 }
 
 type videoServiceClient struct {
@@ -363,14 +424,60 @@ func (c *videoServiceClient) GetCompressionJob(ctx context.Context, in *GetCompr
 	return out, nil
 }
 
+func (c *videoServiceClient) GetWebhookDeliveries(ctx context.Context, in *GetWebhookDeliveriesRequest, opts ...grpc.CallOption) (*GetWebhookDeliveriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetWebhookDeliveriesResponse)
+	err := c.cc.Invoke(ctx, VideoService_GetWebhookDeliveries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *videoServiceClient) GetDownloadURL(ctx context.Context, in *GetDownloadURLRequest, opts ...grpc.CallOption) (*GetDownloadURLResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetDownloadURLResponse)
+	err := c.cc.Invoke(ctx, VideoService_GetDownloadURL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *videoServiceClient) CancelJob(ctx context.Context, in *CancelJobRequest, opts ...grpc.CallOption) (*CancelJobResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelJobResponse)
+	err := c.cc.Invoke(ctx, VideoService_CancelJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // VideoServiceServer is the server API for VideoService service.
 // All implementations must embed UnimplementedVideoServiceServer
 // for forward compatibility.
+//
+// VideoService is also served over HTTP/JSON by the
+// gateway, following the google.api.http options.
 type VideoServiceServer interface {
 	GetVideoDetails(context.Context, *GetVideoDetailsRequest) (*GetVideoDetailsResponse, error)
+	// GetUploadURL creates an upload and its compression job.
 	GetUploadURL(context.Context, *GetUploadURLRequest) (*GetUploadURLResponse, error)
+	// GetJobStatus waits up to limits.statusPollTimeout for
+	// the result of the job, then reports it as processing.
 	GetJobStatus(context.Context, *GetJobStatusRequest) (*GetJobStatusResponse, error)
+	// GetCompressionJob starts compressing an uploaded file.
 	GetCompressionJob(context.Context, *GetCompressionJobRequest) (*GetCompressionJobResponse, error)
+	// GetWebhookDeliveries lists the attempts to notify the
+	// callback URL of the job.
+	GetWebhookDeliveries(context.Context, *GetWebhookDeliveriesRequest) (*GetWebhookDeliveriesResponse, error)
+	// GetDownloadURL presigns a new download URL of the
+	// output of a succeeded job, while it is retained.
+	GetDownloadURL(context.Context, *GetDownloadURLRequest) (*GetDownloadURLResponse, error)
+	// CancelJob stops a job that has not finished. The job is
+	// reported as cancelled once a worker stopped it.
+	CancelJob(context.Context, *CancelJobRequest) (*CancelJobResponse, error)
 	mustEmbedUnimplementedVideoServiceServer()
 }
 
@@ -393,6 +500,15 @@ func (UnimplementedVideoServiceServer) GetJobStatus(context.Context, *GetJobStat
 func (UnimplementedVideoServiceServer) GetCompressionJob(context.Context, *GetCompressionJobRequest) (*GetCompressionJobResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetCompressionJob not implemented")
 }
+func (UnimplementedVideoServiceServer) GetWebhookDeliveries(context.Context, *GetWebhookDeliveriesRequest) (*GetWebhookDeliveriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWebhookDeliveries not implemented")
+}
+func (UnimplementedVideoServiceServer) GetDownloadURL(context.Context, *GetDownloadURLRequest) (*GetDownloadURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDownloadURL not implemented")
+}
+func (UnimplementedVideoServiceServer) CancelJob(context.Context, *CancelJobRequest) (*CancelJobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelJob not implemented")
+}
 func (UnimplementedVideoServiceServer) mustEmbedUnimplementedVideoServiceServer() {}
 func (UnimplementedVideoServiceServer) testEmbeddedByValue()                      {}
 
@@ -486,6 +602,60 @@ func _VideoService_GetCompressionJob_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _VideoService_GetWebhookDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWebhookDeliveriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VideoServiceServer).GetWebhookDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VideoService_GetWebhookDeliveries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VideoServiceServer).GetWebhookDeliveries(ctx, req.(*GetWebhookDeliveriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VideoService_GetDownloadURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDownloadURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VideoServiceServer).GetDownloadURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VideoService_GetDownloadURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VideoServiceServer).GetDownloadURL(ctx, req.(*GetDownloadURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VideoService_CancelJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VideoServiceServer).CancelJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VideoService_CancelJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VideoServiceServer).CancelJob(ctx, req.(*CancelJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // VideoService_ServiceDesc is the grpc.ServiceDesc for VideoService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -509,6 +679,18 @@ var VideoService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetCompressionJob",
 			Handler:    _VideoService_GetCompressionJob_Handler,
 		},
+		{
+			MethodName: "GetWebhookDeliveries",
+			Handler:    _VideoService_GetWebhookDeliveries_Handler,
+		},
+		{
+			MethodName: "GetDownloadURL",
+			Handler:    _VideoService_GetDownloadURL_Handler,
+		},
+		{
+			MethodName: "CancelJob",
+			Handler:    _VideoService_CancelJob_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "video.proto",
@@ -0,0 +1,855 @@
+package config
+
+import (
+	"errors"
+	"ffmpeg/wrapper/pkg/discovery"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Config defines the configuration shared by all services.
+// Every leaf field can be overridden by an environment
+// variable and a command line flag, see Load.
+type Config struct {
+	API              API              `yaml:"api"`
+	Prometheus       Prometheus       `yaml:"prometheus"`
+	ServiceDiscovery ServiceDiscovery `yaml:"serviceDiscovery"`
+	GRPC             GRPC             `yaml:"grpc"`
+	Jaeger           Jaeger           `yaml:"jaeger"`
+	Storage          Storage          `yaml:"storage"`
+	Kafka            Kafka            `yaml:"kafka"`
+	Encoding         Encoding         `yaml:"encoding"`
+	Limits           Limits           `yaml:"limits"`
+	CORS             CORS             `yaml:"cors"`
+	Auth             Auth             `yaml:"auth"`
+	RateLimit        RateLimit        `yaml:"rateLimit"`
+	Redis            Redis            `yaml:"redis"`
+	Webhooks         Webhooks         `yaml:"webhooks"`
+	Retention        Retention        `yaml:"retention"`
+	Dedup            Dedup            `yaml:"dedup"`
+	Scheduling       Scheduling       `yaml:"scheduling"`
+}
+
+// API defines the public API listener of a service.
+type API struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+}
+
+// Prometheus defines the metrics listener of a service.
+// A zero port disables the metrics endpoint.
+type Prometheus struct {
+	MetricsPort int `yaml:"metricsPort"`
+}
+
+// ServiceDiscovery defines the service registry settings.
+type ServiceDiscovery struct {
+	// Backend selects the registry: "consul", "dns",
+	// "kubernetes" or "static".
+	Backend    string     `yaml:"backend"`
+	Consul     Consul     `yaml:"consul"`
+	DNS        DNS        `yaml:"dns"`
+	Kubernetes Kubernetes `yaml:"kubernetes"`
+	Static     Static     `yaml:"static"`
+	// Zone is advertised in the instance metadata and as a
+	// "zone:<zone>" tag.
+	Zone string `yaml:"zone"`
+	// Tags are added to every registered instance.
+	Tags []string `yaml:"tags"`
+	// HealthCheckInterval is how often the registry checks
+	// gRPC servers through grpc.health.v1.
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval"`
+	// AdvertiseHost is the host other services reach this
+	// one at, e.g. "localhost" when running outside of
+	// Docker. It defaults to the compose service name.
+	AdvertiseHost string `yaml:"advertiseHost"`
+}
+
+// HostPort returns the address the instance is registered
+// with, using host unless an advertise host is set.
+func (s ServiceDiscovery) HostPort(host string, port int) string {
+	if s.AdvertiseHost != "" {
+		host = s.AdvertiseHost
+	}
+	return fmt.Sprintf("%s:%d", host, port)
+}
+
+// RegisterOptions returns the tags and metadata every
+// instance of the service is registered with.
+func (s ServiceDiscovery) RegisterOptions() []discovery.RegisterOption {
+	opts := []discovery.RegisterOption{
+		discovery.WithTags(s.Tags...),
+		discovery.WithMeta(discovery.MetaVersion, discovery.Version()),
+	}
+	if s.Zone != "" {
+		opts = append(opts, discovery.WithTags("zone:"+s.Zone), discovery.WithMeta(discovery.MetaZone, s.Zone))
+	}
+	return opts
+}
+
+// Consul defines the Consul agent address.
+type Consul struct {
+	Address string `yaml:"address"`
+}
+
+// DNS defines a registry resolving services through DNS
+// SRV records, e.g. of headless Kubernetes services.
+type DNS struct {
+	// Domain is appended to service names, e.g.
+	// "default.svc.cluster.local".
+	Domain string `yaml:"domain"`
+	// PortName is the SRV service label, so that "grpc"
+	// looks up _grpc._tcp.<service>.<domain>. Without it
+	// <service>.<domain> is looked up directly.
+	PortName string `yaml:"portName"`
+	// Server overrides the system resolver, e.g.
+	// "10.96.0.10:53".
+	Server string `yaml:"server"`
+	// RefreshInterval is how often watched services are
+	// looked up again.
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+// Static defines the in-process registry used for local
+// development.
+type Static struct {
+	// File shares the registry between local processes.
+	// Without it the registry only knows the instances of
+	// its own process.
+	File string `yaml:"file"`
+	// TTL is how long an instance stays active without
+	// reporting a healthy state.
+	TTL time.Duration `yaml:"ttl"`
+	// EvictAfter is how long an inactive instance is kept
+	// before it is removed.
+	EvictAfter time.Duration `yaml:"evictAfter"`
+	// AdminAddr serves the list of instances as JSON on
+	// /registry. Empty disables it.
+	AdminAddr string `yaml:"adminAddr"`
+}
+
+// Kubernetes defines a registry reading the EndpointSlices
+// of Kubernetes services.
+type Kubernetes struct {
+	// APIServer overrides the in-cluster API server, e.g.
+	// "http://127.0.0.1:8001" for kubectl proxy.
+	APIServer string `yaml:"apiServer"`
+	// Namespace defaults to the namespace of the pod.
+	Namespace string `yaml:"namespace"`
+	// PortName selects the endpoint port of services
+	// exposing more than one.
+	PortName string `yaml:"portName"`
+}
+
+// GRPC defines the gRPC settings of a service.
+type GRPC struct {
+	Client GRPCClient `yaml:"client"`
+}
+
+// GRPCClient defines how a service connects to the other
+// services it calls.
+type GRPCClient struct {
+	// Balancer is the client-side load balancing policy,
+	// either "round_robin" or "least_request".
+	Balancer string `yaml:"balancer"`
+	// CallTimeout is the deadline of a call whose context
+	// has none.
+	CallTimeout time.Duration `yaml:"callTimeout"`
+	// MaxAttempts bounds the attempts of a call failing
+	// with UNAVAILABLE, including the first one.
+	MaxAttempts int `yaml:"maxAttempts"`
+}
+
+// Jaeger defines the OTLP trace collector endpoint.
+type Jaeger struct {
+	URL string `yaml:"url"`
+}
+
+// Storage defines the S3 compatible (R2) object storage.
+type Storage struct {
+	AccountID   string `yaml:"accountId" env:"accountId"`
+	AccessKeyID string `yaml:"accessKeyId" env:"accessKeyId" secret:"true"`
+	SecretKey   string `yaml:"secretKey" env:"secretKey" secret:"true"`
+	Bucket      string `yaml:"bucket" env:"bucketname"`
+	Region      string `yaml:"region"`
+	// Endpoint overrides the R2 endpoint derived from the
+	// account ID, e.g. for a local S3 compatible server.
+	Endpoint string `yaml:"endpoint"`
+}
+
+// BaseEndpoint returns the S3 API endpoint of the storage.
+func (s Storage) BaseEndpoint() string {
+	if s.Endpoint != "" {
+		return s.Endpoint
+	}
+	return fmt.Sprintf("https://%s.r2.cloudflarestorage.com", s.AccountID)
+}
+
+// Kafka defines the Kafka brokers and topics.
+type Kafka struct {
+	Brokers       []string `yaml:"brokers" env:"kafkaBroker"`
+	ConsumerGroup string   `yaml:"consumerGroup"`
+	Topics        Topics   `yaml:"topics"`
+}
+
+// Topics defines the Kafka topic names.
+type Topics struct {
+	// Jobs carries compression requests published by the
+	// metadata service, of interactive priority.
+	Jobs string `yaml:"jobs"`
+	// PremiumJobs and BatchJobs carry the compression
+	// requests of premium and batch priority.
+	PremiumJobs string `yaml:"premiumJobs"`
+	BatchJobs   string `yaml:"batchJobs"`
+	// Results carries compression results published by the
+	// compression workers.
+	Results string `yaml:"results"`
+	// Deliveries carries the webhook delivery log published
+	// by the video service.
+	Deliveries string `yaml:"deliveries"`
+	// Cancellations carries the job cancellations published
+	// by the video service.
+	Cancellations string `yaml:"cancellations"`
+	// Segments carries the segments of chunked jobs, read
+	// by the consumer group of the compression workers.
+	Segments string `yaml:"segments"`
+	// SegmentResults carries the encoded segments, read by
+	// every compression worker.
+	SegmentResults string `yaml:"segmentResults"`
+}
+
+// Encoding defines the default ffmpeg encoding settings.
+type Encoding struct {
+	TargetVideoMB float64 `yaml:"targetVideoMB"`
+	TargetAudioMB float64 `yaml:"targetAudioMB"`
+	VideoCodec    string  `yaml:"videoCodec"`
+	AudioCodec    string  `yaml:"audioCodec"`
+	Preset        string  `yaml:"preset"`
+	// WorkDir is where sources are downloaded and encoded.
+	WorkDir string `yaml:"workDir"`
+	// SkipAlreadySmall returns sources that fit the targets
+	// and play in Discord as they are.
+	SkipAlreadySmall bool `yaml:"skipAlreadySmall"`
+	// Strategy is how jobs are encoded: auto, twoPass or
+	// crf. auto picks it from the metadata of each job.
+	Strategy string       `yaml:"strategy"`
+	CRF      CRF          `yaml:"crf"`
+	Output   Output       `yaml:"output"`
+	Animated Animated     `yaml:"animated"`
+	Limits   EncodeLimits `yaml:"limits"`
+	Chunking Chunking     `yaml:"chunking"`
+}
+
+// Output defines the post-processing of outputs.
+type Output struct {
+	// Faststart moves the index of MP4 outputs before their
+	// media, so that they play while they download.
+	Faststart bool `yaml:"faststart"`
+	// KeepMetadata keeps the metadata of sources, such as
+	// the location and device of phone recordings.
+	KeepMetadata bool `yaml:"keepMetadata"`
+	// Chapters and Subtitles are keep or drop.
+	Chapters  string `yaml:"chapters"`
+	Subtitles string `yaml:"subtitles"`
+	// NormalizeRotation rotates the frames of rotated
+	// sources, so that outputs play upright in players
+	// that ignore the rotation.
+	NormalizeRotation bool `yaml:"normalizeRotation"`
+}
+
+// Animated defines the encoding of jobs into animated GIF
+// or WebP images. Each attempt starts from the larger
+// settings, which are lowered towards the Min ones until
+// the image fits the targets.
+type Animated struct {
+	// MaxDuration is the longest video encoded into an
+	// image.
+	MaxDuration time.Duration `yaml:"maxDuration"`
+	// MaxAttempts bounds the encodings of each job.
+	MaxAttempts int `yaml:"maxAttempts"`
+	FPS         int `yaml:"fps"`
+	MinFPS      int `yaml:"minFPS"`
+	// Width is the largest width of images, in pixels.
+	// Narrower sources keep their width.
+	Width    int `yaml:"width"`
+	MinWidth int `yaml:"minWidth"`
+	// Colors is the size of the palettes of GIF images.
+	Colors    int `yaml:"colors"`
+	MinColors int `yaml:"minColors"`
+	// Quality is passed to libwebp_anim as -quality.
+	Quality    int `yaml:"quality"`
+	MinQuality int `yaml:"minQuality"`
+}
+
+// CRF defines the single-pass constant quality encoding.
+type CRF struct {
+	// Quality is passed as -crf.
+	Quality int `yaml:"quality"`
+	// MaxDuration is the longest video the auto strategy
+	// encodes in a single pass.
+	MaxDuration time.Duration `yaml:"maxDuration"`
+}
+
+// Chunking splits long videos into segments encoded by
+// several workers at once.
+type Chunking struct {
+	Enabled bool `yaml:"enabled"`
+	// MinDuration is the shortest video that is split.
+	MinDuration time.Duration `yaml:"minDuration"`
+	// SegmentDuration is the duration segments are split
+	// at, at the next keyframe.
+	SegmentDuration time.Duration `yaml:"segmentDuration"`
+}
+
+// EncodeLimits bounds the time and resources of the ffmpeg
+// processes of each job.
+type EncodeLimits struct {
+	// The encoding of a job times out after BaseTimeout
+	// plus TimeoutFactor times the duration of the video,
+	// and at most after MaxTimeout unless it is 0.
+	BaseTimeout   time.Duration `yaml:"baseTimeout"`
+	TimeoutFactor float64       `yaml:"timeoutFactor"`
+	MaxTimeout    time.Duration `yaml:"maxTimeout"`
+	// Threads is passed as -threads, 0 leaves it to ffmpeg.
+	Threads int `yaml:"threads"`
+	// Nice is the niceness ffmpeg runs at, from 0 to 19.
+	Nice int `yaml:"nice"`
+	// CgroupParent is a cgroup v2 directory delegated to
+	// the worker, with the memory and cpu controllers
+	// enabled for its children. Each job runs in a child
+	// cgroup limited to MemoryMax bytes and CPUMax CPUs.
+	// Empty disables the cgroup limits.
+	CgroupParent string  `yaml:"cgroupParent"`
+	MemoryMax    int64   `yaml:"memoryMax"`
+	CPUMax       float64 `yaml:"cpuMax"`
+	// DrainTimeout is how long a stopping worker lets its
+	// running job finish before interrupting it.
+	DrainTimeout time.Duration `yaml:"drainTimeout"`
+}
+
+// Limits defines request and object lifetime limits.
+type Limits struct {
+	UploadURLLifetime   time.Duration `yaml:"uploadURLLifetime"`
+	DownloadURLLifetime time.Duration `yaml:"downloadURLLifetime"`
+	ProbeTimeout        time.Duration `yaml:"probeTimeout"`
+	StatusPollTimeout   time.Duration `yaml:"statusPollTimeout"`
+	MaxUploadBytes      int64         `yaml:"maxUploadBytes"`
+	// ShutdownTimeout bounds each step of a graceful
+	// shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+// CORS defines the allowed browser origins of the gateway.
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+// Auth defines how the gateway authenticates users and
+// the quotas it enforces on each of them.
+type Auth struct {
+	// Required rejects anonymous requests. Without it,
+	// anonymous requests share the "anonymous" user.
+	Required bool `yaml:"required"`
+	// APIKeys lists the accepted API keys as
+	// "<user id>:<hex SHA-256 of the key>".
+	APIKeys []string `yaml:"apiKeys" secret:"true"`
+	// SessionSecret signs the session cookies of users
+	// logged in with Discord.
+	SessionSecret   string        `yaml:"sessionSecret" secret:"true"`
+	SessionLifetime time.Duration `yaml:"sessionLifetime"`
+	Discord         Discord       `yaml:"discord"`
+	Quotas          Quotas        `yaml:"quotas"`
+}
+
+// Discord defines the OAuth2 application used for "Login
+// with Discord". An empty client ID disables it.
+type Discord struct {
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret" secret:"true"`
+	// RedirectURL is the gateway callback registered with
+	// the application, e.g.
+	// "https://api.example.com/auth/discord/callback".
+	RedirectURL string `yaml:"redirectURL"`
+}
+
+// Quotas defines the per-user limits of the gateway. A
+// zero limit disables it.
+type Quotas struct {
+	DailyJobs        int   `yaml:"dailyJobs"`
+	DailyUploadBytes int64 `yaml:"dailyUploadBytes"`
+	ConcurrentJobs   int   `yaml:"concurrentJobs"`
+	// JobTimeout frees the concurrency slot of a job whose
+	// result was never read.
+	JobTimeout time.Duration `yaml:"jobTimeout"`
+	// ConsumerGroup shares the results between the gateway
+	// replicas, which free the slots of finished jobs.
+	ConsumerGroup string `yaml:"consumerGroup"`
+	// PremiumUsers may submit jobs of premium priority.
+	PremiumUsers []string `yaml:"premiumUsers"`
+}
+
+// RateLimit defines the token buckets limiting gateway
+// requests per client IP and per authenticated user.
+type RateLimit struct {
+	Enabled bool   `yaml:"enabled"`
+	PerIP   Bucket `yaml:"perIP"`
+	PerUser Bucket `yaml:"perUser"`
+	// TrustedProxies are the CIDRs of the proxies whose
+	// X-Forwarded-For header names the client IP.
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+// Bucket defines a token bucket refilled at Rate tokens
+// per second, holding at most Burst tokens.
+type Bucket struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// Redis defines the Redis server shared by the replicas of
+// a service. An empty address disables it.
+type Redis struct {
+	Address  string `yaml:"address"`
+	Password string `yaml:"password" secret:"true"`
+	DB       int    `yaml:"db"`
+	// Timeout bounds each command, so that a slow Redis
+	// falls back to local state quickly.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Webhooks defines how the video service POSTs the
+// results of jobs to their callback URLs.
+type Webhooks struct {
+	// ConsumerGroup shares the results between the video
+	// service replicas, so that each is delivered once.
+	ConsumerGroup string `yaml:"consumerGroup"`
+	// Timeout bounds each attempt.
+	Timeout     time.Duration `yaml:"timeout"`
+	MaxAttempts int           `yaml:"maxAttempts"`
+	// InitialBackoff is the delay before the first retry.
+	// It doubles after each attempt, up to MaxBackoff.
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	// AllowPrivateNetworks accepts callbacks to loopback
+	// and private addresses, e.g. for local development.
+	AllowPrivateNetworks bool `yaml:"allowPrivateNetworks"`
+	// SecretTTL is how long the callback secret of a job is
+	// kept, from its submission to its last delivery.
+	SecretTTL time.Duration `yaml:"secretTTL"`
+}
+
+// Retention defines how long the metadata service keeps
+// the objects of jobs before deleting them.
+type Retention struct {
+	// ConsumerGroup shares the results between the metadata
+	// replicas, so that each is recorded once.
+	ConsumerGroup string `yaml:"consumerGroup"`
+	// SweepInterval is how often expired objects are
+	// deleted, by the replica holding the lease.
+	SweepInterval time.Duration `yaml:"sweepInterval"`
+	// LeaseTTL is how long the lease outlives a replica that
+	// stopped renewing it.
+	LeaseTTL time.Duration `yaml:"leaseTTL"`
+	// Uploads is how long an uploaded file is kept after its
+	// upload URL is created or its job starts, in case the
+	// job never finishes.
+	Uploads  time.Duration `yaml:"uploads"`
+	JobTypes JobTypes      `yaml:"jobTypes"`
+	// ReconcileInterval is how often the bucket is listed
+	// for objects no job recorded. Zero disables it.
+	ReconcileInterval time.Duration `yaml:"reconcileInterval"`
+	// OrphanAge is how old an object no job recorded must be
+	// to be deleted.
+	OrphanAge time.Duration `yaml:"orphanAge"`
+}
+
+// JobTypes defines the retention of each job type.
+type JobTypes struct {
+	Compress JobRetention `yaml:"compress"`
+}
+
+// JobRetention defines how long the objects of a finished
+// job are kept.
+type JobRetention struct {
+	// Source is the uploaded file of a succeeded job.
+	Source time.Duration `yaml:"source"`
+	// Output is the result of a succeeded job. It is kept
+	// at least until its download URL expires.
+	Output time.Duration `yaml:"output"`
+	// Failed is the uploaded file of a failed job, and its
+	// partial output if any.
+	Failed time.Duration `yaml:"failed"`
+}
+
+// Dedup defines the cache of compressed outputs, keyed by
+// the SHA-256 of the source and the encoding settings, so
+// that identical uploads are encoded once.
+type Dedup struct {
+	Enabled bool `yaml:"enabled"`
+	// TTL is how long an output is reused after it was
+	// last encoded or reused.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// Scheduling defines the order compression workers start
+// queued jobs in. The priorities take turns by weighted
+// round-robin, and within a priority the users with queued
+// jobs take turns.
+type Scheduling struct {
+	Weights PriorityWeights `yaml:"weights"`
+	// Prefetch is the most jobs of each priority a worker
+	// fetches ahead of time to choose from. Fetched jobs are
+	// only ordered by that worker, so it is kept to about
+	// the one job a worker runs at a time.
+	Prefetch int `yaml:"prefetch"`
+}
+
+// PriorityWeights are the shares of the jobs started of
+// each priority, while several priorities have queued jobs.
+type PriorityWeights struct {
+	Premium     int `yaml:"premium"`
+	Interactive int `yaml:"interactive"`
+	Batch       int `yaml:"batch"`
+}
+
+// Default returns the built-in configuration all other
+// layers are applied on top of.
+func Default() *Config {
+	return &Config{
+		API: API{Host: "0.0.0.0"},
+		ServiceDiscovery: ServiceDiscovery{
+			Backend:             "consul",
+			Consul:              Consul{Address: "consul:8500"},
+			DNS:                 DNS{PortName: "grpc", RefreshInterval: 10 * time.Second},
+			Kubernetes:          Kubernetes{PortName: "grpc"},
+			Static:              Static{TTL: 5 * time.Second, EvictAfter: time.Minute},
+			HealthCheckInterval: 5 * time.Second,
+		},
+		GRPC: GRPC{
+			Client: GRPCClient{
+				Balancer:    "round_robin",
+				CallTimeout: 30 * time.Second,
+				MaxAttempts: 3,
+			},
+		},
+		Jaeger:  Jaeger{URL: "jaeger:4317"},
+		Storage: Storage{Region: "auto"},
+		Kafka: Kafka{
+			ConsumerGroup: "compression-worker",
+			Topics: Topics{
+				Jobs:           "compression-job",
+				PremiumJobs:    "compression-job-premium",
+				BatchJobs:      "compression-job-batch",
+				Results:        "compression-job",
+				Deliveries:     "webhook-delivery",
+				Cancellations:  "job-cancellation",
+				Segments:       "compression-segment",
+				SegmentResults: "compression-segment-result",
+			},
+		},
+		Encoding: Encoding{
+			TargetVideoMB:    8,
+			TargetAudioMB:    1,
+			VideoCodec:       "libx264",
+			AudioCodec:       "aac",
+			Preset:           "medium",
+			WorkDir:          "/tmp",
+			SkipAlreadySmall: true,
+			Strategy:         "auto",
+			CRF: CRF{
+				Quality:     23,
+				MaxDuration: 2 * time.Minute,
+			},
+			Output: Output{
+				Faststart:         true,
+				Chapters:          "drop",
+				Subtitles:         "drop",
+				NormalizeRotation: true,
+			},
+			Animated: Animated{
+				MaxDuration: time.Minute,
+				MaxAttempts: 6,
+				FPS:         15,
+				MinFPS:      5,
+				Width:       480,
+				MinWidth:    160,
+				Colors:      256,
+				MinColors:   32,
+				Quality:     75,
+				MinQuality:  30,
+			},
+			Limits: EncodeLimits{
+				BaseTimeout:   time.Minute,
+				TimeoutFactor: 5,
+				MaxTimeout:    2 * time.Hour,
+				Nice:          10,
+				DrainTimeout:  5 * time.Minute,
+			},
+			Chunking: Chunking{
+				MinDuration:     10 * time.Minute,
+				SegmentDuration: 2 * time.Minute,
+			},
+		},
+		Limits: Limits{
+			UploadURLLifetime:   6 * time.Minute,
+			DownloadURLLifetime: 30 * time.Minute,
+			ProbeTimeout:        5 * time.Second,
+			StatusPollTimeout:   5 * time.Second,
+			MaxUploadBytes:      500 << 20,
+			ShutdownTimeout:     30 * time.Second,
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"http://127.0.0.1:5173", "http://localhost:5173"},
+		},
+		Auth: Auth{
+			SessionLifetime: 7 * 24 * time.Hour,
+			Quotas: Quotas{
+				DailyJobs:        50,
+				DailyUploadBytes: 5 << 30,
+				ConcurrentJobs:   2,
+				JobTimeout:       30 * time.Minute,
+				ConsumerGroup:    "gateway-quotas",
+			},
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			PerIP:   Bucket{Rate: 5, Burst: 30},
+			PerUser: Bucket{Rate: 2, Burst: 20},
+		},
+		Redis: Redis{Timeout: 100 * time.Millisecond},
+		Webhooks: Webhooks{
+			ConsumerGroup:  "webhook-dispatcher",
+			Timeout:        10 * time.Second,
+			MaxAttempts:    8,
+			InitialBackoff: 5 * time.Second,
+			MaxBackoff:     10 * time.Minute,
+			SecretTTL:      7 * 24 * time.Hour,
+		},
+		Retention: Retention{
+			ConsumerGroup: "retention",
+			SweepInterval: time.Minute,
+			LeaseTTL:      3 * time.Minute,
+			Uploads:       6 * time.Hour,
+			JobTypes: JobTypes{
+				Compress: JobRetention{Source: time.Hour, Output: time.Hour, Failed: time.Hour},
+			},
+			ReconcileInterval: 6 * time.Hour,
+			OrphanAge:         24 * time.Hour,
+		},
+		Dedup: Dedup{Enabled: true, TTL: 24 * time.Hour},
+		Scheduling: Scheduling{
+			Weights:  PriorityWeights{Premium: 8, Interactive: 4, Batch: 1},
+			Prefetch: 1,
+		},
+	}
+}
+
+// Requirement marks a configuration section a service
+// cannot start without.
+type Requirement int
+
+const (
+	RequireStorage Requirement = 1 << iota
+	RequireKafka
+	RequireMetrics
+	RequireAuth
+	RequireRateLimit
+	RequireWebhooks
+	RequireRetention
+	RequireDedup
+)
+
+// Validate checks the configuration and returns all
+// problems found, joined into a single error.
+func (c *Config) Validate(reqs ...Requirement) error {
+	var req Requirement
+	for _, r := range reqs {
+		req |= r
+	}
+	var errs []error
+	check := func(ok bool, format string, a ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, a...))
+		}
+	}
+
+	check(validPort(c.API.Port), "api.port: %d is not a valid port", c.API.Port)
+	check(c.Prometheus.MetricsPort == 0 || validPort(c.Prometheus.MetricsPort),
+		"prometheus.metricsPort: %d is not a valid port", c.Prometheus.MetricsPort)
+	check(c.Prometheus.MetricsPort == 0 || c.Prometheus.MetricsPort != c.API.Port,
+		"prometheus.metricsPort: must differ from api.port")
+	switch c.ServiceDiscovery.Backend {
+	case "consul":
+		check(c.ServiceDiscovery.Consul.Address != "", "serviceDiscovery.consul.address: must be set")
+	case "dns":
+		check(c.ServiceDiscovery.DNS.RefreshInterval > 0, "serviceDiscovery.dns.refreshInterval: must be positive")
+	case "kubernetes":
+	case "static":
+		check(c.ServiceDiscovery.Static.TTL > 0, "serviceDiscovery.static.ttl: must be positive")
+		check(c.ServiceDiscovery.Static.EvictAfter >= 0, "serviceDiscovery.static.evictAfter: must not be negative")
+	default:
+		check(false, "serviceDiscovery.backend: %q is not one of consul, dns, kubernetes, static", c.ServiceDiscovery.Backend)
+	}
+	check(c.ServiceDiscovery.HealthCheckInterval >= time.Second, "serviceDiscovery.healthCheckInterval: must be at least 1s")
+	check(c.Jaeger.URL != "", "jaeger.url: must be set")
+	check(c.GRPC.Client.Balancer == "round_robin" || c.GRPC.Client.Balancer == "least_request",
+		"grpc.client.balancer: %q is not one of round_robin, least_request", c.GRPC.Client.Balancer)
+	check(c.GRPC.Client.CallTimeout > 0, "grpc.client.callTimeout: must be positive")
+	check(c.GRPC.Client.MaxAttempts >= 1 && c.GRPC.Client.MaxAttempts <= 5,
+		"grpc.client.maxAttempts: must be between 1 and 5")
+
+	if req&RequireMetrics != 0 {
+		check(c.Prometheus.MetricsPort != 0, "prometheus.metricsPort: must be set")
+	}
+	if req&RequireStorage != 0 {
+		check(c.Storage.Bucket != "", "storage.bucket: must be set")
+		check(c.Storage.AccountID != "" || c.Storage.Endpoint != "", "storage.accountId: must be set unless storage.endpoint is")
+		check(c.Storage.AccessKeyID != "", "storage.accessKeyId: must be set")
+		check(c.Storage.SecretKey != "", "storage.secretKey: must be set")
+		check(c.Limits.UploadURLLifetime > 0, "limits.uploadURLLifetime: must be positive")
+		check(c.Limits.DownloadURLLifetime > 0 && c.Limits.DownloadURLLifetime <= 7*24*time.Hour,
+			"limits.downloadURLLifetime: must be between 0 and 168h")
+	}
+	if req&RequireKafka != 0 {
+		check(len(c.Kafka.Brokers) > 0, "kafka.brokers: must be set")
+		for i, b := range c.Kafka.Brokers {
+			check(b != "", "kafka.brokers[%d]: must not be empty", i)
+		}
+		check(c.Kafka.Topics.Jobs != "", "kafka.topics.jobs: must be set")
+		check(c.Kafka.Topics.PremiumJobs != "", "kafka.topics.premiumJobs: must be set")
+		check(c.Kafka.Topics.BatchJobs != "", "kafka.topics.batchJobs: must be set")
+		check(c.Kafka.Topics.Results != "", "kafka.topics.results: must be set")
+		check(c.Kafka.Topics.Cancellations != "", "kafka.topics.cancellations: must be set")
+		check(c.Kafka.Topics.Segments != "", "kafka.topics.segments: must be set")
+		check(c.Kafka.Topics.SegmentResults != "", "kafka.topics.segmentResults: must be set")
+	}
+
+	if req&RequireAuth != 0 {
+		for i, k := range c.Auth.APIKeys {
+			user, hash, ok := strings.Cut(k, ":")
+			check(ok && user != "" && len(hash) == 64, "auth.apiKeys[%d]: must be <user id>:<hex SHA-256>", i)
+		}
+		discord := c.Auth.Discord
+		check(discord.ClientID == "" || discord.ClientSecret != "" && discord.RedirectURL != "",
+			"auth.discord: clientSecret and redirectURL must be set with clientId")
+		check(discord.ClientID == "" || len(c.Auth.SessionSecret) >= 32,
+			"auth.sessionSecret: must be at least 32 characters with auth.discord.clientId")
+		check(c.Auth.SessionLifetime > 0, "auth.sessionLifetime: must be positive")
+		check(c.Auth.Quotas.DailyJobs >= 0, "auth.quotas.dailyJobs: must not be negative")
+		check(c.Auth.Quotas.DailyUploadBytes >= 0, "auth.quotas.dailyUploadBytes: must not be negative")
+		check(c.Auth.Quotas.ConcurrentJobs >= 0, "auth.quotas.concurrentJobs: must not be negative")
+		check(c.Auth.Quotas.JobTimeout > 0, "auth.quotas.jobTimeout: must be positive")
+		check(c.Auth.Quotas.ConsumerGroup != "", "auth.quotas.consumerGroup: must be set")
+	}
+
+	if req&RequireRateLimit != 0 && c.RateLimit.Enabled {
+		check(c.RateLimit.PerIP.Rate > 0, "rateLimit.perIP.rate: must be positive")
+		check(c.RateLimit.PerIP.Burst >= 1, "rateLimit.perIP.burst: must be at least 1")
+		check(c.RateLimit.PerUser.Rate > 0, "rateLimit.perUser.rate: must be positive")
+		check(c.RateLimit.PerUser.Burst >= 1, "rateLimit.perUser.burst: must be at least 1")
+		for i, p := range c.RateLimit.TrustedProxies {
+			_, _, err := net.ParseCIDR(p)
+			check(err == nil, "rateLimit.trustedProxies[%d]: %q is not a CIDR", i, p)
+		}
+		check(c.Redis.Address == "" || c.Redis.Timeout > 0, "redis.timeout: must be positive")
+	}
+
+	if req&RequireWebhooks != 0 {
+		check(c.Kafka.Topics.Deliveries != "", "kafka.topics.deliveries: must be set")
+		check(c.Webhooks.ConsumerGroup != "", "webhooks.consumerGroup: must be set")
+		check(c.Webhooks.Timeout > 0, "webhooks.timeout: must be positive")
+		check(c.Webhooks.MaxAttempts >= 1, "webhooks.maxAttempts: must be at least 1")
+		check(c.Webhooks.InitialBackoff > 0, "webhooks.initialBackoff: must be positive")
+		check(c.Webhooks.MaxBackoff >= c.Webhooks.InitialBackoff, "webhooks.maxBackoff: must be at least webhooks.initialBackoff")
+		check(c.Webhooks.SecretTTL > 0, "webhooks.secretTTL: must be positive")
+		check(c.Redis.Address == "" || c.Redis.Timeout > 0, "redis.timeout: must be positive")
+	}
+
+	if req&RequireRetention != 0 {
+		r := c.Retention
+		check(c.Kafka.Topics.Results != "", "kafka.topics.results: must be set")
+		check(r.ConsumerGroup != "", "retention.consumerGroup: must be set")
+		check(r.SweepInterval > 0, "retention.sweepInterval: must be positive")
+		check(r.LeaseTTL > r.SweepInterval, "retention.leaseTTL: must exceed retention.sweepInterval")
+		check(r.Uploads > 0, "retention.uploads: must be positive")
+		longest := r.Uploads + c.Limits.UploadURLLifetime
+		jobTypes := []struct {
+			name string
+			JobRetention
+		}{
+			{"compress", r.JobTypes.Compress},
+		}
+		for _, jt := range jobTypes {
+			check(jt.Source > 0, "retention.jobTypes.%s.source: must be positive", jt.name)
+			check(jt.Output > 0, "retention.jobTypes.%s.output: must be positive", jt.name)
+			check(jt.Failed > 0, "retention.jobTypes.%s.failed: must be positive", jt.name)
+			longest = max(longest, jt.Source, jt.Output, jt.Failed, c.Limits.DownloadURLLifetime)
+		}
+		check(r.ReconcileInterval >= 0, "retention.reconcileInterval: must not be negative")
+		// Objects whose record was lost are not deleted
+		// before their retention would have ended.
+		check(r.ReconcileInterval == 0 || r.OrphanAge >= longest,
+			"retention.orphanAge: must be at least %s, the longest retention", longest)
+		check(c.Redis.Address == "" || c.Redis.Timeout > 0, "redis.timeout: must be positive")
+	}
+
+	if req&RequireDedup != 0 && c.Dedup.Enabled {
+		check(c.Dedup.TTL > 0, "dedup.ttl: must be positive")
+		check(c.Redis.Address == "" || c.Redis.Timeout > 0, "redis.timeout: must be positive")
+	}
+
+	check(c.Encoding.TargetVideoMB > 0, "encoding.targetVideoMB: must be positive")
+	check(c.Encoding.TargetAudioMB >= 0, "encoding.targetAudioMB: must not be negative")
+	check(c.Encoding.VideoCodec != "", "encoding.videoCodec: must be set")
+	check(c.Encoding.AudioCodec != "", "encoding.audioCodec: must be set")
+	check(c.Encoding.WorkDir != "", "encoding.workDir: must be set")
+	check(c.Encoding.Strategy == "auto" || c.Encoding.Strategy == "twoPass" || c.Encoding.Strategy == "crf",
+		"encoding.strategy: %q is not one of auto, twoPass, crf", c.Encoding.Strategy)
+	check(c.Encoding.CRF.Quality >= 0 && c.Encoding.CRF.Quality <= 51, "encoding.crf.quality: must be between 0 and 51")
+	check(c.Encoding.CRF.MaxDuration >= 0, "encoding.crf.maxDuration: must not be negative")
+	check(c.Encoding.Output.Chapters == "keep" || c.Encoding.Output.Chapters == "drop",
+		"encoding.output.chapters: %q is not one of keep, drop", c.Encoding.Output.Chapters)
+	check(c.Encoding.Output.Subtitles == "keep" || c.Encoding.Output.Subtitles == "drop",
+		"encoding.output.subtitles: %q is not one of keep, drop", c.Encoding.Output.Subtitles)
+	a := c.Encoding.Animated
+	check(a.MaxDuration > 0, "encoding.animated.maxDuration: must be positive")
+	check(a.MaxAttempts > 0, "encoding.animated.maxAttempts: must be positive")
+	check(a.MinFPS > 0 && a.MinFPS <= a.FPS, "encoding.animated.minFPS: must be positive and at most fps")
+	check(a.MinWidth > 0 && a.MinWidth <= a.Width, "encoding.animated.minWidth: must be positive and at most width")
+	check(a.MinColors >= 2 && a.MinColors <= a.Colors && a.Colors <= 256,
+		"encoding.animated: minColors and colors must be between 2 and 256, minColors at most colors")
+	check(a.MinQuality >= 0 && a.MinQuality <= a.Quality && a.Quality <= 100,
+		"encoding.animated: minQuality and quality must be between 0 and 100, minQuality at most quality")
+	l := c.Encoding.Limits
+	check(l.BaseTimeout > 0, "encoding.limits.baseTimeout: must be positive")
+	check(l.TimeoutFactor >= 0, "encoding.limits.timeoutFactor: must not be negative")
+	check(l.MaxTimeout == 0 || l.MaxTimeout >= l.BaseTimeout, "encoding.limits.maxTimeout: must be 0 or at least baseTimeout")
+	check(l.Threads >= 0, "encoding.limits.threads: must not be negative")
+	check(l.Nice >= 0 && l.Nice <= 19, "encoding.limits.nice: must be between 0 and 19")
+	check(l.MemoryMax >= 0, "encoding.limits.memoryMax: must not be negative")
+	check(l.CPUMax >= 0, "encoding.limits.cpuMax: must not be negative")
+	check(l.CgroupParent != "" || (l.MemoryMax == 0 && l.CPUMax == 0),
+		"encoding.limits.cgroupParent: must be set for memoryMax and cpuMax")
+	check(l.DrainTimeout > 0, "encoding.limits.drainTimeout: must be positive")
+	if ch := c.Encoding.Chunking; ch.Enabled {
+		check(ch.SegmentDuration > 0, "encoding.chunking.segmentDuration: must be positive")
+		check(ch.MinDuration > ch.SegmentDuration, "encoding.chunking.minDuration: must be longer than segmentDuration")
+	}
+	w := c.Scheduling.Weights
+	check(w.Premium >= 1, "scheduling.weights.premium: must be at least 1")
+	check(w.Interactive >= 1, "scheduling.weights.interactive: must be at least 1")
+	check(w.Batch >= 1, "scheduling.weights.batch: must be at least 1")
+	check(c.Scheduling.Prefetch >= 1, "scheduling.prefetch: must be at least 1")
+	check(c.Limits.ProbeTimeout > 0, "limits.probeTimeout: must be positive")
+	check(c.Limits.StatusPollTimeout > 0, "limits.statusPollTimeout: must be positive")
+	check(c.Limits.MaxUploadBytes >= 0, "limits.maxUploadBytes: must not be negative")
+	check(c.Limits.ShutdownTimeout > 0, "limits.shutdownTimeout: must be positive")
+
+	return errors.Join(errs...)
+}
+
+func validPort(p int) bool {
+	return p > 0 && p < 65536
+}
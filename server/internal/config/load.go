@@ -0,0 +1,280 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrConfigPrinted is returned by Load when the service was
+// started with --print-config. The caller should exit
+// without starting the service.
+var ErrConfigPrinted = errors.New("configuration printed")
+
+const redacted = "[REDACTED]"
+
+// Load builds the configuration of the given service from,
+// in increasing order of precedence:
+//
+//   - the built-in defaults,
+//   - the service's configs/default.yaml, looked up relative
+//     to the working directory so that the service can be
+//     started from the repository root, the service
+//     directory or its cmd directory,
+//   - the YAML files listed in CONFIG_FILES (comma separated)
+//     and passed with --config, in order,
+//   - environment variables, named after the YAML path in
+//     upper snake case (storage.bucket -> STORAGE_BUCKET),
+//     plus the legacy names in the env struct tags,
+//   - command line flags named after the YAML path
+//     (--storage.bucket).
+//
+// The result is validated against reqs before it is
+// returned.
+func Load(service string, args []string, reqs ...Requirement) (*Config, error) {
+	cfg := Default()
+	fields := leaves(reflect.ValueOf(cfg).Elem(), "")
+
+	var (
+		files       []string
+		printConfig bool
+		overrides   []func() error
+	)
+	fs := flag.NewFlagSet(service, flag.ContinueOnError)
+	fs.Func("config", "YAML configuration file to layer on top of the defaults (repeatable)", func(s string) error {
+		files = append(files, s)
+		return nil
+	})
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	for _, f := range fields {
+		f := f
+		fs.Func(f.path, "overrides "+f.path+" (env "+f.envNames[len(f.envNames)-1]+")", func(s string) error {
+			overrides = append(overrides, func() error { return f.set(s) })
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if path, ok := defaultFile(service); ok {
+		if err := decodeFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+	if env := os.Getenv("CONFIG_FILES"); env != "" {
+		files = append(strings.Split(env, ","), files...)
+	}
+	for _, path := range files {
+		if err := decodeFile(cfg, strings.TrimSpace(path)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		for _, name := range f.envNames {
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			if err := f.set(v); err != nil {
+				return nil, fmt.Errorf("env %s: %w", name, err)
+			}
+		}
+	}
+	for _, apply := range overrides {
+		if err := apply(); err != nil {
+			return nil, err
+		}
+	}
+
+	if printConfig {
+		out, err := cfg.Redacted().YAML()
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprint(os.Stdout, out)
+		return cfg, ErrConfigPrinted
+	}
+	if err := cfg.Validate(reqs...); err != nil {
+		return nil, fmt.Errorf("invalid %s configuration:\n%w", service, err)
+	}
+	return cfg, nil
+}
+
+// Redacted returns a copy of the configuration with all
+// secret values replaced.
+func (c *Config) Redacted() *Config {
+	cp := *c
+	for _, f := range leaves(reflect.ValueOf(&cp).Elem(), "") {
+		if !f.secret {
+			continue
+		}
+		switch f.value.Kind() {
+		case reflect.String:
+			if f.value.String() != "" {
+				f.value.SetString(redacted)
+			}
+		case reflect.Slice:
+			// The copy shares its slices with c, so replace
+			// the slice instead of its items.
+			items := make([]string, f.value.Len())
+			for i := range items {
+				items[i] = redacted
+			}
+			f.value.Set(reflect.ValueOf(items))
+		}
+	}
+	return &cp
+}
+
+// YAML returns the configuration encoded as YAML.
+func (c *Config) YAML() (string, error) {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func defaultFile(service string) (string, bool) {
+	candidates := []string{
+		filepath.Join("configs", "default.yaml"),
+		filepath.Join("..", "configs", "default.yaml"),
+		filepath.Join(service, "configs", "default.yaml"),
+	}
+	for _, c := range candidates {
+		if _, err := os.Stat(c); err == nil {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+func decodeFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open configuration: %w", err)
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse configuration %s: %w", path, err)
+	}
+	return nil
+}
+
+type leaf struct {
+	path     string
+	envNames []string
+	secret   bool
+	value    reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func leaves(v reflect.Value, prefix string) []leaf {
+	var res []leaf
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			res = append(res, leaves(fv, path)...)
+			continue
+		}
+		var envNames []string
+		if alias := sf.Tag.Get("env"); alias != "" {
+			envNames = append(envNames, alias)
+		}
+		envNames = append(envNames, envName(path))
+		res = append(res, leaf{
+			path:     path,
+			envNames: envNames,
+			secret:   sf.Tag.Get("secret") == "true",
+			value:    fv,
+		})
+	}
+	return res
+}
+
+func (f leaf) set(s string) error {
+	v := f.value
+	var err error
+	switch {
+	case v.Type() == durationType:
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, 64)
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		var n float64
+		n, err = strconv.ParseFloat(s, 64)
+		v.SetFloat(n)
+	case v.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.path, v.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	return nil
+}
+
+// envName converts a YAML path such as
+// limits.uploadURLLifetime to LIMITS_UPLOAD_URL_LIFETIME.
+func envName(path string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(path, ".") {
+		if b.Len() > 0 {
+			b.WriteByte('_')
+		}
+		r := []rune(part)
+		for i, c := range r {
+			if i > 0 && unicode.IsUpper(c) {
+				prev := r[i-1]
+				nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					b.WriteByte('_')
+				}
+			}
+			b.WriteRune(unicode.ToUpper(c))
+		}
+	}
+	return b.String()
+}
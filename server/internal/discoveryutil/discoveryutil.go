@@ -0,0 +1,49 @@
+package discoveryutil
+
+import (
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/lifecycle"
+	"ffmpeg/wrapper/pkg/discovery"
+	"ffmpeg/wrapper/pkg/discovery/consul"
+	"ffmpeg/wrapper/pkg/discovery/dns"
+	"ffmpeg/wrapper/pkg/discovery/kubernetes"
+	"ffmpeg/wrapper/pkg/discovery/static"
+	"fmt"
+	"net/http"
+)
+
+// NewRegistry creates the service registry selected by the
+// configured backend. Background work of the registry, such
+// as the health checks of the static registry, runs until
+// the lifecycle shuts down.
+func NewRegistry(lc *lifecycle.Lifecycle, cfg config.ServiceDiscovery) (discovery.Registry, error) {
+	switch cfg.Backend {
+	case "consul":
+		return consul.NewRegistry(cfg.Consul.Address)
+	case "dns":
+		return dns.NewRegistry(cfg.DNS.Domain, cfg.DNS.PortName, cfg.DNS.Server, cfg.DNS.RefreshInterval), nil
+	case "kubernetes":
+		return kubernetes.NewRegistry(cfg.Kubernetes.APIServer, cfg.Kubernetes.Namespace, cfg.Kubernetes.PortName)
+	case "static":
+		return newStaticRegistry(lc, cfg.Static), nil
+	default:
+		return nil, fmt.Errorf("unknown service discovery backend %q", cfg.Backend)
+	}
+}
+
+func newStaticRegistry(lc *lifecycle.Lifecycle, cfg config.Static) *static.Registry {
+	opts := []static.Option{static.WithTTL(cfg.TTL), static.WithEvictAfter(cfg.EvictAfter)}
+	if cfg.File != "" {
+		opts = append(opts, static.WithFile(cfg.File))
+	}
+	registry := static.NewRegistry(opts...)
+	lc.Go("service registry", 0, registry.Run)
+
+	if cfg.AdminAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/registry", registry.Handler())
+		srv := &http.Server{Addr: cfg.AdminAddr, Handler: mux}
+		lc.Serve("registry admin server", srv.ListenAndServe, lifecycle.HTTPStopper(srv))
+	}
+	return registry
+}
@@ -2,25 +2,128 @@ package grpcutil
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/user"
 	"ffmpeg/wrapper/pkg/discovery"
 	"fmt"
-	"math/rand"
+	"sync"
+	"time"
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/balancer/leastrequest"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-// ServiceConnection attempts to select a random service
-// instance and returns a gRPC connection to it.
-func ServiceConnection(ctx context.Context, serviceName string, registry discovery.Registry) (*grpc.ClientConn, error) {
-	addrs, err := registry.ServiceAddresses(ctx, serviceName)
+// Pool keeps one long-lived, load balanced connection per
+// service. The addresses of each service are watched in
+// the registry and kept up to date while the connection is
+// open.
+type Pool struct {
+	opts []grpc.DialOption
+
+	mu     sync.Mutex
+	conns  map[string]*grpc.ClientConn
+	closed bool
+}
+
+// NewPool creates a connection pool resolving services
+// through the given registry.
+func NewPool(registry discovery.Registry, cfg config.GRPCClient) (*Pool, error) {
+	serviceConfig, err := ServiceConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Printf("Connecting to %s for service %s\n", addrs, serviceName)
-	return grpc.NewClient(addrs[rand.Intn(len(addrs))],
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
-	)
+	return &Pool{
+		opts: []grpc.DialOption{
+			grpc.WithResolvers(NewResolverBuilder(registry)),
+			grpc.WithDefaultServiceConfig(serviceConfig),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+			grpc.WithChainUnaryInterceptor(DeadlineInterceptor(cfg.CallTimeout), user.UnaryClientInterceptor()),
+		},
+		conns: map[string]*grpc.ClientConn{},
+	}, nil
+}
+
+// Conn returns the connection to the given service,
+// creating it on first use. The connection is shared and
+// must not be closed by the caller.
+func (p *Pool) Conn(serviceName string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errors.New("connection pool is closed")
+	}
+	if conn, ok := p.conns[serviceName]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.NewClient(Target(serviceName), p.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection to %s: %w", serviceName, err)
+	}
+	p.conns[serviceName] = conn
+	return conn, nil
+}
+
+// Close closes all connections of the pool.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	var errs []error
+	for _, conn := range p.conns {
+		errs = append(errs, conn.Close())
+	}
+	p.conns = nil
+	return errors.Join(errs...)
+}
+
+// ServiceConfig returns the gRPC service config selecting
+// the configured load balancing policy and retrying calls
+// that fail with UNAVAILABLE, e.g. because the instance
+// they were sent to went away.
+func ServiceConfig(cfg config.GRPCClient) (string, error) {
+	var lb map[string]any
+	switch cfg.Balancer {
+	case "round_robin":
+		lb = map[string]any{"round_robin": map[string]any{}}
+	case "least_request":
+		lb = map[string]any{"least_request_experimental": map[string]any{"choiceCount": 2}}
+	default:
+		return "", fmt.Errorf("unknown load balancing policy %q", cfg.Balancer)
+	}
+	// An empty name matches every method.
+	methodConfig := map[string]any{"name": []any{map[string]any{}}}
+	if cfg.MaxAttempts > 1 {
+		methodConfig["retryPolicy"] = map[string]any{
+			"maxAttempts":          cfg.MaxAttempts,
+			"initialBackoff":       "0.1s",
+			"maxBackoff":           "1s",
+			"backoffMultiplier":    2,
+			"retryableStatusCodes": []string{"UNAVAILABLE"},
+		}
+	}
+	sc := map[string]any{
+		"loadBalancingConfig": []any{lb},
+		"methodConfig":        []any{methodConfig},
+	}
+	b, err := json.Marshal(sc)
+	return string(b), err
+}
+
+// DeadlineInterceptor returns a client interceptor that
+// bounds calls whose context has no deadline by the given
+// timeout.
+func DeadlineInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
 }
@@ -0,0 +1,21 @@
+package grpcutil
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// NewHealthServer registers a grpc.health.v1 service on
+// srv that reports the server and every service registered
+// on it so far as serving. Call Shutdown on the returned
+// server before stopping srv, so that health checks fail
+// while in-flight calls drain.
+func NewHealthServer(srv *grpc.Server) *health.Server {
+	hs := health.NewServer()
+	for name := range srv.GetServiceInfo() {
+		hs.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	healthpb.RegisterHealthServer(srv, hs)
+	return hs
+}
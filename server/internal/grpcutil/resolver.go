@@ -0,0 +1,118 @@
+package grpcutil
+
+import (
+	"context"
+	"errors"
+	"ffmpeg/wrapper/pkg/discovery"
+	"fmt"
+
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the gRPC target scheme resolved through a
+// discovery.Registry, e.g. "discovery:///metadata".
+const Scheme = "discovery"
+
+// Target returns the gRPC target of the given service.
+func Target(serviceName string) string {
+	return fmt.Sprintf("%s:///%s", Scheme, serviceName)
+}
+
+type instanceKey struct{}
+
+// InstanceFromAddress returns the registry instance a
+// resolved address belongs to, e.g. for a balancer picking
+// instances by zone.
+func InstanceFromAddress(addr resolver.Address) (discovery.Instance, bool) {
+	i, ok := addr.BalancerAttributes.Value(instanceKey{}).(*discovery.Instance)
+	if !ok {
+		return discovery.Instance{}, false
+	}
+	return *i, true
+}
+
+// resolverBuilder builds resolvers that keep the addresses
+// of a service up to date by watching the registry.
+type resolverBuilder struct {
+	registry discovery.Registry
+}
+
+// NewResolverBuilder creates a gRPC resolver builder for
+// the Scheme target scheme, backed by the given registry.
+func NewResolverBuilder(registry discovery.Registry) resolver.Builder {
+	return &resolverBuilder{registry: registry}
+}
+
+// Build creates a resolver for the service named by the
+// endpoint of the target.
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	serviceName := target.Endpoint()
+	if serviceName == "" {
+		return nil, errors.New("discovery target must name a service, e.g. discovery:///metadata")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := b.registry.Watch(ctx, serviceName)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to watch service %s: %w", serviceName, err)
+	}
+	r := &registryResolver{
+		serviceName: serviceName,
+		cc:          cc,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	go r.watch(updates)
+	return r, nil
+}
+
+// Scheme returns the target scheme handled by the builder.
+func (b *resolverBuilder) Scheme() string {
+	return Scheme
+}
+
+type registryResolver struct {
+	serviceName string
+	cc          resolver.ClientConn
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// ResolveNow does nothing, the registry watch already
+// pushes every change.
+func (r *registryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close stops the resolver.
+func (r *registryResolver) Close() {
+	r.cancel()
+	<-r.done
+}
+
+// watch pushes the instances of the service to gRPC until
+// the registry watch ends.
+func (r *registryResolver) watch(updates <-chan []discovery.Instance) {
+	defer close(r.done)
+	for instances := range updates {
+		if len(instances) == 0 {
+			// Drop the connections to the instances that went
+			// away, and report the error to make gRPC fail
+			// calls fast instead of waiting for an instance
+			// that may never come.
+			_ = r.cc.UpdateState(resolver.State{})
+			r.cc.ReportError(fmt.Errorf("service %s: %w", r.serviceName, discovery.ErrNotFound))
+			continue
+		}
+		state := resolver.State{Addresses: make([]resolver.Address, 0, len(instances))}
+		for _, i := range instances {
+			// Balancer attributes do not identify the address,
+			// so changed metadata does not reconnect. They
+			// must be comparable, hence the pointer.
+			state.Addresses = append(state.Addresses, resolver.Address{
+				Addr:               i.HostPort,
+				BalancerAttributes: attributes.New(instanceKey{}, &i),
+			})
+		}
+		_ = r.cc.UpdateState(state)
+	}
+}
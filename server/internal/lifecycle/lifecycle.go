@@ -0,0 +1,189 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// Lifecycle traps termination signals and shuts down the
+// components of a service in order.
+//
+// Shutdown hooks run in reverse registration order, like
+// deferred calls, so a service that registers tracing,
+// service discovery, Kafka and finally its servers stops the
+// servers first and flushes tracing last.
+type Lifecycle struct {
+	logger  *zap.Logger
+	timeout time.Duration
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu    sync.Mutex
+	hooks []hook
+}
+
+type hook struct {
+	name    string
+	timeout time.Duration
+	fn      func(context.Context) error
+}
+
+// ErrSignal is the cause of the lifecycle context
+// cancellation when a termination signal is received.
+var ErrSignal = errors.New("termination signal received")
+
+// New creates a lifecycle that is stopped by SIGINT or
+// SIGTERM. Hooks registered without a timeout get the given
+// default timeout.
+func New(logger *zap.Logger, timeout time.Duration) *Lifecycle {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	l := &Lifecycle{logger: logger, timeout: timeout, ctx: ctx, cancel: cancel}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-sig:
+			logger.Info("Received signal, shutting down", zap.String("signal", s.String()))
+			cancel(ErrSignal)
+		case <-ctx.Done():
+		}
+		signal.Stop(sig)
+	}()
+	return l
+}
+
+// Context returns a context that is cancelled when the
+// service starts shutting down.
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Shutdown starts the shutdown of the service because of
+// the given error.
+func (l *Lifecycle) Shutdown(err error) {
+	l.cancel(err)
+}
+
+// OnShutdown registers a hook that is called during
+// shutdown with a context bounded by the timeout.
+func (l *Lifecycle) OnShutdown(name string, timeout time.Duration, fn func(context.Context) error) {
+	if timeout <= 0 {
+		timeout = l.timeout
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, hook{name: name, timeout: timeout, fn: fn})
+}
+
+// Go runs fn in the background with a context that is
+// cancelled on shutdown. Shutdown waits up to the timeout
+// for fn to return, which lets it drain in-flight work.
+// A non-nil error returned by fn shuts the service down.
+func (l *Lifecycle) Go(name string, timeout time.Duration, fn func(context.Context) error) {
+	ctx, cancel := context.WithCancel(l.ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := fn(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			l.logger.Error("Component failed", zap.String("component", name), zap.Error(err))
+			l.Shutdown(err)
+		}
+	}()
+	l.OnShutdown(name, timeout, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// Serve runs a blocking serve function, such as
+// grpc.Server.Serve, in the background and shuts the
+// service down if it fails. stop is registered as the
+// shutdown hook of the server.
+func (l *Lifecycle) Serve(name string, serve func() error, stop func(context.Context) error) {
+	l.OnShutdown(name, 0, stop)
+	go func() {
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, grpc.ErrServerStopped) {
+			l.logger.Error("Server failed", zap.String("server", name), zap.Error(err))
+			l.Shutdown(err)
+		}
+	}()
+}
+
+// Wait blocks until a termination signal is received or a
+// component fails, then runs the shutdown hooks. It
+// returns the error that caused the shutdown, or nil when
+// the service was stopped by a signal.
+func (l *Lifecycle) Wait() error {
+	<-l.ctx.Done()
+
+	l.mu.Lock()
+	hooks := l.hooks
+	l.hooks = nil
+	l.mu.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+		start := time.Now()
+		if err := h.fn(ctx); err != nil {
+			l.logger.Error("Shutdown step failed", zap.String("step", h.name), zap.Error(err))
+		} else {
+			l.logger.Info("Shutdown step completed", zap.String("step", h.name), zap.Duration("took", time.Since(start)))
+		}
+		cancel()
+	}
+
+	if cause := context.Cause(l.ctx); !errors.Is(cause, ErrSignal) {
+		return cause
+	}
+	return nil
+}
+
+// GRPCStopper returns a shutdown hook that gracefully stops
+// the server, and stops it forcefully once the hook
+// context expires.
+func GRPCStopper(srv *grpc.Server) func(context.Context) error {
+	return func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			srv.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// HTTPStopper returns a shutdown hook that gracefully shuts
+// down the server, and closes it forcefully once the hook
+// context expires.
+func HTTPStopper(srv *http.Server) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			return err
+		}
+		return nil
+	}
+}
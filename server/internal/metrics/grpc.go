@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "Total number of RPCs completed on the server, regardless of success or failure.",
+	}, []string{"grpc_service", "grpc_method", "grpc_code"})
+	grpcHandlingSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Response latency of RPCs handled by the server.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"grpc_service", "grpc_method"})
+)
+
+// UnaryServerInterceptor records the rate, errors and
+// duration of unary RPCs.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		service, method := path.Split(info.FullMethod)
+		service = path.Base(service)
+		grpcHandled.WithLabelValues(service, method, status.Code(err).String()).Inc()
+		grpcHandlingSeconds.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+		return resp, err
+	}
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	kafkaLagDesc = prometheus.NewDesc("kafka_consumer_lag",
+		"Number of messages between the last consumed offset and the end of the partition.",
+		[]string{"topic", "group"}, nil)
+	kafkaMessagesDesc = prometheus.NewDesc("kafka_consumer_messages_total",
+		"Total number of messages consumed.",
+		[]string{"topic", "group"}, nil)
+	kafkaErrorsDesc = prometheus.NewDesc("kafka_consumer_errors_total",
+		"Total number of errors returned by the consumer.",
+		[]string{"topic", "group"}, nil)
+)
+
+// KafkaReaderCollector exposes the statistics of the Kafka
+// readers of a consumer group, one per topic.
+type KafkaReaderCollector struct {
+	readers []*kafka.Reader
+	group   string
+
+	// kafka.Reader.Stats resets its counters on every
+	// call, so the totals are accumulated here.
+	mu       sync.Mutex
+	messages []int64
+	errors   []int64
+}
+
+// NewKafkaReaderCollector creates a collector for the given
+// readers of a consumer group.
+func NewKafkaReaderCollector(group string, readers ...*kafka.Reader) *KafkaReaderCollector {
+	return &KafkaReaderCollector{
+		readers:  readers,
+		group:    group,
+		messages: make([]int64, len(readers)),
+		errors:   make([]int64, len(readers)),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *KafkaReaderCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- kafkaLagDesc
+	ch <- kafkaMessagesDesc
+	ch <- kafkaErrorsDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *KafkaReaderCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, reader := range c.readers {
+		s := reader.Stats()
+		c.messages[i] += s.Messages
+		c.errors[i] += s.Errors
+		ch <- prometheus.MustNewConstMetric(kafkaLagDesc, prometheus.GaugeValue, float64(s.Lag), s.Topic, c.group)
+		ch <- prometheus.MustNewConstMetric(kafkaMessagesDesc, prometheus.CounterValue, float64(c.messages[i]), s.Topic, c.group)
+		ch <- prometheus.MustNewConstMetric(kafkaErrorsDesc, prometheus.CounterValue, float64(c.errors[i]), s.Topic, c.group)
+	}
+}
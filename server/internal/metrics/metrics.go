@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler returns the HTTP handler exposing the metrics of
+// the default Prometheus registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// NewServer creates the HTTP server exposing /metrics on the
+// given address.
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return &http.Server{Addr: addr, Handler: mux}
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	s3OperationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "s3_operation_duration_seconds",
+		Help:    "Latency of S3 operations, including retries.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"operation"})
+	s3OperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "s3_operation_errors_total",
+		Help: "Total number of failed S3 operations.",
+	}, []string{"operation"})
+)
+
+// AddS3Metrics is an S3 client API option that records the
+// latency and errors of every operation:
+//
+//	s3.NewFromConfig(cfg, func(o *s3.Options) {
+//		o.APIOptions = append(o.APIOptions, metrics.AddS3Metrics)
+//	})
+func AddS3Metrics(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("S3Metrics",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, md, err := next.HandleInitialize(ctx, in)
+			op := awsmiddleware.GetOperationName(ctx)
+			s3OperationSeconds.WithLabelValues(op).Observe(time.Since(start).Seconds())
+			if err != nil {
+				s3OperationErrors.WithLabelValues(op).Inc()
+			}
+			return out, md, err
+		}), middleware.Before)
+}
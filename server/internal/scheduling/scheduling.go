@@ -0,0 +1,220 @@
+// Package scheduling orders the compression jobs waiting
+// for a worker, so that no priority and no user starves
+// the others.
+package scheduling
+
+import (
+	"ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+)
+
+// Topic returns the topic the jobs of priority p are
+// published to.
+func Topic(t config.Topics, p model.Priority) string {
+	switch p {
+	case model.PriorityPremium:
+		return t.PremiumJobs
+	case model.PriorityBatch:
+		return t.BatchJobs
+	default:
+		return t.Jobs
+	}
+}
+
+// Weight returns the weight of priority p.
+func Weight(w config.PriorityWeights, p model.Priority) int {
+	switch p {
+	case model.PriorityPremium:
+		return w.Premium
+	case model.PriorityBatch:
+		return w.Batch
+	default:
+		return w.Interactive
+	}
+}
+
+// Queue orders waiting jobs. The priorities with waiting
+// jobs take turns by smooth weighted round-robin, and
+// within a priority the users with waiting jobs take
+// turns, so that a user who submits many jobs delays the
+// others by at most one job per turn. The jobs of a user
+// keep their order. A Queue is not safe for concurrent use.
+type Queue[T any] struct {
+	levels []*level[T]
+	// where locates the jobs in the queue by job ID.
+	where map[int64]location[T]
+}
+
+type level[T any] struct {
+	priority model.Priority
+	weight   int
+	// current is the round-robin credit of the priority.
+	current int
+	// users take turns in order. Each has at least one
+	// waiting job.
+	users []string
+	jobs  map[string][]entry[T]
+	len   int
+}
+
+type entry[T any] struct {
+	id    int64
+	value T
+}
+
+type location[T any] struct {
+	level  *level[T]
+	userID string
+}
+
+// NewQueue creates an empty queue of the given priority
+// weights.
+func NewQueue[T any](weights config.PriorityWeights) *Queue[T] {
+	q := &Queue[T]{where: map[int64]location[T]{}}
+	for _, p := range model.Priorities {
+		q.levels = append(q.levels, &level[T]{
+			priority: p,
+			weight:   Weight(weights, p),
+			jobs:     map[string][]entry[T]{},
+		})
+	}
+	return q
+}
+
+func (q *Queue[T]) level(p model.Priority) *level[T] {
+	for _, l := range q.levels {
+		if l.priority == p {
+			return l
+		}
+	}
+	return q.level(model.PriorityInteractive)
+}
+
+// Push queues job id of the user. Jobs of unknown priority
+// are queued as interactive. Push returns false, and keeps
+// the queued job, if the job is already queued.
+func (q *Queue[T]) Push(id int64, p model.Priority, userID string, value T) bool {
+	if _, ok := q.where[id]; ok {
+		return false
+	}
+	l := q.level(p)
+	if len(l.jobs[userID]) == 0 {
+		l.users = append(l.users, userID)
+	}
+	l.jobs[userID] = append(l.jobs[userID], entry[T]{id: id, value: value})
+	l.len++
+	q.where[id] = location[T]{level: l, userID: userID}
+	return true
+}
+
+// Pop removes and returns the job to start next, and false
+// if the queue is empty.
+func (q *Queue[T]) Pop() (T, bool) {
+	e, ok := q.pop()
+	return e.value, ok
+}
+
+func (q *Queue[T]) pop() (entry[T], bool) {
+	var next *level[T]
+	total := 0
+	for _, l := range q.levels {
+		if l.len == 0 {
+			continue
+		}
+		l.current += l.weight
+		total += l.weight
+		if next == nil || l.current > next.current {
+			next = l
+		}
+	}
+	if next == nil {
+		return entry[T]{}, false
+	}
+	next.current -= total
+
+	userID := next.users[0]
+	jobs := next.jobs[userID]
+	e := jobs[0]
+	if len(jobs) == 1 {
+		delete(next.jobs, userID)
+		next.users = next.users[1:]
+	} else {
+		next.jobs[userID] = jobs[1:]
+		next.users = append(next.users[1:], userID)
+	}
+	next.len--
+	if next.len == 0 {
+		next.current = 0
+	}
+	delete(q.where, e.id)
+	return e, true
+}
+
+// Remove removes job id from the queue, and returns false
+// if it is not queued.
+func (q *Queue[T]) Remove(id int64) bool {
+	loc, ok := q.where[id]
+	if !ok {
+		return false
+	}
+	delete(q.where, id)
+	l := loc.level
+	jobs := l.jobs[loc.userID]
+	for i, e := range jobs {
+		if e.id == id {
+			jobs = append(jobs[:i:i], jobs[i+1:]...)
+			break
+		}
+	}
+	l.len--
+	if l.len == 0 {
+		l.current = 0
+	}
+	if len(jobs) > 0 {
+		l.jobs[loc.userID] = jobs
+		return true
+	}
+	delete(l.jobs, loc.userID)
+	for i, u := range l.users {
+		if u == loc.userID {
+			l.users = append(l.users[:i:i], l.users[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Len returns the number of queued jobs of priority p.
+func (q *Queue[T]) Len(p model.Priority) int {
+	return q.level(p).len
+}
+
+// Position returns the number of queued jobs that start
+// before job id, and false if it is not queued.
+func (q *Queue[T]) Position(id int64) (int, bool) {
+	if _, ok := q.where[id]; !ok {
+		return 0, false
+	}
+	c := q.clone()
+	for n := 0; ; n++ {
+		if e, _ := c.pop(); e.id == id {
+			return n, true
+		}
+	}
+}
+
+// clone copies the queue, sharing the job slices that
+// pop only reslices.
+func (q *Queue[T]) clone() *Queue[T] {
+	c := &Queue[T]{}
+	for _, l := range q.levels {
+		cl := *l
+		cl.users = append([]string(nil), l.users...)
+		cl.jobs = make(map[string][]entry[T], len(l.jobs))
+		for u, jobs := range l.jobs {
+			cl.jobs[u] = jobs
+		}
+		c.levels = append(c.levels, &cl)
+	}
+	return c
+}
@@ -0,0 +1,112 @@
+package scheduling
+
+import (
+	"ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	"slices"
+	"testing"
+)
+
+// popAll pops the queue until it is empty, and returns the
+// IDs of the jobs in order.
+func popAll(q *Queue[int64]) []int64 {
+	var ids []int64
+	for {
+		id, ok := q.Pop()
+		if !ok {
+			return ids
+		}
+		ids = append(ids, id)
+	}
+}
+
+func TestQueueWeightedRoundRobin(t *testing.T) {
+	q := NewQueue[int64](config.PriorityWeights{Premium: 8, Interactive: 4, Batch: 1})
+	id := int64(0)
+	for _, p := range model.Priorities {
+		for range 26 {
+			id++
+			q.Push(id, p, "user", id)
+		}
+	}
+	priority := func(id int64) model.Priority {
+		return model.Priorities[(id-1)/26]
+	}
+	// The first 13 jobs started hold the share of each
+	// priority, and batch jobs start before the other
+	// queues are empty.
+	counts := map[model.Priority]int{}
+	for range 13 {
+		id, _ := q.Pop()
+		counts[priority(id)]++
+	}
+	want := map[model.Priority]int{model.PriorityPremium: 8, model.PriorityInteractive: 4, model.PriorityBatch: 1}
+	for p, n := range want {
+		if counts[p] != n {
+			t.Errorf("started %d %s jobs of 13, want %d", counts[p], p, n)
+		}
+	}
+}
+
+func TestQueueOnlyWaitingPrioritiesTakeTurns(t *testing.T) {
+	q := NewQueue[int64](config.PriorityWeights{Premium: 8, Interactive: 4, Batch: 1})
+	for id := int64(1); id <= 3; id++ {
+		q.Push(id, model.PriorityBatch, "user", id)
+	}
+	if got := popAll(q); !slices.Equal(got, []int64{1, 2, 3}) {
+		t.Errorf("popped %v, want [1 2 3]", got)
+	}
+}
+
+func TestQueueUsersTakeTurns(t *testing.T) {
+	q := NewQueue[int64](config.PriorityWeights{Premium: 8, Interactive: 4, Batch: 1})
+	// Alice submits 4 jobs before Bob and Carol submit 2
+	// and 1.
+	for id := int64(1); id <= 4; id++ {
+		q.Push(id, model.PriorityInteractive, "alice", id)
+	}
+	q.Push(5, model.PriorityInteractive, "bob", 5)
+	q.Push(6, model.PriorityInteractive, "bob", 6)
+	q.Push(7, model.PriorityInteractive, "carol", 7)
+
+	want := []int64{1, 5, 7, 2, 6, 3, 4}
+	if got := popAll(q); !slices.Equal(got, want) {
+		t.Errorf("popped %v, want %v", got, want)
+	}
+}
+
+func TestQueuePushTwice(t *testing.T) {
+	q := NewQueue[int64](config.PriorityWeights{Premium: 8, Interactive: 4, Batch: 1})
+	if !q.Push(1, model.PriorityBatch, "alice", 1) {
+		t.Fatal("first push of job 1 failed")
+	}
+	if q.Push(1, model.PriorityPremium, "alice", 1) {
+		t.Error("second push of job 1 succeeded")
+	}
+	if n := q.Len(model.PriorityPremium); n != 0 {
+		t.Errorf("%d premium jobs queued, want 0", n)
+	}
+}
+
+func TestQueuePositionAndRemove(t *testing.T) {
+	q := NewQueue[int64](config.PriorityWeights{Premium: 8, Interactive: 4, Batch: 1})
+	q.Push(1, model.PriorityInteractive, "alice", 1)
+	q.Push(2, model.PriorityInteractive, "alice", 2)
+	q.Push(3, model.PriorityInteractive, "bob", 3)
+
+	// Bob's job starts before Alice's second one.
+	for id, want := range map[int64]int{1: 0, 3: 1, 2: 2} {
+		if pos, ok := q.Position(id); !ok || pos != want {
+			t.Errorf("Position(%d) = %d, %v, want %d, true", id, pos, ok, want)
+		}
+	}
+	if !q.Remove(3) {
+		t.Fatal("Remove(3) failed")
+	}
+	if _, ok := q.Position(3); ok {
+		t.Error("removed job 3 is still queued")
+	}
+	if got := popAll(q); !slices.Equal(got, []int64{1, 2}) {
+		t.Errorf("popped %v, want [1 2]", got)
+	}
+}
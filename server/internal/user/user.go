@@ -0,0 +1,53 @@
+package user
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey is the gRPC metadata key carrying the ID of
+// the user a call is made for.
+const MetadataKey = "x-user-id"
+
+// Anonymous is the user of unauthenticated requests when
+// the gateway does not require authentication.
+const Anonymous = "anonymous"
+
+type ctxKey struct{}
+
+// WithID returns a copy of ctx carrying the given user ID.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// ID returns the user ID carried by ctx, or "" if there is
+// none.
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// UnaryClientInterceptor forwards the user ID of the call
+// context in the outgoing gRPC metadata.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if id := ID(ctx); id != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// UnaryServerInterceptor puts the user ID of the incoming
+// gRPC metadata into the handler context, so that calls
+// made by the handler forward it too.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if ids := metadata.ValueFromIncomingContext(ctx, MetadataKey); len(ids) > 0 && ids[0] != "" {
+			ctx = WithID(ctx, ids[0])
+		}
+		return handler(ctx, req)
+	}
+}
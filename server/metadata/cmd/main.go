@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
 	"ffmpeg/wrapper/gen"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/discoveryutil"
+	"ffmpeg/wrapper/internal/grpcutil"
+	"ffmpeg/wrapper/internal/lifecycle"
+	"ffmpeg/wrapper/internal/metrics"
+	"ffmpeg/wrapper/internal/user"
 	metadata "ffmpeg/wrapper/metadata/internal/controller/metadata"
 	"ffmpeg/wrapper/metadata/internal/repository"
+	"ffmpeg/wrapper/metadata/internal/retention"
 	"ffmpeg/wrapper/pkg/discovery"
-	"ffmpeg/wrapper/pkg/discovery/consul"
 	"ffmpeg/wrapper/pkg/discovery/tracing"
 	"fmt"
 	"net"
@@ -17,9 +24,11 @@ import (
 	grpchandler "ffmpeg/wrapper/metadata/internal/handler/grpc"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
 	"github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel"
@@ -27,7 +36,6 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
-	"gopkg.in/yaml.v3"
 )
 
 const serviceName = "metadata"
@@ -36,94 +44,149 @@ func main() {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	f, err := os.Open("../configs/default.yaml")
-	if err != nil {
-		logger.Fatal("Failed to open configuration", zap.Error(err))
-	}
-	var cfg configuration
-	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
-		logger.Fatal("Failed to parse configuration", zap.Error(err))
+	cfg, err := config.Load(serviceName, os.Args[1:], config.RequireStorage, config.RequireKafka, config.RequireMetrics, config.RequireRetention)
+	if errors.Is(err, config.ErrConfigPrinted) {
+		return
+	} else if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 	port := cfg.API.Port
 
 	logger.Info("Starting the metadata service", zap.Int("port", port))
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	lc := lifecycle.New(logger, cfg.Limits.ShutdownTimeout)
+	ctx := lc.Context()
 	tp, err := tracing.NewJaegerProvider(ctx, cfg.Jaeger.URL, serviceName)
 	if err != nil {
 		logger.Fatal("Failed to initialize Jaeger provider", zap.Error(err))
 	}
-	defer func() {
-		if err := tp.Shutdown(ctx); err != nil {
-			logger.Fatal("Failed to shut down Jaeger provider", zap.Error(err))
-		}
-	}()
+	lc.OnShutdown("tracing", 0, tp.Shutdown)
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(propagation.TraceContext{})
 
-	registry, err := consul.NewRegistry(cfg.ServiceDiscovery.Consul.Address)
+	registry, err := discoveryutil.NewRegistry(lc, cfg.ServiceDiscovery)
 	if err != nil {
 		panic(err)
 	}
 
 	instanceID := discovery.GenerateInstanceID(serviceName)
-	if err := registry.Register(ctx, instanceID, serviceName, fmt.Sprintf("metadata:%d", port)); err != nil {
+	metricsInstanceID := discovery.GenerateInstanceID(serviceName + "-metrics")
+	registerOpts := cfg.ServiceDiscovery.RegisterOptions()
+	if err := registry.Register(ctx, instanceID, serviceName, cfg.ServiceDiscovery.HostPort("metadata", port),
+		append(registerOpts, discovery.WithGRPCHealthCheck(cfg.ServiceDiscovery.HealthCheckInterval))...); err != nil {
+		panic(err)
+	}
+	if err := registry.Register(ctx, metricsInstanceID, serviceName+"-metrics", cfg.ServiceDiscovery.HostPort("metadata", cfg.Prometheus.MetricsPort),
+		append(registerOpts, discovery.WithTags("metrics"))...); err != nil {
 		panic(err)
 	}
-	go func() {
+	lc.OnShutdown("service discovery", 0, func(ctx context.Context) error {
+		return errors.Join(
+			registry.Deregister(ctx, instanceID, serviceName),
+			registry.Deregister(ctx, metricsInstanceID, serviceName+"-metrics"),
+		)
+	})
+	lc.Go("health reporting", 0, func(ctx context.Context) error {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
 		for {
-			if err := registry.ReportHealthyState(instanceID, serviceName); err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+			if err := registry.ReportHealthyState(metricsInstanceID, serviceName+"-metrics"); err != nil {
 				logger.Error("Failed to report healthy state", zap.Error(err))
 			}
-			time.Sleep(1 * time.Second)
 		}
-	}()
-	defer registry.Deregister(ctx, instanceID, serviceName)
-
-	var accountId = os.Getenv("accountId")
-	var accessKeyId = os.Getenv("accessKeyId")
-	var accessKey = os.Getenv("secretKey")
+	})
 
-	AWScfg, err := config.LoadDefaultConfig(ctx,
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyId, accessKey, "")),
-		config.WithRegion("auto"),
+	AWScfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.Storage.AccessKeyID, cfg.Storage.SecretKey, "")),
+		awsconfig.WithRegion(cfg.Storage.Region),
 	)
 	if err != nil {
 		logger.Fatal("Failed to load AWS s3 configuration", zap.Error(err))
 	}
 
 	s3Client := s3.NewFromConfig(AWScfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", accountId))
+		o.BaseEndpoint = aws.String(cfg.Storage.BaseEndpoint())
 		o.UsePathStyle = true
+		o.APIOptions = append(o.APIOptions, metrics.AddS3Metrics)
 	})
 
 	presignClient := s3.NewPresignClient(s3Client)
 	repository := repository.New(presignClient, s3Client)
 
 	// conn, err := kafka.DialLeader(ctx, "tcp", os.Getenv("kafkaBroker"), "compression-job", 0)
+	// Jobs are published to the topic of their priority.
 	kafkaWriter := &kafka.Writer{
-		Addr:        kafka.TCP(os.Getenv("kafkaBroker")),
-		Topic:       "compression-job",
-		Balancer:    &kafka.LeastBytes{},
-		Logger:      kafka.LoggerFunc(logf),
-		ErrorLogger: kafka.LoggerFunc(logf),
+		Addr:                   kafka.TCP(cfg.Kafka.Brokers...),
+		Balancer:               &kafka.LeastBytes{},
+		AllowAutoTopicCreation: true,
+		Logger:                 kafka.LoggerFunc(logf),
+		ErrorLogger:            kafka.LoggerFunc(logf),
 	}
+	lc.OnShutdown("kafka writer", 0, func(context.Context) error { return kafkaWriter.Close() })
+
+	var retentionStore retention.Store
+	if cfg.Redis.Address != "" {
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:         cfg.Redis.Address,
+			Password:     cfg.Redis.Password,
+			DB:           cfg.Redis.DB,
+			DialTimeout:  cfg.Redis.Timeout,
+			ReadTimeout:  cfg.Redis.Timeout,
+			WriteTimeout: cfg.Redis.Timeout,
+		})
+		lc.OnShutdown("redis client", 0, func(context.Context) error { return redisClient.Close() })
+		retentionStore = retention.NewRedisStore(redisClient, cfg.Redis.Timeout)
+	} else {
+		logger.Warn("No Redis configured, object expiries are kept in memory and only one replica may run")
+		retentionStore = retention.NewMemoryStore()
+	}
+	// The replicas share a consumer group, so that each
+	// result is recorded once.
+	retentionReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     cfg.Kafka.Brokers,
+		Topic:       cfg.Kafka.Topics.Results,
+		GroupID:     cfg.Retention.ConsumerGroup,
+		MaxBytes:    10e6,
+		StartOffset: kafka.FirstOffset,
+	})
+	lc.OnShutdown("kafka retention reader", 0, func(context.Context) error { return retentionReader.Close() })
+	prometheus.MustRegister(metrics.NewKafkaReaderCollector(cfg.Retention.ConsumerGroup, retentionReader))
+	retentionManager := retention.New(cfg, retentionStore, repository, retentionReader, instanceID)
+	lc.Go("retention consumer", 0, retentionManager.Consume)
+	lc.Go("retention sweeper", 0, retentionManager.Run)
 
-	ctrl := metadata.New(repository, kafkaWriter)
+	ctrl := metadata.New(repository, kafkaWriter, retentionManager, cfg)
 	h := grpchandler.New(ctrl)
-	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	addr := fmt.Sprintf("%s:%d", cfg.API.Host, port)
+	metricsSrv := metrics.NewServer(fmt.Sprintf(":%d", cfg.Prometheus.MetricsPort))
+	lc.Serve("metrics server", metricsSrv.ListenAndServe, lifecycle.HTTPStopper(metricsSrv))
+
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		logger.Fatal("Failed to listen network connection", zap.Error(err))
 	}
-	srv := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	srv := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor(), user.UnaryServerInterceptor()),
+	)
 	reflection.Register(srv)
 	gen.RegisterMetadataServiceServer(srv, h)
-	if err := srv.Serve(lis); err != nil {
-		panic(err)
-	}
+	healthSrv := grpcutil.NewHealthServer(srv)
+	lc.Serve("grpc server", func() error { return srv.Serve(lis) }, lifecycle.GRPCStopper(srv))
+	lc.OnShutdown("grpc health", 0, func(context.Context) error {
+		healthSrv.Shutdown()
+		return nil
+	})
 
+	if err := lc.Wait(); err != nil {
+		logger.Fatal("Metadata service stopped", zap.Error(err))
+	}
+	logger.Info("Metadata service stopped")
 }
 
 func logf(msg string, a ...any) {
@@ -4,44 +4,80 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/scheduling"
+	"ffmpeg/wrapper/internal/user"
 	"ffmpeg/wrapper/metadata/internal/repository"
+	"ffmpeg/wrapper/metadata/internal/retention"
 	"ffmpeg/wrapper/metadata/pkg/model"
+	"ffmpeg/wrapper/pkg/discovery/tracing"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"log"
+	"mime"
 	"os"
 	"os/exec"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/vansante/go-ffprobe.v2"
 )
 
-var bucketName = os.Getenv("bucketname")
 var ErrNotFound = errors.New("not found")
 
+// ErrNotRetained is returned for objects that are deleted,
+// or about to be.
+var ErrNotRetained = errors.New("object is no longer retained")
+
+// minDownloadURLLifetime is the shortest download URL
+// worth presigning.
+const minDownloadURLLifetime = time.Minute
+
+// objectKeyTimeLayout prefixes the keys of uploads.
+const objectKeyTimeLayout = "20060102T150405"
+
+const tracerID = "metadata-controller"
+
 type Controller struct {
 	repo        repository.S3
 	kafkaWriter *kafka.Writer
+	retention   *retention.Manager
+	bucket      string
+	limits      config.Limits
+	topics      config.Topics
 }
 
-func New(repository repository.S3, writer *kafka.Writer) *Controller {
+func New(repository repository.S3, writer *kafka.Writer, retention *retention.Manager, cfg *config.Config) *Controller {
 	return &Controller{
 		repo:        repository,
 		kafkaWriter: writer,
+		retention:   retention,
+		bucket:      cfg.Storage.Bucket,
+		limits:      cfg.Limits,
+		topics:      cfg.Kafka.Topics,
 	}
 }
 
 func (c *Controller) GetMetadata(ctx context.Context, objectKey string) (*model.Metadata, error) {
-	filename, err := c.repo.DownloadObject(ctx, bucketName, objectKey, objectKey)
+	filename, err := c.repo.DownloadObject(ctx, c.bucket, objectKey, objectKey)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancelFn := context.WithTimeout(ctx, 5*time.Second)
+	ctx, cancelFn := context.WithTimeout(ctx, c.limits.ProbeTimeout)
 	defer cancelFn()
 	defer os.Remove(filename)
 
@@ -49,6 +85,10 @@ func (c *Controller) GetMetadata(ctx context.Context, objectKey string) (*model.
 	if err != nil {
 		return nil, err
 	}
+	sum, err := fileSHA256(filename)
+	if err != nil {
+		return nil, err
+	}
 	meta := &model.Metadata{
 		Filename:       data.Format.Filename,
 		NbStreams:      data.Format.NBStreams,
@@ -65,12 +105,47 @@ func (c *Controller) GetMetadata(ctx context.Context, objectKey string) (*model.
 			MajorBrand:       data.Format.Tags.MajorBrand,
 			MinorVersion:     data.Format.Tags.MinorVersion,
 		},
+		SHA256: sum,
+	}
+	if stream := data.FirstVideoStream(); stream != nil {
+		meta.VideoCodec = stream.CodecName
+		meta.Rotation = rotation(stream)
+	}
+	if stream := data.FirstAudioStream(); stream != nil {
+		meta.AudioCodec = stream.CodecName
 	}
 	log.Println(meta)
 	return meta, nil
 }
+
+// rotation returns the rotation of a video stream, from its
+// display matrix or, in files written by older muxers, its
+// rotate tag.
+func rotation(stream *ffprobe.Stream) int {
+	if m, err := stream.SideDataList.GetDisplayMatrix(); err == nil {
+		return m.Rotation
+	}
+	if rotate, err := stream.TagList.GetInt("rotate"); err == nil {
+		return int(rotate)
+	}
+	return 0
+}
+
+// fileSHA256 returns the hex SHA-256 digest of the file.
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", filename, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 func (c *Controller) GetThumbnail(ctx context.Context, objectKey string) (string, error) {
-	fileName, err := c.repo.DownloadPartialObject(ctx, bucketName, objectKey, objectKey, 1048575)
+	fileName, err := c.repo.DownloadPartialObject(ctx, c.bucket, objectKey, objectKey, 1048575)
 	if err != nil {
 		return "", err
 	}
@@ -95,29 +170,85 @@ func (c *Controller) GetThumbnail(ctx context.Context, objectKey string) (string
 	}
 	return thumbNailPath, nil
 }
-func (c *Controller) GetURL(ctx context.Context, filename string) (*model.UploadURL, error) {
-	objectKey := fmt.Sprintf("%s_%s", time.Now().Format("20060102T150405"), filename)
+
+// GetURL presigns the upload of a new object. A positive
+// size is signed into the URL, so that the storage rejects
+// uploads of any other size. The object is recorded for
+// deletion in case its job never finishes.
+func (c *Controller) GetURL(ctx context.Context, filename string, size int64) (*model.UploadURL, error) {
+	objectKey := fmt.Sprintf("%s_%s", time.Now().Format(objectKeyTimeLayout), filename)
 	// url, err := c.presigner.PresignPutObject(ctx, &s3.PutObjectInput{
 	// 	Bucket:      aws.String(bucketName),
 	// 	Key:         aws.String(objectKey),
 	// 	ContentType: aws.String("video/mp4"),
 	// })
 
-	url, err := c.repo.PutObject(ctx, bucketName, objectKey, 360)
+	expiresAt := time.Now().Add(c.limits.UploadURLLifetime)
+	url, err := c.repo.PutObject(ctx, c.bucket, objectKey, int64(c.limits.UploadURLLifetime.Seconds()), size)
 
 	if err != nil {
 		return nil, err
 	}
 	fmt.Println("Presigned URL:", url.URL)
 	fmt.Println("HTTP method signed:", url.Method)
+	// An unrecorded object is left to the reconciliation.
+	if err := c.retention.RecordUpload(ctx, objectKey, expiresAt); err != nil {
+		log.Printf("Failed to record upload %s: %v", objectKey, err)
+	}
 	upload := &model.UploadURL{
 		JobID:        GenerateObjectKeyInt64Random(filename),
 		PresignedURL: url,
 		ObjectKey:    objectKey,
+		ExpiresAt:    expiresAt,
 	}
 	return upload, nil
 }
 
+// GetDownloadURL presigns the download of objectKey, the
+// output of the upload sourceKey. The URL is valid for
+// lifetime, at most limits.downloadURLLifetime and at most
+// until the object expires. It is saved under the name of
+// the upload, with the extension of the output.
+func (c *Controller) GetDownloadURL(ctx context.Context, objectKey string, sourceKey string, lifetime time.Duration) (*model.DownloadURL, error) {
+	expiry, ok, err := c.retention.Expiry(ctx, objectKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if lifetime <= 0 || lifetime > c.limits.DownloadURLLifetime {
+		lifetime = c.limits.DownloadURLLifetime
+	}
+	lifetime = min(lifetime, expiry.Sub(now)).Truncate(time.Second)
+	if !ok || lifetime < minDownloadURLLifetime {
+		return nil, ErrNotRetained
+	}
+	filename := downloadFilename(sourceKey, objectKey)
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	url, err := c.repo.GetObject(ctx, c.bucket, objectKey, int64(lifetime.Seconds()), disposition)
+	if err != nil {
+		return nil, err
+	}
+	return &model.DownloadURL{
+		PresignedURL: url,
+		ExpiresAt:    now.Add(lifetime),
+		Filename:     filename,
+	}, nil
+}
+
+// downloadFilename names the output objectKey of an upload
+// after the filename it was uploaded with, e.g.
+// clip_compressed.mp4 for clip.mp4, or clip_compressed.gif
+// for a GIF image of it.
+func downloadFilename(sourceKey string, objectKey string) string {
+	name := sourceKey
+	if prefix, rest, ok := strings.Cut(sourceKey, "_"); ok {
+		if _, err := time.Parse(objectKeyTimeLayout, prefix); err == nil {
+			name = rest
+		}
+	}
+	return strings.TrimSuffix(name, path.Ext(name)) + "_compressed" + path.Ext(objectKey)
+}
+
 // GenerateObjectKeyInt64 generates an int64 object key based on the filename.
 func GenerateObjectKeyInt64(filename string) int64 {
 	h := fnv.New64a()         // 64-bit FNV-1a hash
@@ -140,17 +271,51 @@ func GenerateObjectKeyInt64Random(filename string) int64 {
 	return base ^ random
 }
 
-func (c *Controller) PublishCompressionEvent(ctx context.Context, jobID int64, objectKey string, meta *model.Metadata) error {
+// PublishCompressionEvent enqueues the compression of the
+// object into outputFormat to the topic of its priority. A
+// non-nil callback is notified of the result. The object is kept at least
+// retention.uploads from now.
+func (c *Controller) PublishCompressionEvent(ctx context.Context, jobID int64, objectKey string, meta *model.Metadata, callback *compressionmodel.Callback, priority compressionmodel.Priority, outputFormat compressionmodel.OutputFormat) error {
 	event := model.CompressionEvent{
-		JobID:     jobID,
-		ObjectKey: objectKey,
-		Metadata:  *meta,
+		JobID:        jobID,
+		ObjectKey:    objectKey,
+		Metadata:     *meta,
+		UserID:       user.ID(ctx),
+		Callback:     callback,
+		JobType:      compressionmodel.JobTypeCompress,
+		Priority:     priority,
+		OutputFormat: outputFormat,
+	}
+	topic := scheduling.Topic(c.topics, priority)
+	if err := c.retention.RecordUpload(ctx, objectKey, time.Now()); err != nil {
+		log.Printf("Failed to record upload %s: %v", objectKey, err)
 	}
 	payload, err := json.Marshal(event)
 	if err != nil {
 		return fmt.Errorf("marshal error: %w", err)
 	}
-	log.Printf("Publishing payload: %s", string(payload))
+	// The payload is not logged, as it holds the secret of
+	// the callback.
+	log.Printf("Publishing compression event for job %d", jobID)
+
+	ctx, span := otel.Tracer(tracerID).Start(ctx, "Kafka/PublishCompressionEvent",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.Int64("job.id", jobID),
+			attribute.String("object.key", objectKey),
+			attribute.String("user.id", event.UserID),
+			attribute.String("job.priority", string(priority)),
+			attribute.String("messaging.destination.name", topic),
+		),
+	)
+	defer span.End()
+	msg := kafka.Message{
+		Topic: topic,
+		Key:   []byte(fmt.Sprintf("%d", jobID)),
+		Value: payload,
+	}
+	tracing.InjectKafka(ctx, &msg)
+
 	const retries = 3
 	for range retries {
 		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -158,17 +323,14 @@ func (c *Controller) PublishCompressionEvent(ctx context.Context, jobID int64, o
 
 		// attempt to create topic prior to publishing the message
 
-		err := c.kafkaWriter.WriteMessages(ctx,
-			kafka.Message{
-				Key:   []byte(fmt.Sprintf("%d", jobID)),
-				Value: payload,
-			},
-		)
+		err := c.kafkaWriter.WriteMessages(ctx, msg)
 		if errors.Is(err, kafka.LeaderNotAvailable) || errors.Is(err, context.DeadlineExceeded) {
 			time.Sleep(time.Millisecond * 250)
 			continue
 		}
 		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "kafka write error")
 			return fmt.Errorf("kafka write error: %w", err)
 		}
 		break
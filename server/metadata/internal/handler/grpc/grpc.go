@@ -3,13 +3,16 @@ package grpc
 import (
 	"context"
 	"errors"
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
 	"ffmpeg/wrapper/gen"
 	metadata "ffmpeg/wrapper/metadata/internal/controller/metadata"
 	"ffmpeg/wrapper/metadata/pkg/model"
 	"fmt"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Handler defines a video metadata gRPC handler.
@@ -28,12 +31,24 @@ func (h *Handler) GetCompressionJob(ctx context.Context, req *gen.GetCompression
 	if req == nil || req.ObjectKey == "" {
 		return nil, status.Error(codes.InvalidArgument, "nil req or empty objectkey")
 	}
+	priority, ok := compressionmodel.ParsePriority(req.Priority)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown priority %q", req.Priority)
+	}
+	outputFormat, ok := compressionmodel.ParseOutputFormat(req.OutputFormat)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown output format %q", req.OutputFormat)
+	}
 	m, err := h.svc.GetMetadata(ctx, req.ObjectKey)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "%s", err.Error())
 	}
 	fmt.Println(m)
-	err = h.svc.PublishCompressionEvent(ctx, req.JobId, req.ObjectKey, m)
+	var callback *compressionmodel.Callback
+	if req.CallbackUrl != "" {
+		callback = &compressionmodel.Callback{URL: req.CallbackUrl}
+	}
+	err = h.svc.PublishCompressionEvent(ctx, req.JobId, req.ObjectKey, m, callback, priority, outputFormat)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "%s", err.Error())
 	}
@@ -59,7 +74,10 @@ func (h *Handler) GetUploadURL(ctx context.Context, req *gen.GetUploadURLRequest
 	if req == nil || req.Filename == "" {
 		return nil, status.Errorf(codes.InvalidArgument, "nil req or empty path")
 	}
-	url, err := h.svc.GetURL(ctx, req.Filename)
+	if req.Size < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "negative size")
+	}
+	url, err := h.svc.GetURL(ctx, req.Filename, req.Size)
 	if err != nil && errors.Is(err, metadata.ErrNotFound) {
 		return nil, status.Errorf(codes.NotFound, "%s", err.Error())
 	} else if err != nil {
@@ -70,5 +88,23 @@ func (h *Handler) GetUploadURL(ctx context.Context, req *gen.GetUploadURLRequest
 		JobId:        url.JobID,
 		PresignedUrl: model.PresignedToProto(url.PresignedURL),
 		ObjectKey:    url.ObjectKey,
+		ExpiresAt:    timestamppb.New(url.ExpiresAt),
+	}, nil
+}
+
+func (h *Handler) GetDownloadURL(ctx context.Context, req *gen.GetDownloadURLRequest) (*gen.GetDownloadURLResponse, error) {
+	if req == nil || req.ObjectKey == "" || req.SourceKey == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "nil req or empty object key")
+	}
+	url, err := h.svc.GetDownloadURL(ctx, req.ObjectKey, req.SourceKey, time.Duration(req.LifetimeSeconds)*time.Second)
+	if err != nil && errors.Is(err, metadata.ErrNotRetained) {
+		return nil, status.Errorf(codes.NotFound, "%s", err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "%s", err.Error())
+	}
+	return &gen.GetDownloadURLResponse{
+		DownloadUrl: model.PresignedToProto(url.PresignedURL),
+		ExpiresAt:   timestamppb.New(url.ExpiresAt),
+		Filename:    url.Filename,
 	}, nil
 }
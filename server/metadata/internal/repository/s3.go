@@ -36,14 +36,19 @@ const tracerID = "metadata-repository-s3"
 
 // GetObject makes a presigned request that can be used to get an object from a bucket.
 // The presigned request is valid for the specified number of seconds.
+// A non-empty contentDisposition overrides the Content-Disposition of the response.
 func (presigner S3) GetObject(
-	ctx context.Context, bucketName string, objectKey string, lifetimeSecs int64) (*v4.PresignedHTTPRequest, error) {
+	ctx context.Context, bucketName string, objectKey string, lifetimeSecs int64, contentDisposition string) (*v4.PresignedHTTPRequest, error) {
 	_, span := otel.Tracer(tracerID).Start(ctx, "Repository/GetObject")
 	defer span.End()
-	request, err := presigner.PresignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(objectKey),
-	}, func(opts *s3.PresignOptions) {
+	}
+	if contentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(contentDisposition)
+	}
+	request, err := presigner.PresignClient.PresignGetObject(ctx, input, func(opts *s3.PresignOptions) {
 		opts.Expires = time.Duration(lifetimeSecs * int64(time.Second))
 	})
 	if err != nil {
@@ -55,13 +60,20 @@ func (presigner S3) GetObject(
 
 // PutObject makes a presigned request that can be used to put an object in a bucket.
 // The presigned request is valid for the specified number of seconds.
-func (p S3) PutObject(ctx context.Context, bucketname string, objectKey string, lifetimeSecs int64) (*v4.PresignedHTTPRequest, error) {
+// A positive size is signed as the Content-Length of the request.
+func (p S3) PutObject(ctx context.Context, bucketname string, objectKey string, lifetimeSecs int64, size int64) (*v4.PresignedHTTPRequest, error) {
 	_, span := otel.Tracer(tracerID).Start(ctx, "Repository/PutObject")
 	defer span.End()
-	request, err := p.PresignClient.PresignPutObject(ctx, &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket:      aws.String(bucketname),
 		Key:         aws.String(objectKey),
 		ContentType: aws.String("video/mp4"),
+	}
+	if size > 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	request, err := p.PresignClient.PresignPutObject(ctx, input, func(opts *s3.PresignOptions) {
+		opts.Expires = time.Duration(lifetimeSecs * int64(time.Second))
 	})
 	if err != nil {
 		log.Printf("Couldn't get a presigned request to put %v:%v. Here's why: %v\n",
@@ -158,3 +170,48 @@ func (p S3) DownloadPartialObject(ctx context.Context, bucketName string, object
 	}
 	return filePath, nil
 }
+
+// DeleteObjects deletes objects from a bucket, at most 1000
+// per call. Keys that do not exist are not an error.
+func (p S3) DeleteObjects(ctx context.Context, bucketName string, objectKeys []string) error {
+	ctx, span := otel.Tracer(tracerID).Start(ctx, "Repository/DeleteObjects")
+	defer span.End()
+	if len(objectKeys) == 0 {
+		return nil
+	}
+	objects := make([]types.ObjectIdentifier, len(objectKeys))
+	for i, k := range objectKeys {
+		objects[i] = types.ObjectIdentifier{Key: aws.String(k)}
+	}
+	out, err := p.S3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucketName),
+		Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %d objects from %s: %w", len(objectKeys), bucketName, err)
+	}
+	if len(out.Errors) > 0 {
+		e := out.Errors[0]
+		return fmt.Errorf("failed to delete %d of %d objects from %s, %s: %s",
+			len(out.Errors), len(objectKeys), bucketName, aws.ToString(e.Key), aws.ToString(e.Message))
+	}
+	return nil
+}
+
+// ListObjects calls fn with each page of the objects of a
+// bucket, until fn fails.
+func (p S3) ListObjects(ctx context.Context, bucketName string, fn func([]types.Object) error) error {
+	ctx, span := otel.Tracer(tracerID).Start(ctx, "Repository/ListObjects")
+	defer span.End()
+	pages := s3.NewListObjectsV2Paginator(p.S3Client, &s3.ListObjectsV2Input{Bucket: aws.String(bucketName)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list objects of %s: %w", bucketName, err)
+		}
+		if err := fn(page.Contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
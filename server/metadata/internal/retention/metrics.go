@@ -0,0 +1,25 @@
+package retention
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	objectsRecorded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metadata_retention_objects_recorded_total",
+		Help: "Total number of object expiries recorded, by kind of object.",
+	}, []string{"kind"})
+	objectsDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metadata_retention_objects_deleted_total",
+		Help: "Total number of objects deleted, by reason: expired or orphaned.",
+	}, []string{"reason"})
+	sweepErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "metadata_retention_errors_total",
+		Help: "Total number of sweeps and reconciliations that failed.",
+	})
+	leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "metadata_retention_leader",
+		Help: "1 if this replica holds the retention lease.",
+	})
+)
@@ -0,0 +1,124 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// expiriesKey is a sorted set of object keys, scored by
+	// their expiry in Unix milliseconds.
+	expiriesKey = "retention:expiries"
+	leaseKey    = "retention:lease"
+)
+
+// leadScript renews the lease if holder holds it, and
+// acquires it if nobody does.
+var leadScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  redis.call('PEXPIRE', KEYS[1], ARGV[2])
+  return 1
+end
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+  return 1
+end
+return 0
+`)
+
+// RedisStore keeps the records and the lease in Redis,
+// shared by the metadata replicas.
+type RedisStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+// NewRedisStore creates a store on the given Redis client.
+// Each command is bounded by timeout.
+func NewRedisStore(client *redis.Client, timeout time.Duration) *RedisStore {
+	return &RedisStore{client: client, timeout: timeout}
+}
+
+func (s *RedisStore) Record(ctx context.Context, key string, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	err := s.client.ZAdd(ctx, expiriesKey, redis.Z{Score: float64(expiry.UnixMilli()), Member: key}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to record expiry of %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Due(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	keys, err := s.client.ZRangeByScore(ctx, expiriesKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read expired objects: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *RedisStore) Expiry(ctx context.Context, key string) (time.Time, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	score, err := s.client.ZScore(ctx, expiriesKey, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read expiry of %s: %w", key, err)
+	}
+	return time.UnixMilli(int64(score)), true, nil
+}
+
+func (s *RedisStore) Forget(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	members := make([]any, len(keys))
+	for i, k := range keys {
+		members[i] = k
+	}
+	if err := s.client.ZRem(ctx, expiriesKey, members...).Err(); err != nil {
+		return fmt.Errorf("failed to forget %d objects: %w", len(keys), err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Recorded(ctx context.Context, keys []string) ([]bool, error) {
+	res := make([]bool, len(keys))
+	if len(keys) == 0 {
+		return res, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	scores, err := s.client.ZMScore(ctx, expiriesKey, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %d objects: %w", len(keys), err)
+	}
+	// Missing members score 0, which no expiry has.
+	for i, score := range scores {
+		res[i] = score != 0
+	}
+	return res, nil
+}
+
+func (s *RedisStore) Lead(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	res, err := leadScript.Run(ctx, s.client, []string{leaseKey}, holder, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew the retention lease: %w", err)
+	}
+	return res == 1, nil
+}
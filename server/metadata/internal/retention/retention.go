@@ -0,0 +1,260 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/segmentio/kafka-go"
+)
+
+// batchSize is the most objects deleted per request, the
+// limit of the S3 API.
+const batchSize = 1000
+
+// Bucket is the object storage holding the objects.
+type Bucket interface {
+	DeleteObjects(ctx context.Context, bucketName string, objectKeys []string) error
+	// ListObjects calls fn with each page of objects.
+	ListObjects(ctx context.Context, bucketName string, fn func([]types.Object) error) error
+}
+
+// Manager deletes the objects of jobs once their retention
+// ends. Every object is recorded with its expiry, from the
+// upload URL to the result of its job. The replica holding
+// the lease deletes expired objects, and objects no job
+// recorded.
+type Manager struct {
+	cfg        config.Retention
+	store      Store
+	bucket     Bucket
+	bucketName string
+	reader     *kafka.Reader
+	// holder identifies this replica in the lease.
+	holder string
+}
+
+// New creates a manager recording the results read from
+// reader, a consumer group reader of the results topic.
+func New(cfg *config.Config, store Store, bucket Bucket, reader *kafka.Reader, holder string) *Manager {
+	return &Manager{
+		cfg:        cfg.Retention,
+		store:      store,
+		bucket:     bucket,
+		bucketName: cfg.Storage.Bucket,
+		reader:     reader,
+		holder:     holder,
+	}
+}
+
+// RecordUpload keeps an uploaded file for
+// retention.uploads after from, the creation of its upload
+// URL or the start of its job.
+func (m *Manager) RecordUpload(ctx context.Context, objectKey string, from time.Time) error {
+	return m.record(ctx, objectKey, from.Add(m.cfg.Uploads), "upload")
+}
+
+// RecordResult sets the expiry of the objects of a
+// finished job, following the retention of its job type.
+func (m *Manager) RecordResult(ctx context.Context, event compressionmodel.CompressionResultEvent) error {
+	r := m.jobRetention(event.JobType)
+	now := time.Now()
+	switch event.CompressionEventType {
+	case compressionmodel.CompressionEventTypeSuccess:
+		// The download URL must not outlive the output.
+		output := now.Add(r.Output)
+		if event.Expiry.After(output) {
+			output = event.Expiry
+		}
+		return errors.Join(
+			m.record(ctx, event.ObjectKey, now.Add(r.Source), "source"),
+			m.record(ctx, event.CompressedKey, output, "output"),
+		)
+	case compressionmodel.CompressionEventTypeFail, compressionmodel.CompressionEventTypeCancelled:
+		// The output exists if only presigning it failed, or
+		// if deleting the output of a cancelled job did.
+		return errors.Join(
+			m.record(ctx, event.ObjectKey, now.Add(r.Failed), "failed"),
+			m.record(ctx, event.CompressedKey, now.Add(r.Failed), "failed"),
+		)
+	}
+	return nil
+}
+
+// Expiry returns when the object under key is deleted, and
+// false if it is not retained.
+func (m *Manager) Expiry(ctx context.Context, key string) (time.Time, bool, error) {
+	return m.store.Expiry(ctx, key)
+}
+
+func (m *Manager) record(ctx context.Context, key string, expiry time.Time, kind string) error {
+	if key == "" {
+		return nil
+	}
+	if err := m.store.Record(ctx, key, expiry); err != nil {
+		return err
+	}
+	objectsRecorded.WithLabelValues(kind).Inc()
+	return nil
+}
+
+func (m *Manager) jobRetention(jobType compressionmodel.JobType) config.JobRetention {
+	switch jobType {
+	case compressionmodel.JobTypeCompress, "":
+		return m.cfg.JobTypes.Compress
+	}
+	log.Printf("No retention configured for job type %q, keeping its objects as compress jobs", jobType)
+	return m.cfg.JobTypes.Compress
+}
+
+// Consume records the results of jobs until ctx is done.
+// A result is committed once recorded, so results are
+// retried while the store fails.
+func (m *Manager) Consume(ctx context.Context) error {
+	for {
+		msg, err := m.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		var event compressionmodel.CompressionResultEvent
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			log.Printf("Skipping invalid result at offset %d: %v", msg.Offset, err)
+		} else {
+			// The topic may also carry the jobs, which have
+			// no event type.
+			for event.CompressionEventType != "" {
+				err := m.RecordResult(ctx, event)
+				if err == nil {
+					break
+				}
+				log.Printf("Failed to record the objects of job %d, retrying: %v", event.JobID, err)
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(time.Second):
+				}
+			}
+		}
+		if err := m.reader.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to commit result at offset %d: %v", msg.Offset, err)
+		}
+	}
+}
+
+// Run sweeps expired objects every retention.sweepInterval
+// and reconciles the bucket every
+// retention.reconcileInterval, while this replica holds
+// the lease, until ctx is done.
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.cfg.SweepInterval)
+	defer ticker.Stop()
+	var lastReconcile time.Time
+	for {
+		if m.lead(ctx) {
+			m.sweep(ctx)
+			if m.cfg.ReconcileInterval > 0 && time.Since(lastReconcile) >= m.cfg.ReconcileInterval {
+				lastReconcile = time.Now()
+				m.reconcile(ctx)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead renews the lease, reporting whether this replica
+// holds it.
+func (m *Manager) lead(ctx context.Context) bool {
+	leading, err := m.store.Lead(ctx, m.holder, m.cfg.LeaseTTL)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to renew the retention lease: %v", err)
+		}
+		leading = false
+	}
+	if leading {
+		leader.Set(1)
+	} else {
+		leader.Set(0)
+	}
+	return leading
+}
+
+// sweep deletes the expired objects, renewing the lease
+// between batches so that a long sweep keeps it.
+func (m *Manager) sweep(ctx context.Context) {
+	for {
+		keys, err := m.store.Due(ctx, time.Now(), batchSize)
+		if err != nil {
+			log.Printf("Failed to read expired objects: %v", err)
+			return
+		}
+		if len(keys) == 0 {
+			return
+		}
+		if err := m.bucket.DeleteObjects(ctx, m.bucketName, keys); err != nil {
+			sweepErrors.Inc()
+			log.Printf("Failed to delete expired objects: %v", err)
+			return
+		}
+		objectsDeleted.WithLabelValues("expired").Add(float64(len(keys)))
+		if err := m.store.Forget(ctx, keys...); err != nil {
+			log.Printf("Failed to forget deleted objects: %v", err)
+			return
+		}
+		if len(keys) < batchSize || !m.lead(ctx) {
+			return
+		}
+	}
+}
+
+// reconcile deletes the objects older than
+// retention.orphanAge that have no record, e.g. as their
+// record was lost or the bucket was written to directly.
+func (m *Manager) reconcile(ctx context.Context) {
+	cutoff := time.Now().Add(-m.cfg.OrphanAge)
+	var orphans int
+	err := m.bucket.ListObjects(ctx, m.bucketName, func(objects []types.Object) error {
+		var keys []string
+		for _, o := range objects {
+			if o.LastModified != nil && o.LastModified.Before(cutoff) {
+				keys = append(keys, aws.ToString(o.Key))
+			}
+		}
+		recorded, err := m.store.Recorded(ctx, keys)
+		if err != nil {
+			return err
+		}
+		var unrecorded []string
+		for i, k := range keys {
+			if !recorded[i] {
+				unrecorded = append(unrecorded, k)
+			}
+		}
+		if err := m.bucket.DeleteObjects(ctx, m.bucketName, unrecorded); err != nil {
+			return err
+		}
+		orphans += len(unrecorded)
+		objectsDeleted.WithLabelValues("orphaned").Add(float64(len(unrecorded)))
+		return nil
+	})
+	if err != nil {
+		sweepErrors.Inc()
+		log.Printf("Failed to reconcile the bucket: %v", err)
+	}
+	if orphans > 0 {
+		log.Printf("Deleted %d objects no job recorded", orphans)
+	}
+}
@@ -0,0 +1,96 @@
+package retention
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store keeps the expiry of every object of the bucket
+// and the lease of the replica sweeping them.
+type Store interface {
+	// Record sets the expiry of the object under key,
+	// replacing any earlier one.
+	Record(ctx context.Context, key string, expiry time.Time) error
+	// Due returns up to limit keys expired at now, the
+	// earliest first.
+	Due(ctx context.Context, now time.Time, limit int) ([]string, error)
+	// Expiry returns the expiry of the object under key, and
+	// false if it has no record.
+	Expiry(ctx context.Context, key string) (time.Time, bool, error)
+	// Forget removes the records of keys.
+	Forget(ctx context.Context, keys ...string) error
+	// Recorded reports which of keys have a record.
+	Recorded(ctx context.Context, keys []string) ([]bool, error)
+	// Lead acquires or renews the lease for holder, and
+	// reports whether holder holds it.
+	Lead(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+}
+
+// MemoryStore keeps the records in memory, for a single
+// metadata replica. Its records are lost on restart, and
+// their objects are left to the reconciliation.
+type MemoryStore struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{expires: make(map[string]time.Time)}
+}
+
+func (s *MemoryStore) Record(_ context.Context, key string, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expires[key] = expiry
+	return nil
+}
+
+func (s *MemoryStore) Due(_ context.Context, now time.Time, limit int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k, exp := range s.expires {
+		if !exp.After(now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return s.expires[keys[i]].Before(s.expires[keys[j]]) })
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+func (s *MemoryStore) Expiry(_ context.Context, key string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.expires[key]
+	return exp, ok, nil
+}
+
+func (s *MemoryStore) Forget(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		delete(s.expires, k)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Recorded(_ context.Context, keys []string) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]bool, len(keys))
+	for i, k := range keys {
+		_, res[i] = s.expires[k]
+	}
+	return res, nil
+}
+
+// Lead always succeeds, as the store is not shared.
+func (s *MemoryStore) Lead(context.Context, string, time.Duration) (bool, error) {
+	return true, nil
+}
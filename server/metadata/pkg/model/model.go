@@ -1,6 +1,11 @@
 package model
 
-import v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+import (
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
 
 type Metadata struct {
 	Filename       string `json:"filename"`
@@ -14,6 +19,16 @@ type Metadata struct {
 	BitRate        string `json:"bit_rate"`
 	ProbeScore     int    `json:"probe_score"`
 	Tags           Tags   `json:"tags"`
+	// SHA256 is the hex digest of the source, identifying
+	// identical uploads.
+	SHA256 string `json:"sha256,omitempty"`
+	// VideoCodec and AudioCodec are the codecs of the first
+	// video and audio streams, empty if there is none.
+	VideoCodec string `json:"video_codec,omitempty"`
+	AudioCodec string `json:"audio_codec,omitempty"`
+	// Rotation is the rotation in degrees players apply to
+	// the video stream.
+	Rotation int `json:"rotation,omitempty"`
 }
 
 type Tags struct {
@@ -26,10 +41,31 @@ type UploadURL struct {
 	JobID        int64                    // corresponds to JobId in proto
 	PresignedURL *v4.PresignedHTTPRequest // corresponds to PresignedUrl in proto
 	ObjectKey    string                   // corresponds to ObjectKey in proto
+	ExpiresAt    time.Time                // corresponds to ExpiresAt in proto
+}
+
+// DownloadURL is a presigned download of the output of a
+// job.
+type DownloadURL struct {
+	PresignedURL *v4.PresignedHTTPRequest
+	ExpiresAt    time.Time
+	// Filename is the name the file is saved as.
+	Filename string
 }
 
 type CompressionEvent struct {
 	JobID     int64    `json:"job_id"`
 	ObjectKey string   `json:"object_key"`
 	Metadata  Metadata `json:"metadata"`
+	// UserID is the user the job was submitted by.
+	UserID string `json:"user_id,omitempty"`
+	// Callback is notified of the result of the job.
+	Callback *compressionmodel.Callback `json:"callback,omitempty"`
+	JobType  compressionmodel.JobType   `json:"job_type,omitempty"`
+	// Priority is the priority the job was published with.
+	Priority compressionmodel.Priority `json:"priority,omitempty"`
+	// OutputFormat is the kind of file the job produces.
+	// Jobs published before output formats have none, and
+	// produce videos.
+	OutputFormat compressionmodel.OutputFormat `json:"output_format,omitempty"`
 }
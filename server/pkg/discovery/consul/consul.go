@@ -5,8 +5,10 @@ import (
 	"errors"
 	"ffmpeg/wrapper/pkg/discovery"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	consul "github.com/hashicorp/consul/api"
 )
@@ -29,7 +31,10 @@ func NewRegistry(addr string) (*Registry, error) {
 }
 
 // Register creates a service record in the registry.
-func (r *Registry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string) error {
+// Instances are checked with a 5 second TTL that must be
+// refreshed with ReportHealthyState, unless they are
+// registered with a gRPC health check.
+func (r *Registry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string, opts ...discovery.RegisterOption) error {
 	parts := strings.Split(hostPort, ":")
 	if len(parts) != 2 {
 		return errors.New("hostPort must be in a form of <host>:<port>, example: localhost:8081")
@@ -38,11 +43,19 @@ func (r *Registry) Register(ctx context.Context, instanceID string, serviceName
 	if err != nil {
 		return err
 	}
+	reg := discovery.NewRegistration(opts...)
 
-	// Only add "metrics" tag if the service name contains "metrics"
-	var tags []string
-	if strings.Contains(serviceName, "metrics") {
-		tags = []string{"metrics"}
+	check := &consul.AgentServiceCheck{CheckID: instanceID, TTL: "5s"}
+	if reg.GRPCHealthCheck > 0 {
+		check = &consul.AgentServiceCheck{
+			CheckID:  instanceID,
+			GRPC:     hostPort,
+			Interval: reg.GRPCHealthCheck.String(),
+			Timeout:  reg.GRPCHealthCheck.String(),
+			// Remove instances that were killed without
+			// deregistering.
+			DeregisterCriticalServiceAfter: "1m",
+		}
 	}
 
 	return r.client.Agent().ServiceRegister(&consul.AgentServiceRegistration{
@@ -50,8 +63,9 @@ func (r *Registry) Register(ctx context.Context, instanceID string, serviceName
 		ID:      instanceID,
 		Name:    serviceName,
 		Port:    port,
-		Tags:    tags,
-		Check:   &consul.AgentServiceCheck{CheckID: instanceID, TTL: "5s"},
+		Tags:    reg.Tags,
+		Meta:    reg.Meta,
+		Check:   check,
 	})
 }
 
@@ -64,21 +78,100 @@ func (r *Registry) Deregister(ctx context.Context, instanceID string, _ string)
 // ServiceAddresses returns the list of addresses of
 // active instances of the given service.
 func (r *Registry) ServiceAddresses(ctx context.Context, serviceName string) ([]string, error) {
-	entries, _, err := r.client.Health().Service(serviceName, "", true, nil)
+	instances, err := r.Instances(ctx, serviceName)
 	if err != nil {
 		return nil, err
-	} else if len(entries) == 0 {
-		return nil, discovery.ErrNotFound
 	}
-	var res []string
-	for _, e := range entries {
-		res = append(res, fmt.Sprintf("%s:%d", e.Service.Address, e.Service.Port))
+	res := make([]string, 0, len(instances))
+	for _, i := range instances {
+		res = append(res, i.HostPort)
 	}
 	return res, nil
 }
 
+// Instances returns the active instances of the given
+// service. Results are served from the agent cache, so
+// frequent calls do not each hit the Consul servers.
+func (r *Registry) Instances(ctx context.Context, serviceName string) ([]discovery.Instance, error) {
+	opts := (&consul.QueryOptions{UseCache: true, MaxAge: 5 * time.Second}).WithContext(ctx)
+	entries, _, err := r.client.Health().Service(serviceName, "", true, opts)
+	if err != nil {
+		return nil, err
+	} else if len(entries) == 0 {
+		return nil, discovery.ErrNotFound
+	}
+	return instances(entries), nil
+}
+
+// Watch sends the active instances of the given service
+// every time they change, using Consul blocking queries.
+// An empty slice is sent when no instance is active.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (<-chan []discovery.Instance, error) {
+	ch := make(chan []discovery.Instance, 1)
+	go func() {
+		defer close(ch)
+		var index uint64
+		backoff := 250 * time.Millisecond
+		for {
+			opts := (&consul.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Minute}).WithContext(ctx)
+			entries, meta, err := r.client.Health().Service(serviceName, "", true, opts)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("failed to watch service %s: %v", serviceName, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff = min(2*backoff, 30*time.Second)
+				continue
+			}
+			backoff = 250 * time.Millisecond
+
+			// The wait timed out without any change.
+			if index != 0 && meta.LastIndex == index {
+				continue
+			}
+			// Indexes may go backwards, e.g. after a snapshot
+			// restore, in which case the watch starts over.
+			if meta.LastIndex < index {
+				index = 0
+			} else {
+				index = meta.LastIndex
+			}
+
+			select {
+			case ch <- instances(entries):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
 // ReportHealthyState is a push mechanism for
 // reporting healthy state to the registry.
 func (r *Registry) ReportHealthyState(instanceID string, _ string) error {
 	return r.client.Agent().PassTTL(instanceID, "")
 }
+
+func instances(entries []*consul.ServiceEntry) []discovery.Instance {
+	res := make([]discovery.Instance, 0, len(entries))
+	for _, e := range entries {
+		addr := e.Service.Address
+		if addr == "" {
+			addr = e.Node.Address
+		}
+		res = append(res, discovery.Instance{
+			ID:          e.Service.ID,
+			ServiceName: e.Service.Service,
+			HostPort:    fmt.Sprintf("%s:%d", addr, e.Service.Port),
+			Tags:        e.Service.Tags,
+			Meta:        e.Service.Meta,
+		})
+	}
+	return res
+}
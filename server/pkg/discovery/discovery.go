@@ -9,6 +9,8 @@ import (
 
 	"math/rand"
 
+	"runtime/debug"
+
 	"time"
 )
 
@@ -17,7 +19,7 @@ type Registry interface {
 
 	// Register creates a service instance record in the
 	// registry.
-	Register(ctx context.Context, instanceID string, serviceName string, hostPort string) error
+	Register(ctx context.Context, instanceID string, serviceName string, hostPort string, opts ...RegisterOption) error
 
 	// Deregister removes a service instance record from
 	// the registry.
@@ -27,15 +29,115 @@ type Registry interface {
 	// active instances of the given service.
 	ServiceAddresses(ctx context.Context, serviceID string) ([]string, error)
 
+	// Instances returns the active instances of the given
+	// service, including their tags and metadata.
+	Instances(ctx context.Context, serviceName string) ([]Instance, error)
+
+	// Watch sends the active instances of the given service
+	// on the returned channel, first immediately and then
+	// every time they change. The channel is closed once
+	// ctx is done.
+	Watch(ctx context.Context, serviceName string) (<-chan []Instance, error)
+
 	// ReportHealthyState is a push mechanism for reporting
-	// healthy state to the registry.
+	// healthy state to the registry. It is not needed for
+	// instances registered with a gRPC health check.
 	ReportHealthyState(instanceID string, serviceName string) error
 }
 
+// Instance defines an active service instance.
+type Instance struct {
+	ID          string
+	ServiceName string
+	HostPort    string
+	Tags        []string
+	Meta        map[string]string
+}
+
+// Well-known instance metadata keys.
+const (
+	// MetaVersion is the build version of the instance.
+	MetaVersion = "version"
+	// MetaZone is the zone the instance runs in.
+	MetaZone = "zone"
+	// MetaEncoders is the comma separated list of ffmpeg
+	// encoders available to a compression instance.
+	MetaEncoders = "encoders"
+)
+
+// Registration defines the optional attributes of a
+// service instance record.
+type Registration struct {
+	Tags []string
+	Meta map[string]string
+	// GRPCHealthCheck makes the registry check the instance
+	// through the grpc.health.v1 protocol every interval,
+	// instead of expecting ReportHealthyState calls.
+	GRPCHealthCheck time.Duration
+}
+
+// RegisterOption sets an optional attribute of a service
+// instance record.
+type RegisterOption func(*Registration)
+
+// NewRegistration applies the given options to an empty
+// registration.
+func NewRegistration(opts ...RegisterOption) Registration {
+	var r Registration
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r
+}
+
+// WithTags adds tags to the instance record.
+func WithTags(tags ...string) RegisterOption {
+	return func(r *Registration) {
+		r.Tags = append(r.Tags, tags...)
+	}
+}
+
+// WithMeta adds a metadata entry to the instance record.
+// Empty values are skipped.
+func WithMeta(key string, value string) RegisterOption {
+	return func(r *Registration) {
+		if value == "" {
+			return
+		}
+		if r.Meta == nil {
+			r.Meta = map[string]string{}
+		}
+		r.Meta[key] = value
+	}
+}
+
+// WithGRPCHealthCheck makes the registry check the health
+// of the instance through grpc.health.v1 every interval.
+func WithGRPCHealthCheck(interval time.Duration) RegisterOption {
+	return func(r *Registration) {
+		r.GRPCHealthCheck = interval
+	}
+}
+
 // ErrNotFound is returned when no service addresses are
 // found.
 var ErrNotFound = errors.New("no service addresses found")
 
+// Version returns the VCS revision the binary was built
+// from, or "dev" if it is unknown.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+			return s.Value[:12]
+		}
+	}
+	return "dev"
+}
+
 // GenerateInstanceID generates a pseudo-random service
 // instance identifier, using a service name
 // suffixed by dash and a random number.
@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"ffmpeg/wrapper/pkg/discovery"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Registry defines a service registry resolving instances
+// through DNS SRV records, such as the records Kubernetes
+// publishes for the named ports of headless services.
+//
+// Instances are registered by the platform serving the
+// records, so Register, Deregister and ReportHealthyState
+// do nothing.
+type Registry struct {
+	resolver *net.Resolver
+	domain   string
+	portName string
+	interval time.Duration
+}
+
+// NewRegistry creates a new DNS SRV based service
+// registry. Service names are looked up as
+// _<portName>._tcp.<service>.<domain>, or as
+// <service>.<domain> if portName is empty. A non-empty
+// server replaces the system resolver.
+func NewRegistry(domain string, portName string, server string, interval time.Duration) *Registry {
+	resolver := net.DefaultResolver
+	if server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return &Registry{
+		resolver: resolver,
+		domain:   strings.Trim(domain, "."),
+		portName: portName,
+		interval: interval,
+	}
+}
+
+// Register does nothing, DNS records are managed by the
+// platform.
+func (r *Registry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string, opts ...discovery.RegisterOption) error {
+	return nil
+}
+
+// Deregister does nothing, DNS records are managed by the
+// platform.
+func (r *Registry) Deregister(ctx context.Context, instanceID string, serviceName string) error {
+	return nil
+}
+
+// ReportHealthyState does nothing, only ready instances
+// are published in DNS.
+func (r *Registry) ReportHealthyState(instanceID string, serviceName string) error {
+	return nil
+}
+
+// ServiceAddresses returns the list of addresses of
+// active instances of the given service.
+func (r *Registry) ServiceAddresses(ctx context.Context, serviceName string) ([]string, error) {
+	instances, err := r.Instances(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(instances))
+	for _, i := range instances {
+		res = append(res, i.HostPort)
+	}
+	return res, nil
+}
+
+// Instances returns the instances published in the SRV
+// records of the given service.
+func (r *Registry) Instances(ctx context.Context, serviceName string) ([]discovery.Instance, error) {
+	name := serviceName
+	if r.domain != "" {
+		name = serviceName + "." + r.domain
+	}
+	proto := "tcp"
+	if r.portName == "" {
+		proto = ""
+	}
+	_, srvs, err := r.resolver.LookupSRV(ctx, r.portName, proto, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound || err == nil && len(srvs) == 0 {
+		return nil, discovery.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	res := make([]discovery.Instance, 0, len(srvs))
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		res = append(res, discovery.Instance{
+			ID:          host,
+			ServiceName: serviceName,
+			HostPort:    net.JoinHostPort(host, fmt.Sprint(srv.Port)),
+		})
+	}
+	slices.SortFunc(res, func(a, b discovery.Instance) int { return strings.Compare(a.HostPort, b.HostPort) })
+	return res, nil
+}
+
+// Watch looks the given service up every refresh interval
+// and sends its instances whenever they change. Lookup
+// errors keep the last known instances.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (<-chan []discovery.Instance, error) {
+	ch := make(chan []discovery.Instance, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		var last []string
+		first := true
+		for {
+			instances, err := r.Instances(ctx, serviceName)
+			if err != nil && !errors.Is(err, discovery.ErrNotFound) {
+				log.Printf("failed to look up service %s: %v", serviceName, err)
+			} else {
+				addrs := make([]string, 0, len(instances))
+				for _, i := range instances {
+					addrs = append(addrs, i.HostPort)
+				}
+				if first || !slices.Equal(addrs, last) {
+					select {
+					case ch <- instances:
+					case <-ctx.Done():
+						return
+					}
+					first, last = false, addrs
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,205 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"ffmpeg/wrapper/pkg/discovery"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const domain = "default.svc.cluster.local"
+
+// srvRecord is an answer of the fake server.
+type srvRecord struct {
+	target string
+	port   uint16
+}
+
+// fakeServer answers SRV queries over UDP from zone. Names
+// that are absent get NXDOMAIN, names mapped to no records
+// an empty answer, and names in drop no answer at all.
+type fakeServer struct {
+	conn net.PacketConn
+	zone map[string][]srvRecord
+	drop map[string]bool
+}
+
+func startServer(t *testing.T, zone map[string][]srvRecord, drop ...string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{conn: conn, zone: zone, drop: map[string]bool{}}
+	for _, name := range drop {
+		s.drop[name] = true
+	}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return conn.LocalAddr().String()
+}
+
+func (s *fakeServer) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp, ok := s.answer(buf[:n]); ok {
+			s.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *fakeServer) answer(query []byte) ([]byte, bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, false
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, false
+	}
+	name := strings.TrimSuffix(q.Name.String(), ".")
+	if s.drop[name] {
+		return nil, false
+	}
+	records, found := s.zone[name]
+	rcode := dnsmessage.RCodeSuccess
+	if !found {
+		rcode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 h.ID,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   h.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	if q.Type == dnsmessage.TypeSRV {
+		for _, r := range records {
+			b.SRVResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 5},
+				dnsmessage.SRVResource{Priority: 0, Weight: 10, Port: r.port, Target: dnsmessage.MustNewName(r.target + ".")})
+		}
+	}
+	resp, err := b.Finish()
+	return resp, err == nil
+}
+
+func TestServiceAddresses(t *testing.T) {
+	server := startServer(t, map[string][]srvRecord{
+		"_grpc._tcp.video." + domain: {
+			{"video-1.video." + domain, 8080},
+			{"video-0.video." + domain, 8080},
+		},
+		"metadata." + domain: {
+			{"metadata-0.metadata." + domain, 8081},
+		},
+		"_grpc._tcp.compression." + domain: {},
+	}, "_grpc._tcp.gateway."+domain)
+
+	tests := []struct {
+		name     string
+		service  string
+		portName string
+		timeout  time.Duration
+		want     []string
+		wantErr  error
+		// wantFail expects an error other than ErrNotFound.
+		wantFail bool
+	}{
+		{
+			name:     "named port",
+			service:  "video",
+			portName: "grpc",
+			want:     []string{"video-0.video." + domain + ":8080", "video-1.video." + domain + ":8080"},
+		},
+		{
+			name:    "without port name",
+			service: "metadata",
+			want:    []string{"metadata-0.metadata." + domain + ":8081"},
+		},
+		{
+			name:     "empty answer",
+			service:  "compression",
+			portName: "grpc",
+			wantErr:  discovery.ErrNotFound,
+		},
+		{
+			name:     "NXDOMAIN",
+			service:  "unknown",
+			portName: "grpc",
+			wantErr:  discovery.ErrNotFound,
+		},
+		{
+			name:     "timeout",
+			service:  "gateway",
+			portName: "grpc",
+			timeout:  300 * time.Millisecond,
+			wantFail: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(domain, tt.portName, server, time.Second)
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			got, err := r.ServiceAddresses(ctx, tt.service)
+			switch {
+			case tt.wantFail:
+				if err == nil || errors.Is(err, discovery.ErrNotFound) {
+					t.Fatalf("ServiceAddresses() error = %v, want a lookup failure", err)
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("ServiceAddresses() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ServiceAddresses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterDoesNothing(t *testing.T) {
+	server := startServer(t, map[string][]srvRecord{
+		"_grpc._tcp.video." + domain: {{"video-0.video." + domain, 8080}},
+	})
+	r := NewRegistry(domain, "grpc", server, time.Second)
+	ctx := context.Background()
+	if err := r.Register(ctx, "video-1", "video", "10.0.0.9:8080"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := r.ReportHealthyState("video-1", "video"); err != nil {
+		t.Fatalf("ReportHealthyState() error = %v", err)
+	}
+	got, err := r.ServiceAddresses(ctx, "video")
+	if err != nil {
+		t.Fatalf("ServiceAddresses() error = %v", err)
+	}
+	if want := []string{"video-0.video." + domain + ":8080"}; !slices.Equal(got, want) {
+		t.Errorf("ServiceAddresses() = %v, want only the published %v", got, want)
+	}
+	if err := r.Deregister(ctx, "video-0", "video"); err != nil {
+		t.Fatalf("Deregister() error = %v", err)
+	}
+	if got, _ := r.ServiceAddresses(ctx, "video"); len(got) != 1 {
+		t.Errorf("ServiceAddresses() = %v after Deregister, want the published instance", got)
+	}
+}
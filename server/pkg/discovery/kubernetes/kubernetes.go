@@ -0,0 +1,300 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"ffmpeg/wrapper/pkg/discovery"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// ErrGone is returned when a watch starts from a resource
+// version the API server no longer has.
+var ErrGone = errors.New("resource version is gone")
+
+// Registry defines a service registry reading the
+// EndpointSlices of Kubernetes services through the API
+// server. The pod needs get, list and watch permissions on
+// endpointslices.discovery.k8s.io in the namespace.
+//
+// Endpoints are managed by Kubernetes from the pod
+// readiness, so Register, Deregister and
+// ReportHealthyState do nothing.
+type Registry struct {
+	client    kubernetes.Interface
+	namespace string
+	portName  string
+}
+
+// NewRegistry creates a Kubernetes EndpointSlice based
+// service registry. An empty apiServer selects the
+// in-cluster API server and service account, an empty
+// namespace the namespace of the pod. portName selects
+// the endpoint port of services exposing more than one.
+func NewRegistry(apiServer string, namespace string, portName string) (*Registry, error) {
+	cfg := &rest.Config{Host: apiServer}
+	if apiServer == "" {
+		var err error
+		if cfg, err = rest.InClusterConfig(); err != nil {
+			return nil, fmt.Errorf("not running in a Kubernetes cluster, set the API server address: %w", err)
+		}
+	}
+	if namespace == "" {
+		ns, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pod namespace, set it explicitly: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return newRegistry(client, namespace, portName), nil
+}
+
+func newRegistry(client kubernetes.Interface, namespace string, portName string) *Registry {
+	return &Registry{client: client, namespace: namespace, portName: portName}
+}
+
+// Register does nothing, endpoints are managed by
+// Kubernetes.
+func (r *Registry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string, opts ...discovery.RegisterOption) error {
+	return nil
+}
+
+// Deregister does nothing, endpoints are managed by
+// Kubernetes.
+func (r *Registry) Deregister(ctx context.Context, instanceID string, serviceName string) error {
+	return nil
+}
+
+// ReportHealthyState does nothing, endpoint readiness
+// follows the pod readiness probe.
+func (r *Registry) ReportHealthyState(instanceID string, serviceName string) error {
+	return nil
+}
+
+// ServiceAddresses returns the list of addresses of
+// ready endpoints of the given service.
+func (r *Registry) ServiceAddresses(ctx context.Context, serviceName string) ([]string, error) {
+	instances, err := r.Instances(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(instances))
+	for _, i := range instances {
+		res = append(res, i.HostPort)
+	}
+	return res, nil
+}
+
+// Instances returns the ready endpoints of the given
+// service.
+func (r *Registry) Instances(ctx context.Context, serviceName string) ([]discovery.Instance, error) {
+	list, err := r.list(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	instances := r.instances(serviceName, list.Items)
+	if len(instances) == 0 {
+		return nil, discovery.ErrNotFound
+	}
+	return instances, nil
+}
+
+// Watch sends the ready endpoints of the given service
+// every time its EndpointSlices change. The slices are
+// listed once and then watched, and listed again whenever
+// the watch breaks.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (<-chan []discovery.Instance, error) {
+	ch := make(chan []discovery.Instance, 1)
+	go func() {
+		defer close(ch)
+		backoff := 250 * time.Millisecond
+		var last []discovery.Instance
+		first := true
+		send := func(byName map[string]discoveryv1.EndpointSlice) bool {
+			items := make([]discoveryv1.EndpointSlice, 0, len(byName))
+			for _, s := range byName {
+				items = append(items, s)
+			}
+			instances := r.instances(serviceName, items)
+			if !first && sameAddresses(instances, last) {
+				return true
+			}
+			first, last = false, instances
+			select {
+			case ch <- instances:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for ctx.Err() == nil {
+			err := r.listAndWatch(ctx, serviceName, send)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && !errors.Is(err, ErrGone) {
+				log.Printf("failed to watch endpoints of service %s: %v", serviceName, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				backoff = min(2*backoff, 30*time.Second)
+				continue
+			}
+			backoff = 250 * time.Millisecond
+		}
+	}()
+	return ch, nil
+}
+
+// listAndWatch lists the EndpointSlices of the service
+// and applies watch events to them until the watch ends.
+func (r *Registry) listAndWatch(ctx context.Context, serviceName string, send func(map[string]discoveryv1.EndpointSlice) bool) error {
+	list, err := r.list(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	byName := map[string]discoveryv1.EndpointSlice{}
+	for _, s := range list.Items {
+		byName[s.Name] = s
+	}
+	if !send(byName) {
+		return nil
+	}
+
+	timeout := int64(300)
+	w, err := r.client.DiscoveryV1().EndpointSlices(r.namespace).Watch(ctx, metav1.ListOptions{
+		LabelSelector:       selector(serviceName),
+		ResourceVersion:     list.ResourceVersion,
+		AllowWatchBookmarks: true,
+		TimeoutSeconds:      &timeout,
+	})
+	if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+		return ErrGone
+	} else if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	for {
+		var ev watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok = <-w.ResultChan():
+		}
+		if !ok {
+			return nil
+		}
+		switch ev.Type {
+		case watch.Added, watch.Modified:
+			s, ok := ev.Object.(*discoveryv1.EndpointSlice)
+			if !ok {
+				return fmt.Errorf("unexpected watch object %T", ev.Object)
+			}
+			byName[s.Name] = *s
+		case watch.Deleted:
+			s, ok := ev.Object.(*discoveryv1.EndpointSlice)
+			if !ok {
+				return fmt.Errorf("unexpected watch object %T", ev.Object)
+			}
+			delete(byName, s.Name)
+		case watch.Error:
+			err := apierrors.FromObject(ev.Object)
+			if apierrors.IsGone(err) || apierrors.IsResourceExpired(err) {
+				return ErrGone
+			}
+			return fmt.Errorf("watch error: %w", err)
+		default:
+			// BOOKMARK events only advance the resource
+			// version.
+			continue
+		}
+		if !send(byName) {
+			return nil
+		}
+	}
+}
+
+func (r *Registry) list(ctx context.Context, serviceName string) (*discoveryv1.EndpointSliceList, error) {
+	list, err := r.client.DiscoveryV1().EndpointSlices(r.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector(serviceName)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list EndpointSlices: %w", err)
+	}
+	return list, nil
+}
+
+func selector(serviceName string) string {
+	return discoveryv1.LabelServiceName + "=" + serviceName
+}
+
+// instances returns the ready endpoints of the given
+// slices, on the port selected by the registry port name.
+// Terminating endpoints are skipped even while they still
+// serve.
+func (r *Registry) instances(serviceName string, items []discoveryv1.EndpointSlice) []discovery.Instance {
+	var res []discovery.Instance
+	for _, s := range items {
+		port, ok := r.port(s.Ports)
+		if !ok {
+			continue
+		}
+		for _, e := range s.Endpoints {
+			if e.Conditions.Ready != nil && !*e.Conditions.Ready ||
+				e.Conditions.Terminating != nil && *e.Conditions.Terminating {
+				continue
+			}
+			for _, addr := range e.Addresses {
+				i := discovery.Instance{
+					ID:          addr,
+					ServiceName: serviceName,
+					HostPort:    net.JoinHostPort(addr, strconv.Itoa(int(port))),
+				}
+				if e.TargetRef != nil && e.TargetRef.Name != "" {
+					i.ID = e.TargetRef.Name
+				}
+				if e.Zone != nil && *e.Zone != "" {
+					i.Tags = []string{"zone:" + *e.Zone}
+					i.Meta = map[string]string{discovery.MetaZone: *e.Zone}
+				}
+				res = append(res, i)
+			}
+		}
+	}
+	slices.SortFunc(res, func(a, b discovery.Instance) int { return strings.Compare(a.HostPort, b.HostPort) })
+	return res
+}
+
+func (r *Registry) port(ports []discoveryv1.EndpointPort) (int32, bool) {
+	for _, p := range ports {
+		if p.Port != nil && (len(ports) == 1 || p.Name != nil && *p.Name == r.portName) {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
+
+func sameAddresses(a, b []discovery.Instance) bool {
+	return slices.EqualFunc(a, b, func(x, y discovery.Instance) bool { return x.HostPort == y.HostPort })
+}
@@ -0,0 +1,233 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"ffmpeg/wrapper/pkg/discovery"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const namespace = "default"
+
+func ptr[T any](v T) *T { return &v }
+
+func slice(name string, service string, ports []discoveryv1.EndpointPort, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       ports,
+		Endpoints:   endpoints,
+	}
+}
+
+func endpoint(addr string, ready *bool, terminating *bool) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{
+		Addresses:  []string{addr},
+		Conditions: discoveryv1.EndpointConditions{Ready: ready, Terminating: terminating},
+	}
+}
+
+// twoPorts exposes gRPC and metrics, so the registry needs
+// a port name to pick one.
+var twoPorts = []discoveryv1.EndpointPort{
+	{Name: ptr("grpc"), Port: ptr[int32](8080)},
+	{Name: ptr("metrics"), Port: ptr[int32](9090)},
+}
+
+func fixtures() *fake.Clientset {
+	ready := endpoint("10.0.0.1", ptr(true), nil)
+	ready.TargetRef = &corev1.ObjectReference{Name: "video-0"}
+	ready.Zone = ptr("eu-1a")
+	return fake.NewClientset(
+		slice("video-a", "video", twoPorts,
+			ready,
+			endpoint("10.0.0.2", ptr(false), nil),
+			// Terminating pods stay ready while they drain.
+			endpoint("10.0.0.3", ptr(true), ptr(true)),
+			// Unknown readiness counts as ready.
+			endpoint("10.0.0.4", nil, nil),
+		),
+		slice("video-b", "video", []discoveryv1.EndpointPort{{Port: ptr[int32](7000)}},
+			endpoint("10.0.1.1", ptr(true), ptr(false)),
+		),
+		slice("metadata-a", "metadata", twoPorts,
+			endpoint("10.0.2.1", ptr(false), nil),
+			endpoint("10.0.2.2", ptr(false), ptr(true)),
+		),
+		slice("gateway-a", "gateway", twoPorts),
+	)
+}
+
+func TestServiceAddresses(t *testing.T) {
+	tests := []struct {
+		name     string
+		service  string
+		portName string
+		want     []string
+		wantErr  error
+	}{
+		{
+			name:     "ready endpoints on the named port",
+			service:  "video",
+			portName: "grpc",
+			want:     []string{"10.0.0.1:8080", "10.0.0.4:8080", "10.0.1.1:7000"},
+		},
+		{
+			name:     "other named port",
+			service:  "video",
+			portName: "metrics",
+			want:     []string{"10.0.0.1:9090", "10.0.0.4:9090", "10.0.1.1:7000"},
+		},
+		{
+			name:     "unknown port name keeps single port slices",
+			service:  "video",
+			portName: "http",
+			want:     []string{"10.0.1.1:7000"},
+		},
+		{
+			name:     "only not ready and terminating endpoints",
+			service:  "metadata",
+			portName: "grpc",
+			wantErr:  discovery.ErrNotFound,
+		},
+		{
+			name:     "slice without endpoints",
+			service:  "gateway",
+			portName: "grpc",
+			wantErr:  discovery.ErrNotFound,
+		},
+		{
+			name:     "unknown service",
+			service:  "compression",
+			portName: "grpc",
+			wantErr:  discovery.ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRegistry(fixtures(), namespace, tt.portName)
+			got, err := r.ServiceAddresses(context.Background(), tt.service)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ServiceAddresses() error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ServiceAddresses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstances(t *testing.T) {
+	r := newRegistry(fixtures(), namespace, "grpc")
+	got, err := r.Instances(context.Background(), "video")
+	if err != nil {
+		t.Fatalf("Instances() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("Instances() = %v, want 3 instances", got)
+	}
+	first := got[0]
+	if first.ID != "video-0" || first.ServiceName != "video" || first.Meta[discovery.MetaZone] != "eu-1a" ||
+		!slices.Equal(first.Tags, []string{"zone:eu-1a"}) {
+		t.Errorf("Instances()[0] = %+v, want pod video-0 in zone eu-1a", first)
+	}
+	if got[1].ID != "10.0.0.4" {
+		t.Errorf("Instances()[1].ID = %q, want the address without a target", got[1].ID)
+	}
+}
+
+func TestRegisterDoesNothing(t *testing.T) {
+	client := fixtures()
+	r := newRegistry(client, namespace, "grpc")
+	ctx := context.Background()
+	if err := r.Register(ctx, "video-1", "video", "10.0.9.9:8080"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := r.ReportHealthyState("video-1", "video"); err != nil {
+		t.Fatalf("ReportHealthyState() error = %v", err)
+	}
+	if err := r.Deregister(ctx, "video-1", "video"); err != nil {
+		t.Fatalf("Deregister() error = %v", err)
+	}
+	if n := len(client.Actions()); n != 0 {
+		t.Errorf("registration made %d API calls, want none", n)
+	}
+	got, _ := r.ServiceAddresses(ctx, "video")
+	if slices.Contains(got, "10.0.9.9:8080") {
+		t.Errorf("ServiceAddresses() = %v, registered instance should not appear", got)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	client := fixtures()
+	r := newRegistry(client, namespace, "grpc")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch, err := r.Watch(ctx, "video")
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	want := func(addrs ...string) {
+		t.Helper()
+		select {
+		case instances := <-ch:
+			var got []string
+			for _, i := range instances {
+				got = append(got, i.HostPort)
+			}
+			if !slices.Equal(got, addrs) {
+				t.Fatalf("Watch() sent %v, want %v", got, addrs)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Watch() sent nothing, want %v", addrs)
+		}
+	}
+	want("10.0.0.1:8080", "10.0.0.4:8080", "10.0.1.1:7000")
+	waitForWatch(t, ctx, client)
+
+	endpointSlices := client.DiscoveryV1().EndpointSlices(namespace)
+	// The not ready endpoint of video-a becomes ready.
+	a, err := endpointSlices.Get(ctx, "video-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Endpoints[1].Conditions.Ready = ptr(true)
+	if _, err := endpointSlices.Update(ctx, a, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want("10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.4:8080", "10.0.1.1:7000")
+
+	if err := endpointSlices.Delete(ctx, "video-b", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	want("10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.4:8080")
+}
+
+// waitForWatch waits until the registry watches the slices,
+// as the fake clientset drops the changes made before.
+func waitForWatch(t *testing.T, ctx context.Context, client *fake.Clientset) {
+	t.Helper()
+	for {
+		for _, a := range client.Actions() {
+			if a.GetVerb() == "watch" {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("registry did not start watching")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package static
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type adminInstance struct {
+	ID          string            `json:"id"`
+	HostPort    string            `json:"hostPort"`
+	Active      bool              `json:"active"`
+	LastActive  time.Time         `json:"lastActive"`
+	Tags        []string          `json:"tags,omitempty"`
+	Meta        map[string]string `json:"meta,omitempty"`
+	HealthCheck string            `json:"healthCheck"`
+}
+
+// Handler returns an HTTP handler listing every registered
+// instance as JSON, grouped by service, including inactive
+// instances that are not evicted yet. The service query
+// parameter limits the listing to one service.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		only := req.URL.Query().Get("service")
+		services := map[string][]adminInstance{}
+		err := r.view(func() {
+			now := time.Now()
+			for serviceName, instances := range r.serviceAddrs {
+				if only != "" && serviceName != only {
+					continue
+				}
+				for instanceID, i := range instances {
+					check := "ttl " + r.ttl.String()
+					if i.GRPCHealthCheck > 0 {
+						check = "grpc every " + i.GRPCHealthCheck.String()
+					}
+					services[serviceName] = append(services[serviceName], adminInstance{
+						ID:          instanceID,
+						HostPort:    i.HostPort,
+						Active:      now.Sub(i.LastActive) <= r.instanceTTL(i),
+						LastActive:  i.LastActive,
+						Tags:        i.Tags,
+						Meta:        i.Meta,
+						HealthCheck: check,
+					})
+				}
+				slices.SortFunc(services[serviceName], func(a, b adminInstance) int { return strings.Compare(a.ID, b.ID) })
+			}
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"services": services})
+	})
+}
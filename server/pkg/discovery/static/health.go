@@ -0,0 +1,97 @@
+package static
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type healthTarget struct {
+	serviceName string
+	instanceID  string
+	hostPort    string
+	timeout     time.Duration
+}
+
+// checkHealth checks the instances registered with a gRPC
+// health check whose interval has passed, and marks the
+// serving ones active.
+func (r *Registry) checkHealth(ctx context.Context) {
+	var due []healthTarget
+	now := time.Now()
+	err := r.view(func() {
+		for serviceName, instances := range r.serviceAddrs {
+			for instanceID, i := range instances {
+				if i.GRPCHealthCheck > 0 && now.Sub(r.lastChecked[instanceID]) >= i.GRPCHealthCheck {
+					r.lastChecked[instanceID] = now
+					due = append(due, healthTarget{serviceName, instanceID, i.HostPort, i.GRPCHealthCheck})
+				}
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("failed to read instances to check: %v", err)
+		return
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		healthy []healthTarget
+	)
+	for _, t := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := checkGRPC(ctx, t.hostPort, t.timeout); err != nil {
+				log.Printf("Instance %s of service %s is not healthy: %v", t.instanceID, t.serviceName, err)
+				return
+			}
+			mu.Lock()
+			healthy = append(healthy, t)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(healthy) == 0 {
+		return
+	}
+
+	err = r.update(func() error {
+		for _, t := range healthy {
+			if i, ok := r.serviceAddrs[t.serviceName][t.instanceID]; ok {
+				i.LastActive = time.Now()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to update instance health: %v", err)
+	}
+}
+
+// checkGRPC returns nil if the server at hostPort reports
+// itself as serving through grpc.health.v1.
+func checkGRPC(ctx context.Context, hostPort string, timeout time.Duration) error {
+	conn, err := grpc.NewClient(hostPort, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
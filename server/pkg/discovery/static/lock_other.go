@@ -0,0 +1,10 @@
+//go:build !unix
+
+package static
+
+// lockFile does not lock anything on platforms without
+// flock. A registry file is then only safe to share if
+// processes do not update it at the same time.
+func lockFile(path string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
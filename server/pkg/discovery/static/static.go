@@ -2,81 +2,413 @@ package static
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
 	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"ffmpeg/wrapper/pkg/discovery"
 )
 
-// Registry defines an in-memory service regisry.
-// Note: this registry does not perform health monitoring of active instances.
+// DefaultTTL is how long an instance stays active without
+// reporting a healthy state, unless set with WithTTL.
+const DefaultTTL = 5 * time.Second
+
+// DefaultEvictAfter is how long an inactive instance is
+// kept before it is removed, unless set with WithEvictAfter.
+const DefaultEvictAfter = time.Minute
+
+// Registry defines an in-process service registry, meant
+// for local development and tests.
+//
+// Instances are active while they report a healthy state
+// within the TTL. Instances registered with a gRPC health
+// check are checked through grpc.health.v1 by Run instead.
+// Run also evicts instances that stayed inactive for too
+// long, and notifies watchers of instances going inactive.
+//
+// With a file, the registry keeps its state in a JSON file
+// shared by every process using it, so that several local
+// services can discover each other.
 type Registry struct {
-	sync.RWMutex
+	sync.Mutex
 	serviceAddrs map[string]map[string]*serviceInstance
+	ttl          time.Duration
+	evictAfter   time.Duration
+	file         string
+
+	// active holds the last active addresses of each
+	// service, to notify watchers when they change.
+	active   map[string]string
+	watchers map[string]map[chan struct{}]struct{}
+	// lastChecked holds the time of the last gRPC health
+	// check of each instance.
+	lastChecked map[string]time.Time
 }
 
 type serviceInstance struct {
-	hostPort   string
-	lastActive time.Time
+	HostPort        string            `json:"hostPort"`
+	LastActive      time.Time         `json:"lastActive"`
+	Tags            []string          `json:"tags,omitempty"`
+	Meta            map[string]string `json:"meta,omitempty"`
+	GRPCHealthCheck time.Duration     `json:"grpcHealthCheck,omitempty"`
+}
+
+// Option configures a Registry.
+type Option func(*Registry)
+
+// WithTTL sets how long an instance stays active without
+// reporting a healthy state.
+func WithTTL(ttl time.Duration) Option {
+	return func(r *Registry) {
+		r.ttl = ttl
+	}
+}
+
+// WithEvictAfter sets how long an inactive instance is
+// kept before Run removes it.
+func WithEvictAfter(d time.Duration) Option {
+	return func(r *Registry) {
+		r.evictAfter = d
+	}
+}
+
+// WithFile keeps the registry state in the given JSON
+// file, shared with other processes using the same file.
+func WithFile(path string) Option {
+	return func(r *Registry) {
+		r.file = path
+	}
 }
 
 // NewRegistry creates a new in-memory service registry instance.
-func NewRegistry() *Registry {
-	return &Registry{serviceAddrs: map[string]map[string]*serviceInstance{}}
+func NewRegistry(opts ...Option) *Registry {
+	r := &Registry{
+		serviceAddrs: map[string]map[string]*serviceInstance{},
+		ttl:          DefaultTTL,
+		evictAfter:   DefaultEvictAfter,
+		active:       map[string]string{},
+		watchers:     map[string]map[chan struct{}]struct{}{},
+		lastChecked:  map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // Register creates a service record in the registry.
-func (r *Registry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string) error {
+func (r *Registry) Register(ctx context.Context, instanceID string, serviceName string, hostPort string, opts ...discovery.RegisterOption) error {
+	reg := discovery.NewRegistration(opts...)
+	return r.update(func() error {
+		if _, ok := r.serviceAddrs[serviceName]; !ok {
+			r.serviceAddrs[serviceName] = map[string]*serviceInstance{}
+		}
+		r.serviceAddrs[serviceName][instanceID] = &serviceInstance{
+			HostPort:        hostPort,
+			LastActive:      time.Now(),
+			Tags:            reg.Tags,
+			Meta:            reg.Meta,
+			GRPCHealthCheck: reg.GRPCHealthCheck,
+		}
+		return nil
+	})
+}
+
+// Deregister removes a service record from the registry.
+func (r *Registry) Deregister(ctx context.Context, instanceID string, serviceName string) error {
+	return r.update(func() error {
+		if _, ok := r.serviceAddrs[serviceName]; !ok {
+			return nil
+		}
+		delete(r.serviceAddrs[serviceName], instanceID)
+		if len(r.serviceAddrs[serviceName]) == 0 {
+			delete(r.serviceAddrs, serviceName)
+		}
+		return nil
+	})
+}
+
+// ReportHealthyState is a push mechanism for reporting healthy state to the registry.
+func (r *Registry) ReportHealthyState(instanceID string, serviceName string) error {
+	return r.update(func() error {
+		if _, ok := r.serviceAddrs[serviceName]; !ok {
+			return errors.New("instance " + instanceID + " of service " + serviceName + " is not registered yet")
+		}
+		if _, ok := r.serviceAddrs[serviceName][instanceID]; !ok {
+			return errors.New("service instance is not registered yet")
+		}
+		r.serviceAddrs[serviceName][instanceID].LastActive = time.Now()
+		return nil
+	})
+}
+
+// ServiceAddresses returns the list of addresses of active instances of the given service.
+func (r *Registry) ServiceAddresses(ctx context.Context, serviceName string) ([]string, error) {
+	instances, err := r.Instances(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(instances))
+	for _, i := range instances {
+		res = append(res, i.HostPort)
+	}
+	return res, nil
+}
+
+// Instances returns the active instances of the given service.
+func (r *Registry) Instances(ctx context.Context, serviceName string) ([]discovery.Instance, error) {
+	var res []discovery.Instance
+	err := r.view(func() {
+		res = r.activeInstances(serviceName, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, discovery.ErrNotFound
+	}
+	return res, nil
+}
+
+// Watch sends the active instances of the given service
+// on the returned channel, first immediately and then every
+// time they change. Instances going inactive without
+// deregistering, and changes made by other processes
+// sharing the registry file, are only noticed while Run is
+// running.
+func (r *Registry) Watch(ctx context.Context, serviceName string) (<-chan []discovery.Instance, error) {
+	notify := make(chan struct{}, 1)
+	notify <- struct{}{}
 	r.Lock()
-	defer r.Unlock()
-	if _, ok := r.serviceAddrs[serviceName]; !ok {
-		r.serviceAddrs[serviceName] = map[string]*serviceInstance{}
+	if _, ok := r.watchers[serviceName]; !ok {
+		r.watchers[serviceName] = map[chan struct{}]struct{}{}
+	}
+	r.watchers[serviceName][notify] = struct{}{}
+	r.Unlock()
+
+	ch := make(chan []discovery.Instance, 1)
+	go func() {
+		defer close(ch)
+		defer func() {
+			r.Lock()
+			delete(r.watchers[serviceName], notify)
+			r.Unlock()
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-notify:
+			}
+			instances, err := r.Instances(ctx, serviceName)
+			if err != nil && !errors.Is(err, discovery.ErrNotFound) {
+				log.Printf("failed to read instances of service %s: %v", serviceName, err)
+				continue
+			}
+			select {
+			case ch <- instances:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Run checks instances registered with a gRPC health
+// check, evicts instances that stayed inactive for longer
+// than the eviction delay and notifies watchers of changes,
+// until ctx is done.
+func (r *Registry) Run(ctx context.Context) error {
+	ticker := time.NewTicker(min(r.ttl/2, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		r.checkHealth(ctx)
+		if err := r.update(r.evict); err != nil {
+			log.Printf("failed to evict inactive instances: %v", err)
+		}
+	}
+}
+
+// evict removes the instances that stayed inactive for
+// longer than the eviction delay.
+func (r *Registry) evict() error {
+	now := time.Now()
+	for serviceName, instances := range r.serviceAddrs {
+		for instanceID, i := range instances {
+			if now.Sub(i.LastActive) > r.instanceTTL(i)+r.evictAfter {
+				log.Println("Evicting instance " + instanceID + " of service " + serviceName)
+				delete(instances, instanceID)
+				delete(r.lastChecked, instanceID)
+			}
+		}
+		if len(instances) == 0 {
+			delete(r.serviceAddrs, serviceName)
+		}
 	}
-	r.serviceAddrs[serviceName][instanceID] = &serviceInstance{hostPort: hostPort, lastActive: time.Now()}
 	return nil
 }
 
-// Deregister removes a service record from the registry.
-func (r *Registry) Deregister(ctx context.Context, instanceID string, serviceName string) error {
+// instanceTTL returns how long the instance stays active
+// after it was last seen healthy. Instances checked through
+// gRPC may miss up to two checks.
+func (r *Registry) instanceTTL(i *serviceInstance) time.Duration {
+	if i.GRPCHealthCheck > 0 {
+		return 3 * i.GRPCHealthCheck
+	}
+	return r.ttl
+}
+
+func (r *Registry) activeInstances(serviceName string, now time.Time) []discovery.Instance {
+	var res []discovery.Instance
+	for instanceID, i := range r.serviceAddrs[serviceName] {
+		if now.Sub(i.LastActive) > r.instanceTTL(i) {
+			continue
+		}
+		res = append(res, discovery.Instance{
+			ID:          instanceID,
+			ServiceName: serviceName,
+			HostPort:    i.HostPort,
+			Tags:        i.Tags,
+			Meta:        i.Meta,
+		})
+	}
+	slices.SortFunc(res, func(a, b discovery.Instance) int { return strings.Compare(a.HostPort, b.HostPort) })
+	return res
+}
+
+// update runs fn on the registry state, saving the state to
+// the registry file if there is one, and notifies watchers
+// of the services whose active instances changed.
+func (r *Registry) update(fn func() error) error {
 	r.Lock()
 	defer r.Unlock()
-	if _, ok := r.serviceAddrs[serviceName]; !ok {
-		return nil
+	if r.file != "" {
+		unlock, err := lockFile(r.file+".lock", true)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if err := r.load(); err != nil {
+			return err
+		}
+	}
+	if err := fn(); err != nil {
+		return err
 	}
-	delete(r.serviceAddrs[serviceName], instanceID)
+	if r.file != "" {
+		if err := r.save(); err != nil {
+			return err
+		}
+	}
+	r.notify()
 	return nil
 }
 
-// ReportHealthyState is a push mechanism for reporting healthy state to the registry.
-func (r *Registry) ReportHealthyState(instanceID string, serviceName string) error {
+// view runs fn on the registry state, reloading it from the
+// registry file first if there is one.
+func (r *Registry) view(fn func()) error {
 	r.Lock()
 	defer r.Unlock()
-	if _, ok := r.serviceAddrs[serviceName]; !ok {
-		return errors.New("instance " + instanceID + " of service " + serviceName + " is not registered yet")
-	}
-	if _, ok := r.serviceAddrs[serviceName][instanceID]; !ok {
-		return errors.New("service instance is not registered yet")
+	if r.file != "" {
+		unlock, err := lockFile(r.file+".lock", false)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if err := r.load(); err != nil {
+			return err
+		}
+		r.notify()
 	}
-	r.serviceAddrs[serviceName][instanceID].lastActive = time.Now()
+	fn()
 	return nil
 }
 
-// ServiceAddresses returns the list of addresses of active instances of the given service.
-func (r *Registry) ServiceAddresses(ctx context.Context, serviceName string) ([]string, error) {
-	r.RLock()
-	defer r.RUnlock()
-	if len(r.serviceAddrs[serviceName]) == 0 {
-		return nil, discovery.ErrNotFound
+// notify wakes the watchers of every service whose active
+// addresses changed since the last notification. It must
+// be called with the registry locked.
+func (r *Registry) notify() {
+	now := time.Now()
+	services := map[string]struct{}{}
+	for s := range r.serviceAddrs {
+		services[s] = struct{}{}
 	}
-	var res []string
-	for instanceID, i := range r.serviceAddrs[serviceName] {
-		if i.lastActive.Before(time.Now().Add(-5 * time.Second)) {
-			log.Println("Instance " + instanceID + " of service " + serviceName + " is not active, skipping")
+	for s := range r.active {
+		services[s] = struct{}{}
+	}
+	for s := range services {
+		var addrs []string
+		for _, i := range r.activeInstances(s, now) {
+			addrs = append(addrs, i.HostPort)
+		}
+		key := strings.Join(addrs, ",")
+		if key == r.active[s] {
 			continue
 		}
-		res = append(res, i.hostPort)
+		if key == "" {
+			delete(r.active, s)
+		} else {
+			r.active[s] = key
+		}
+		for w := range r.watchers[s] {
+			select {
+			case w <- struct{}{}:
+			default:
+			}
+		}
 	}
-	return res, nil
+}
+
+func (r *Registry) load() error {
+	b, err := os.ReadFile(r.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		r.serviceAddrs = map[string]map[string]*serviceInstance{}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read registry file: %w", err)
+	}
+	state := map[string]map[string]*serviceInstance{}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &state); err != nil {
+			return fmt.Errorf("failed to parse registry file: %w", err)
+		}
+	}
+	r.serviceAddrs = state
+	return nil
+}
+
+// save writes the registry file atomically, so that other
+// processes never read a partial state.
+func (r *Registry) save() error {
+	b, err := json.MarshalIndent(r.serviceAddrs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.file), filepath.Base(r.file)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write registry file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write registry file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write registry file: %w", err)
+	}
+	return os.Rename(tmp.Name(), r.file)
 }
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// KafkaHeaderCarrier adapts the headers of a Kafka message
+// to a propagation.TextMapCarrier.
+type KafkaHeaderCarrier struct {
+	msg *kafka.Message
+}
+
+var _ propagation.TextMapCarrier = KafkaHeaderCarrier{}
+
+// NewKafkaHeaderCarrier returns a carrier reading and
+// writing the headers of msg.
+func NewKafkaHeaderCarrier(msg *kafka.Message) KafkaHeaderCarrier {
+	return KafkaHeaderCarrier{msg: msg}
+}
+
+// Get returns the value of the header with the given key.
+func (c KafkaHeaderCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of the header with the given key.
+func (c KafkaHeaderCarrier) Set(key string, value string) {
+	for i, h := range c.msg.Headers {
+		if h.Key == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+// Keys returns the keys of all headers.
+func (c KafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// InjectKafka writes the trace context of ctx into the
+// headers of msg using the global propagator.
+func InjectKafka(ctx context.Context, msg *kafka.Message) {
+	otel.GetTextMapPropagator().Inject(ctx, NewKafkaHeaderCarrier(msg))
+}
+
+// ExtractKafka returns a copy of ctx carrying the trace
+// context found in the headers of msg.
+func ExtractKafka(ctx context.Context, msg *kafka.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, NewKafkaHeaderCarrier(msg))
+}
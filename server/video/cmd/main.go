@@ -2,13 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
 	"ffmpeg/wrapper/gen"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/discoveryutil"
+	"ffmpeg/wrapper/internal/grpcutil"
+	"ffmpeg/wrapper/internal/lifecycle"
+	"ffmpeg/wrapper/internal/metrics"
+	"ffmpeg/wrapper/internal/scheduling"
+	"ffmpeg/wrapper/internal/user"
 	"ffmpeg/wrapper/pkg/discovery"
-	"ffmpeg/wrapper/pkg/discovery/consul"
 	"ffmpeg/wrapper/pkg/discovery/tracing"
 	"ffmpeg/wrapper/video/internal/controller/video"
 	compressiongateway "ffmpeg/wrapper/video/internal/gateway/compression/grpc"
 	metadatagateway "ffmpeg/wrapper/video/internal/gateway/metadata/grpc"
+	"ffmpeg/wrapper/video/internal/webhook"
 	"fmt"
 	"net"
 	"os"
@@ -18,13 +27,15 @@ import (
 
 	"log"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
-	"gopkg.in/yaml.v3"
 )
 
 const serviceName = "video"
@@ -33,64 +44,198 @@ func main() {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
-	f, err := os.Open("../configs/default.yaml")
-	if err != nil {
-		logger.Fatal("Failed to open configuration file", zap.Error(err))
-	}
-	var cfg configuration
-	if err := yaml.NewDecoder(f).Decode(&cfg); err != nil {
-		logger.Fatal("Failed to decode configuration file", zap.Error(err))
+	cfg, err := config.Load(serviceName, os.Args[1:], config.RequireKafka, config.RequireMetrics, config.RequireWebhooks)
+	if errors.Is(err, config.ErrConfigPrinted) {
+		return
+	} else if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 	port := cfg.API.Port
 	logger.Info("Starting the video service", zap.Int("port", port))
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	lc := lifecycle.New(logger, cfg.Limits.ShutdownTimeout)
+	ctx := lc.Context()
 
 	tp, err := tracing.NewJaegerProvider(ctx, cfg.Jaeger.URL, serviceName)
 	if err != nil {
 		logger.Fatal("Failed to initialize Jaeger provider", zap.Error(err))
 	}
-	defer func() {
-		if err := tp.Shutdown(ctx); err != nil {
-			log.Fatal("Failed to shut down Jaeger prodiver", zap.Error(err))
-		}
-	}()
+	lc.OnShutdown("tracing", 0, tp.Shutdown)
 	otel.SetTracerProvider(tp)
 	otel.SetTextMapPropagator(propagation.TraceContext{})
 
-	registry, err := consul.NewRegistry(cfg.ServiceDiscovery.Consul.Address)
+	registry, err := discoveryutil.NewRegistry(lc, cfg.ServiceDiscovery)
 	if err != nil {
 		panic(err)
 	}
 
 	instanceID := discovery.GenerateInstanceID(serviceName)
-	if err := registry.Register(ctx, instanceID, serviceName, fmt.Sprintf("video:%d", port)); err != nil {
+	metricsInstanceID := discovery.GenerateInstanceID(serviceName + "-metrics")
+	registerOpts := cfg.ServiceDiscovery.RegisterOptions()
+	if err := registry.Register(ctx, instanceID, serviceName, cfg.ServiceDiscovery.HostPort("video", port),
+		append(registerOpts, discovery.WithGRPCHealthCheck(cfg.ServiceDiscovery.HealthCheckInterval))...); err != nil {
 		panic(err)
 	}
-	go func() {
+	if err := registry.Register(ctx, metricsInstanceID, serviceName+"-metrics", cfg.ServiceDiscovery.HostPort("video", cfg.Prometheus.MetricsPort),
+		append(registerOpts, discovery.WithTags("metrics"))...); err != nil {
+		panic(err)
+	}
+	lc.OnShutdown("service discovery", 0, func(ctx context.Context) error {
+		return errors.Join(
+			registry.Deregister(ctx, instanceID, serviceName),
+			registry.Deregister(ctx, metricsInstanceID, serviceName+"-metrics"),
+		)
+	})
+	lc.Go("health reporting", 0, func(ctx context.Context) error {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
 		for {
-			if err := registry.ReportHealthyState(instanceID, serviceName); err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+			if err := registry.ReportHealthyState(metricsInstanceID, serviceName+"-metrics"); err != nil {
 				logger.Error("Failed to report healthy state", zap.Error(err))
 			}
-			time.Sleep(1 * time.Second)
 		}
-	}()
-	defer registry.Deregister(ctx, instanceID, serviceName)
+	})
+
+	pool, err := grpcutil.NewPool(registry, cfg.GRPC.Client)
+	if err != nil {
+		logger.Fatal("Failed to create connection pool", zap.Error(err))
+	}
+	lc.OnShutdown("grpc connection pool", 0, func(context.Context) error { return pool.Close() })
+	metadataConn, err := pool.Conn("metadata")
+	if err != nil {
+		logger.Fatal("Failed to connect to MetadataService", zap.Error(err))
+	}
+	compressionConn, err := pool.Conn("compression")
+	if err != nil {
+		logger.Fatal("Failed to connect to CompressionService", zap.Error(err))
+	}
+
+	metadataGateway := metadatagateway.New(gen.NewMetadataServiceClient(metadataConn))
+	compressionGateway := compressiongateway.New(gen.NewCompressionServiceClient(compressionConn))
+
+	// Every replica reads all results, so that any of them
+	// can answer status requests.
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   cfg.Kafka.Brokers,
+		Topic:     cfg.Kafka.Topics.Results,
+		Partition: 0,
+		MaxBytes:  10e6,
+	})
+	lc.OnShutdown("kafka reader", 0, func(context.Context) error { return reader.Close() })
+
+	// Every replica also reads all jobs, to report their
+	// queue position.
+	var queueReaders []*kafka.Reader
+	seen := map[string]bool{}
+	for _, p := range compressionmodel.Priorities {
+		topic := scheduling.Topic(cfg.Kafka.Topics, p)
+		if seen[topic] {
+			continue
+		}
+		seen[topic] = true
+		queueReader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   cfg.Kafka.Brokers,
+			Topic:     topic,
+			Partition: 0,
+			MaxBytes:  10e6,
+		})
+		lc.OnShutdown("kafka queue reader "+topic, 0, func(context.Context) error { return queueReader.Close() })
+		queueReaders = append(queueReaders, queueReader)
+	}
+	queue := video.NewQueue(queueReaders, cfg.Scheduling.Weights)
+	lc.Go("kafka queue consumer", 0, queue.Consume)
+
+	results := video.NewResults(reader, queue, cfg.Limits.StatusPollTimeout)
+	lc.Go("kafka results consumer", 0, results.Consume)
+
+	// The dispatchers of all replicas share a consumer
+	// group, so that each result is delivered once.
+	dispatchReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     cfg.Kafka.Brokers,
+		Topic:       cfg.Kafka.Topics.Results,
+		GroupID:     cfg.Webhooks.ConsumerGroup,
+		MaxBytes:    10e6,
+		StartOffset: kafka.LastOffset,
+	})
+	lc.OnShutdown("kafka webhook reader", 0, func(context.Context) error { return dispatchReader.Close() })
+	deliveryWriter := &kafka.Writer{
+		Addr:                   kafka.TCP(cfg.Kafka.Brokers...),
+		Topic:                  cfg.Kafka.Topics.Deliveries,
+		Balancer:               &kafka.LeastBytes{},
+		AllowAutoTopicCreation: true,
+	}
+	lc.OnShutdown("kafka delivery writer", 0, func(context.Context) error { return deliveryWriter.Close() })
+	// Callback secrets are shared by the replicas through
+	// Redis, as the replica delivering a result is not the
+	// one that received the job.
+	var secrets webhook.SecretStore
+	if cfg.Redis.Address != "" {
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:         cfg.Redis.Address,
+			Password:     cfg.Redis.Password,
+			DB:           cfg.Redis.DB,
+			DialTimeout:  cfg.Redis.Timeout,
+			ReadTimeout:  cfg.Redis.Timeout,
+			WriteTimeout: cfg.Redis.Timeout,
+		})
+		lc.OnShutdown("redis client", 0, func(context.Context) error { return redisClient.Close() })
+		secrets = webhook.NewRedisSecretStore(redisClient, cfg.Redis.Timeout)
+	} else {
+		logger.Warn("No Redis configured, webhooks are only signed by the replica that received the job")
+		secrets = webhook.NewMemorySecretStore()
+	}
+	dispatcher := webhook.NewDispatcher(cfg.Webhooks, dispatchReader, deliveryWriter, secrets)
+	lc.Go("webhook dispatcher", 0, dispatcher.Run)
+	prometheus.MustRegister(metrics.NewKafkaReaderCollector(cfg.Webhooks.ConsumerGroup, dispatchReader))
+
+	deliveryReader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   cfg.Kafka.Brokers,
+		Topic:     cfg.Kafka.Topics.Deliveries,
+		Partition: 0,
+		MaxBytes:  10e6,
+	})
+	lc.OnShutdown("kafka delivery reader", 0, func(context.Context) error { return deliveryReader.Close() })
+	deliveries := webhook.NewLog(deliveryReader)
+	lc.Go("kafka delivery consumer", 0, deliveries.Consume)
+
+	cancelWriter := &kafka.Writer{
+		Addr:                   kafka.TCP(cfg.Kafka.Brokers...),
+		Topic:                  cfg.Kafka.Topics.Cancellations,
+		Balancer:               &kafka.LeastBytes{},
+		AllowAutoTopicCreation: true,
+	}
+	lc.OnShutdown("kafka cancellation writer", 0, func(context.Context) error { return cancelWriter.Close() })
 
-	metadataGateway := metadatagateway.New(registry)
-	compressionGateway := compressiongateway.New(registry)
-	ctrl := video.New(compressionGateway, metadataGateway)
+	ctrl := video.New(compressionGateway, metadataGateway, results, dispatcher, deliveries, cancelWriter)
+
+	grpcAddr := fmt.Sprintf("%s:%d", cfg.API.Host, port)
+	metricsSrv := metrics.NewServer(fmt.Sprintf(":%d", cfg.Prometheus.MetricsPort))
+	lc.Serve("metrics server", metricsSrv.ListenAndServe, lifecycle.HTTPStopper(metricsSrv))
 
-	grpcAddr := fmt.Sprintf("0.0.0.0:%d", port)
 	lis, err := net.Listen("tcp", grpcAddr)
 	if err != nil {
 		log.Fatal("Failed to listen", zap.Error(err))
 	}
-	grpcServer := grpc.NewServer(grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	grpcServer := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor(), user.UnaryServerInterceptor()),
+	)
 	reflection.Register(grpcServer)
 	gen.RegisterVideoServiceServer(grpcServer, grpchandler.New(ctrl))
-	if err := grpcServer.Serve(lis); err != nil {
-		panic(err)
+	healthSrv := grpcutil.NewHealthServer(grpcServer)
+	lc.Serve("grpc server", func() error { return grpcServer.Serve(lis) }, lifecycle.GRPCStopper(grpcServer))
+	lc.OnShutdown("grpc health", 0, func(context.Context) error {
+		healthSrv.Shutdown()
+		return nil
+	})
+
+	if err := lc.Wait(); err != nil {
+		logger.Fatal("Video service stopped", zap.Error(err))
 	}
+	logger.Info("Video service stopped")
 }
@@ -2,20 +2,44 @@ package video
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	conversionmodel "ffmpeg/wrapper/compression/pkg/model"
 	"ffmpeg/wrapper/gen"
+	"ffmpeg/wrapper/internal/user"
 	metadatamodel "ffmpeg/wrapper/metadata/pkg/model"
+	"ffmpeg/wrapper/pkg/discovery/tracing"
 	"ffmpeg/wrapper/video/internal/gateway"
+	"ffmpeg/wrapper/video/internal/webhook"
 	"ffmpeg/wrapper/video/pkg/model"
+	"fmt"
 	"log"
 	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
 )
 
 // ErrNotFound is returned when the video metadata is not
 // found.
 var ErrNotFound = errors.New("movie metadata not found")
 
+// ErrNotSucceeded is returned for the download of a job
+// that has not succeeded.
+var ErrNotSucceeded = errors.New("job has not succeeded")
+
+// ErrFinished is returned for the cancellation of a job
+// that has finished.
+var ErrFinished = errors.New("job has finished")
+
+// ErrInvalidCallback is returned for jobs with an invalid
+// callback.
+var ErrInvalidCallback = errors.New("invalid callback")
+
 type compressionGateway interface {
 	GetCompressedVideo(ctx context.Context, duration conversionmodel.Duration, videoLink conversionmodel.VideoLink) (string, error)
 }
@@ -23,17 +47,24 @@ type metadataGateway interface {
 	Get(ctx context.Context, path string) (*metadatamodel.Metadata, error)
 	GetPresignedURL(ctx context.Context, r *gen.GetUploadURLRequest) (*gen.GetUploadURLResponse, error)
 	GetCompressionJob(ctx context.Context, r *gen.GetCompressionJobRequest) (*gen.GetCompressionJobResponse, error)
+	GetDownloadURL(ctx context.Context, r *gen.GetDownloadURLRequest) (*gen.GetDownloadURLResponse, error)
 }
 
 // Controller defines a video service controller.
 type Controller struct {
 	compressionGateway compressionGateway
 	metadataGateway    metadataGateway
+	results            *Results
+	dispatcher         *webhook.Dispatcher
+	deliveries         *webhook.Log
+	// cancellations publishes job cancellations to the
+	// compression workers.
+	cancellations *kafka.Writer
 }
 
 // New creates a new videservice controller.
-func New(compressionGateway compressionGateway, metadataGateway metadataGateway) *Controller {
-	return &Controller{compressionGateway, metadataGateway}
+func New(compressionGateway compressionGateway, metadataGateway metadataGateway, results *Results, dispatcher *webhook.Dispatcher, deliveries *webhook.Log, cancellations *kafka.Writer) *Controller {
+	return &Controller{compressionGateway, metadataGateway, results, dispatcher, deliveries, cancellations}
 
 }
 
@@ -71,6 +102,90 @@ func (c *Controller) GetUploadURL(ctx context.Context, req *gen.GetUploadURLRequ
 	return c.metadataGateway.GetPresignedURL(ctx, req)
 }
 
+// GetCompressionJob enqueues the compression of an
+// uploaded file. The job is reported as processing.
 func (c *Controller) GetCompressionJob(ctx context.Context, req *gen.GetCompressionJobRequest) (*gen.GetCompressionJobResponse, error) {
-	return c.metadataGateway.GetCompressionJob(ctx, req)
+	if req.CallbackUrl != "" || req.CallbackSecret != "" {
+		if err := c.dispatcher.Validate(req.CallbackUrl, req.CallbackSecret); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCallback, err)
+		}
+		// The secret stays in the video service, only the
+		// URL is sent with the job.
+		if err := c.dispatcher.SaveSecret(ctx, req.JobId, req.CallbackSecret); err != nil {
+			return nil, err
+		}
+		req = proto.CloneOf(req)
+		req.CallbackSecret = ""
+	}
+	resp, err := c.metadataGateway.GetCompressionJob(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Status = model.JobStatusProcessing
+	return resp, nil
+}
+
+// GetJobStatus returns the status of a compression job.
+func (c *Controller) GetJobStatus(ctx context.Context, jobID int64) (*gen.GetJobStatusResponse, error) {
+	return c.results.Status(ctx, jobID)
+}
+
+// GetDownloadURL presigns a new download URL of the output
+// of a succeeded job, as long as the output is retained.
+func (c *Controller) GetDownloadURL(ctx context.Context, req *gen.GetDownloadURLRequest) (*gen.GetDownloadURLResponse, error) {
+	event, ok := c.results.Get(req.JobId)
+	if !ok || event.CompressionEventType != conversionmodel.CompressionEventTypeSuccess {
+		return nil, ErrNotSucceeded
+	}
+	return c.metadataGateway.GetDownloadURL(ctx, &gen.GetDownloadURLRequest{
+		JobId:           req.JobId,
+		LifetimeSeconds: req.LifetimeSeconds,
+		ObjectKey:       event.CompressedKey,
+		SourceKey:       event.ObjectKey,
+	})
+}
+
+// GetWebhookDeliveries returns the attempts to notify the
+// callback of a job, oldest first.
+func (c *Controller) GetWebhookDeliveries(jobID int64) []webhook.Delivery {
+	return c.deliveries.Deliveries(jobID)
+}
+
+// CancelJob asks the compression workers to stop a job
+// that has not finished. Cancelling a cancelled job again
+// does nothing.
+func (c *Controller) CancelJob(ctx context.Context, jobID int64) (*gen.CancelJobResponse, error) {
+	if event, ok := c.results.Get(jobID); ok {
+		if event.CompressionEventType == conversionmodel.CompressionEventTypeCancelled {
+			return &gen.CancelJobResponse{JobId: jobID, Status: model.JobStatusCancelled}, nil
+		}
+		return nil, ErrFinished
+	}
+	payload, err := json.Marshal(conversionmodel.CancellationEvent{
+		JobID:       jobID,
+		UserID:      user.ID(ctx),
+		RequestedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cancellation: %w", err)
+	}
+	ctx, span := otel.Tracer(tracerID).Start(ctx, "Kafka/PublishCancellation",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.Int64("job.id", jobID),
+			attribute.String("messaging.destination.name", c.cancellations.Topic),
+		),
+	)
+	defer span.End()
+	msg := kafka.Message{
+		Key:   []byte(strconv.FormatInt(jobID, 10)),
+		Value: payload,
+	}
+	tracing.InjectKafka(ctx, &msg)
+	if err := c.cancellations.WriteMessages(ctx, msg); err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("failed to publish cancellation: %w", err)
+	}
+	log.Printf("Cancellation of job %d published", jobID)
+	return &gen.CancelJobResponse{JobId: jobID, Status: model.JobStatusProcessing}, nil
 }
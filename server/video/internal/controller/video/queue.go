@@ -0,0 +1,143 @@
+package video
+
+import (
+	"context"
+	"encoding/json"
+	conversionmodel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/internal/scheduling"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Queue tracks the jobs waiting for a compression worker,
+// read from the job topics of all priorities, in the fair
+// order the workers start them in. Each worker only orders
+// the jobs it fetched, so positions are estimates.
+type Queue struct {
+	readers []*kafka.Reader
+
+	mu     sync.Mutex
+	queue  *scheduling.Queue[struct{}]
+	queued map[int64]time.Time
+	// started holds the jobs that started or finished, as
+	// a job may be read after its result.
+	started   map[int64]time.Time
+	lastPrune time.Time
+}
+
+// NewQueue creates a queue fed by the readers of the job
+// topics.
+func NewQueue(readers []*kafka.Reader, weights config.PriorityWeights) *Queue {
+	return &Queue{
+		readers:   readers,
+		queue:     scheduling.NewQueue[struct{}](weights),
+		queued:    map[int64]time.Time{},
+		started:   map[int64]time.Time{},
+		lastPrune: time.Now(),
+	}
+}
+
+// Consume reads jobs until ctx is done.
+func (q *Queue) Consume(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, reader := range q.readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.consume(ctx, reader)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (q *Queue) consume(ctx context.Context, reader *kafka.Reader) {
+	for {
+		m, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("kafka reading error: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		var event struct {
+			EventType string `json:"event_type"`
+			JobID     int64  `json:"job_id"`
+			UserID    string `json:"user_id"`
+			Priority  string `json:"priority"`
+		}
+		if err := json.Unmarshal(m.Value, &event); err != nil || event.EventType != "" || event.JobID == 0 {
+			continue // skip results and malformed messages
+		}
+		priority, _ := conversionmodel.ParsePriority(event.Priority)
+		q.push(event.JobID, priority, event.UserID, m.Time)
+	}
+}
+
+// push queues a job published at the given time. Jobs read
+// again at startup are skipped once they are too old.
+func (q *Queue) push(jobID int64, p conversionmodel.Priority, userID string, published time.Time) {
+	now := time.Now()
+	if now.Sub(published) > resultRetention {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.started[jobID]; ok {
+		return
+	}
+	if q.queue.Push(jobID, p, userID, struct{}{}) {
+		q.queued[jobID] = published
+	}
+	q.prune(now)
+}
+
+// Start removes the job from the queue, once it started or
+// finished.
+func (q *Queue) Start(jobID int64) {
+	now := time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queue.Remove(jobID)
+	delete(q.queued, jobID)
+	q.started[jobID] = now
+	q.prune(now)
+}
+
+// prune forgets the jobs queued or started longer than
+// resultRetention ago. q.mu must be held.
+func (q *Queue) prune(now time.Time) {
+	if now.Sub(q.lastPrune) < time.Hour {
+		return
+	}
+	q.lastPrune = now
+	for jobID, t := range q.queued {
+		if now.Sub(t) > resultRetention {
+			q.queue.Remove(jobID)
+			delete(q.queued, jobID)
+		}
+	}
+	for jobID, t := range q.started {
+		if now.Sub(t) > resultRetention {
+			delete(q.started, jobID)
+		}
+	}
+}
+
+// Position returns the estimated number of queued jobs
+// that start before the job, and false if it is not queued.
+func (q *Queue) Position(jobID int64) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queue.Position(jobID)
+}
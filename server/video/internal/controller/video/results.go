@@ -0,0 +1,154 @@
+package video
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	conversionmodel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/gen"
+	"ffmpeg/wrapper/pkg/discovery/tracing"
+	"ffmpeg/wrapper/video/pkg/model"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerID = "video-controller"
+
+// resultRetention is how long a job result is kept after
+// it is received.
+const resultRetention = 24 * time.Hour
+
+// Results keeps the compression results read from the
+// results topic, so that job status requests are answered
+// without scanning the topic.
+type Results struct {
+	reader      *kafka.Reader
+	queue       *Queue
+	pollTimeout time.Duration
+
+	mu      sync.Mutex
+	results map[int64]result
+	// changed is closed and replaced whenever a result is
+	// added, to wake up waiting status requests.
+	changed chan struct{}
+}
+
+type result struct {
+	event    conversionmodel.CompressionResultEvent
+	received time.Time
+}
+
+// NewResults creates a result store fed by reader. Status
+// requests wait up to pollTimeout for a result. The jobs
+// that start or finish are removed from queue.
+func NewResults(reader *kafka.Reader, queue *Queue, pollTimeout time.Duration) *Results {
+	return &Results{
+		reader:      reader,
+		queue:       queue,
+		pollTimeout: pollTimeout,
+		results:     map[int64]result{},
+		changed:     make(chan struct{}),
+	}
+}
+
+// Consume reads results until ctx is done.
+func (r *Results) Consume(ctx context.Context) error {
+	lastPrune := time.Now()
+	for {
+		m, err := r.reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("kafka reading error: %v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		var event conversionmodel.CompressionResultEvent
+		if err := json.Unmarshal(m.Value, &event); err != nil || event.CompressionEventType == "" {
+			continue // skip jobs and malformed messages
+		}
+		_, span := otel.Tracer(tracerID).Start(tracing.ExtractKafka(context.Background(), &m), "Kafka/CompressionResultReceived",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.Int64("job.id", event.JobID),
+				attribute.String("compression.result", string(event.CompressionEventType)),
+			),
+		)
+		span.End()
+
+		r.queue.Start(event.JobID)
+		if event.CompressionEventType == conversionmodel.CompressionEventTypeProcessing {
+			continue
+		}
+		now := time.Now()
+		r.mu.Lock()
+		r.results[event.JobID] = result{event: event, received: now}
+		close(r.changed)
+		r.changed = make(chan struct{})
+		if now.Sub(lastPrune) > time.Hour {
+			for jobID, res := range r.results {
+				if now.Sub(res.received) > resultRetention {
+					delete(r.results, jobID)
+				}
+			}
+			lastPrune = now
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Get returns the result of the job, if it was received.
+func (r *Results) Get(jobID int64) (conversionmodel.CompressionResultEvent, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, ok := r.results[jobID]
+	return res.event, ok
+}
+
+// Status waits up to the poll timeout for the result of
+// the job, then reports it as processing.
+func (r *Results) Status(ctx context.Context, jobID int64) (*gen.GetJobStatusResponse, error) {
+	timer := time.NewTimer(r.pollTimeout)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		res, ok := r.results[jobID]
+		changed := r.changed
+		r.mu.Unlock()
+		if ok {
+			return model.JobStatusToProto(res.event), nil
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return r.processing(jobID), nil
+			}
+			return nil, ctx.Err()
+		case <-timer.C:
+			return r.processing(jobID), nil
+		case <-changed:
+		}
+	}
+}
+
+// processing reports a job without result, with its queue
+// position while it waits for a worker.
+func (r *Results) processing(jobID int64) *gen.GetJobStatusResponse {
+	resp := &gen.GetJobStatusResponse{JobId: jobID, Status: model.JobStatusProcessing}
+	if position, ok := r.queue.Position(jobID); ok {
+		resp.Queued = true
+		resp.QueuePosition = int32(position)
+	}
+	return resp
+}
@@ -3,31 +3,23 @@ package grpc
 import (
 	"context"
 	"ffmpeg/wrapper/gen"
-	"ffmpeg/wrapper/internal/grpcutil"
 	"ffmpeg/wrapper/metadata/pkg/model"
-	"ffmpeg/wrapper/pkg/discovery"
 )
 
 // Gateway defines a movie metadata gRPC gateway.
 type Gateway struct {
-	registry discovery.Registry
+	client gen.MetadataServiceClient
 }
 
 // New creates a new gRPC gateway for a video metadata
 // service.
-func New(registry discovery.Registry) *Gateway {
-	return &Gateway{registry}
+func New(client gen.MetadataServiceClient) *Gateway {
+	return &Gateway{client}
 }
 
 // Get returns video metadata by a movie path.
 func (g *Gateway) Get(ctx context.Context, path string) (*model.Metadata, error) {
-	conn, err := grpcutil.ServiceConnection(ctx, "metadata", g.registry)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-	client := gen.NewMetadataServiceClient(conn)
-	resp, err := client.GetMetadata(ctx, &gen.GetMetadataRequest{Path: path})
+	resp, err := g.client.GetMetadata(ctx, &gen.GetMetadataRequest{Path: path})
 	if err != nil {
 		return nil, err
 	}
@@ -36,13 +28,7 @@ func (g *Gateway) Get(ctx context.Context, path string) (*model.Metadata, error)
 }
 
 func (g *Gateway) GetPresignedURL(ctx context.Context, req *gen.GetUploadURLRequest) (*gen.GetUploadURLResponse, error) {
-	conn, err := grpcutil.ServiceConnection(ctx, "metadata", g.registry)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-	client := gen.NewMetadataServiceClient(conn)
-	resp, err := client.GetUploadURL(ctx, &gen.GetUploadURLRequest{Filename: req.Filename})
+	resp, err := g.client.GetUploadURL(ctx, &gen.GetUploadURLRequest{Filename: req.Filename, Size: req.Size})
 	if err != nil {
 		return nil, err
 	}
@@ -50,15 +36,24 @@ func (g *Gateway) GetPresignedURL(ctx context.Context, req *gen.GetUploadURLRequ
 }
 
 func (g *Gateway) GetCompressionJob(ctx context.Context, req *gen.GetCompressionJobRequest) (*gen.GetCompressionJobResponse, error) {
-	conn, err := grpcutil.ServiceConnection(ctx, "metadata", g.registry)
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-	client := gen.NewMetadataServiceClient(conn)
-	resp, err := client.GetCompressionJob(ctx, &gen.GetCompressionJobRequest{JobId: req.JobId, ObjectKey: req.ObjectKey})
+	resp, err := g.client.GetCompressionJob(ctx, &gen.GetCompressionJobRequest{
+		JobId:          req.JobId,
+		ObjectKey:      req.ObjectKey,
+		CallbackUrl:    req.CallbackUrl,
+		CallbackSecret: req.CallbackSecret,
+		Priority:       req.Priority,
+		OutputFormat:   req.OutputFormat,
+	})
 	if err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
+
+func (g *Gateway) GetDownloadURL(ctx context.Context, req *gen.GetDownloadURLRequest) (*gen.GetDownloadURLResponse, error) {
+	return g.client.GetDownloadURL(ctx, &gen.GetDownloadURLRequest{
+		LifetimeSeconds: req.LifetimeSeconds,
+		ObjectKey:       req.ObjectKey,
+		SourceKey:       req.SourceKey,
+	})
+}
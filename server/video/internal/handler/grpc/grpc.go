@@ -6,6 +6,7 @@ import (
 	"ffmpeg/wrapper/gen"
 	"ffmpeg/wrapper/metadata/pkg/model"
 	"ffmpeg/wrapper/video/internal/controller/video"
+	"ffmpeg/wrapper/video/internal/webhook"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -42,6 +43,53 @@ func (h *Handler) GetUploadURL(ctx context.Context, req *gen.GetUploadURLRequest
 	return h.svc.GetUploadURL(ctx, req)
 }
 
+func (h *Handler) GetJobStatus(ctx context.Context, req *gen.GetJobStatusRequest) (*gen.GetJobStatusResponse, error) {
+	if req == nil || req.JobId == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "nil req or empty job id")
+	}
+	return h.svc.GetJobStatus(ctx, req.JobId)
+}
+
 func (h *Handler) GetCompressionJob(ctx context.Context, req *gen.GetCompressionJobRequest) (*gen.GetCompressionJobResponse, error) {
-	return h.svc.GetCompressionJob(ctx, req)
+	resp, err := h.svc.GetCompressionJob(ctx, req)
+	if err != nil && errors.Is(err, video.ErrInvalidCallback) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s", err.Error())
+	}
+	return resp, err
+}
+
+func (h *Handler) GetWebhookDeliveries(ctx context.Context, req *gen.GetWebhookDeliveriesRequest) (*gen.GetWebhookDeliveriesResponse, error) {
+	if req == nil || req.JobId == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "nil req or empty job id")
+	}
+	resp := &gen.GetWebhookDeliveriesResponse{}
+	for _, d := range h.svc.GetWebhookDeliveries(req.JobId) {
+		resp.Deliveries = append(resp.Deliveries, webhook.DeliveryToProto(d))
+	}
+	return resp, nil
+}
+
+func (h *Handler) GetDownloadURL(ctx context.Context, req *gen.GetDownloadURLRequest) (*gen.GetDownloadURLResponse, error) {
+	if req == nil || req.JobId == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "nil req or empty job id")
+	}
+	if req.LifetimeSeconds < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "negative lifetime")
+	}
+	resp, err := h.svc.GetDownloadURL(ctx, req)
+	if err != nil && errors.Is(err, video.ErrNotSucceeded) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s", err.Error())
+	}
+	return resp, err
+}
+
+func (h *Handler) CancelJob(ctx context.Context, req *gen.CancelJobRequest) (*gen.CancelJobResponse, error) {
+	if req == nil || req.JobId == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "nil req or empty job id")
+	}
+	resp, err := h.svc.CancelJob(ctx, req.JobId)
+	if err != nil && errors.Is(err, video.ErrFinished) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s", err.Error())
+	}
+	return resp, err
 }
@@ -0,0 +1,402 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/internal/config"
+	"ffmpeg/wrapper/pkg/discovery/tracing"
+	"ffmpeg/wrapper/video/pkg/model"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const tracerID = "video-webhook"
+
+// maxConcurrentDeliveries bounds the deliveries in flight,
+// including the ones waiting for a retry.
+const maxConcurrentDeliveries = 256
+
+// minSecretLength is the minimum length of callback
+// secrets.
+const minSecretLength = 16
+
+// ErrPrivateAddress is returned for callbacks to loopback
+// and private addresses, unless they are allowed.
+var ErrPrivateAddress = errors.New("callback address is not public")
+
+// Dispatcher POSTs the results of jobs to their callback
+// URLs, retrying failed attempts with exponential backoff.
+// Every attempt is published to the delivery log.
+//
+// The offset of a result is committed once its delivery
+// succeeded or was abandoned, so that deliveries stopped by
+// a shutdown are made again by the next dispatcher.
+type Dispatcher struct {
+	cfg    config.Webhooks
+	reader *kafka.Reader
+	writer *kafka.Writer
+	client *http.Client
+	sem    chan struct{}
+	// secrets holds the callback secrets, kept out of
+	// Kafka.
+	secrets SecretStore
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	offsets map[partition]*offsets
+}
+
+type partition struct {
+	topic     string
+	partition int
+}
+
+// offsets are the offsets fetched from a partition and not
+// committed yet, and how many times each was handled.
+type offsets struct {
+	fetched []int64
+	done    map[int64]int
+}
+
+// NewDispatcher creates a dispatcher delivering the results
+// read by reader, signed with the secrets of secrets, and
+// publishing its attempts with writer.
+func NewDispatcher(cfg config.Webhooks, reader *kafka.Reader, writer *kafka.Writer, secrets SecretStore) *Dispatcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		// Checking the address dialed, rather than the URL,
+		// also covers host names resolving to private
+		// addresses.
+		dialer.Control = func(_ string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		}
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: cfg.Timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// Redirects could lead to private addresses, and
+		// count as failed attempts.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Dispatcher{
+		cfg:     cfg,
+		reader:  reader,
+		writer:  writer,
+		client:  client,
+		sem:     make(chan struct{}, maxConcurrentDeliveries),
+		secrets: secrets,
+		offsets: map[partition]*offsets{},
+	}
+}
+
+// Validate checks the callback of a new job. Callbacks must
+// be HTTPS URLs of public hosts, unless private networks
+// are allowed.
+func (d *Dispatcher) Validate(callbackURL string, secret string) error {
+	if callbackURL == "" {
+		return errors.New("callback_url must be set with callback_secret")
+	}
+	if len(secret) < minSecretLength {
+		return fmt.Errorf("callback_secret must be at least %d characters", minSecretLength)
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback_url: %w", err)
+	}
+	if u.Host == "" || u.User != nil {
+		return errors.New("callback_url must be an absolute URL without credentials")
+	}
+	if d.cfg.AllowPrivateNetworks {
+		if u.Scheme != "https" && u.Scheme != "http" {
+			return errors.New("callback_url must be an HTTP or HTTPS URL")
+		}
+		return nil
+	}
+	if u.Scheme != "https" {
+		return errors.New("callback_url must be an HTTPS URL")
+	}
+	if u.Hostname() == "localhost" {
+		return ErrPrivateAddress
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !isPublic(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// SaveSecret stores the callback secret of a new job for
+// webhooks.secretTTL, as only its callback URL is sent
+// with the job.
+func (d *Dispatcher) SaveSecret(ctx context.Context, jobID int64, secret string) error {
+	return d.secrets.Put(ctx, jobID, secret, d.cfg.SecretTTL)
+}
+
+// Run delivers the results read until ctx is done, then
+// waits for the deliveries in flight, which stop retrying
+// and are left uncommitted.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	defer d.wg.Wait()
+	for {
+		m, err := d.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("kafka reading error: %v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		d.track(m)
+		var event compressionmodel.CompressionResultEvent
+		if err := json.Unmarshal(m.Value, &event); err != nil || event.CompressionEventType == "" || event.Callback == nil ||
+			event.CompressionEventType == compressionmodel.CompressionEventTypeProcessing {
+			// Skip jobs, job starts, malformed messages and
+			// results without callback.
+			d.done(context.WithoutCancel(ctx), m)
+			continue
+		}
+		select {
+		case d.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			defer func() { <-d.sem }()
+			if d.deliver(ctx, m, event) {
+				d.done(context.WithoutCancel(ctx), m)
+			}
+		}()
+	}
+}
+
+func (d *Dispatcher) track(m kafka.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := partition{topic: m.Topic, partition: m.Partition}
+	o, ok := d.offsets[key]
+	if !ok {
+		o = &offsets{done: map[int64]int{}}
+		d.offsets[key] = o
+	}
+	o.fetched = append(o.fetched, m.Offset)
+}
+
+// done marks the result handled, and commits the offsets
+// of its partition up to the first result still being
+// delivered. Commits are made holding d.mu, so that they
+// do not overtake another.
+func (d *Dispatcher) done(ctx context.Context, m kafka.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	o := d.offsets[partition{topic: m.Topic, partition: m.Partition}]
+	o.done[m.Offset]++
+	commit := int64(-1)
+	for len(o.fetched) > 0 && o.done[o.fetched[0]] > 0 {
+		commit = o.fetched[0]
+		if o.done[commit]--; o.done[commit] == 0 {
+			delete(o.done, commit)
+		}
+		o.fetched = o.fetched[1:]
+	}
+	if commit < 0 {
+		return
+	}
+	c := kafka.Message{Topic: m.Topic, Partition: m.Partition, Offset: commit}
+	if err := d.reader.CommitMessages(ctx, c); err != nil {
+		log.Printf("failed to commit offset %d of %s/%d: %v", commit, c.Topic, c.Partition, err)
+	}
+}
+
+// payload is the body POSTed to callback URLs.
+type payload struct {
+	// ID is the ID of the delivery, the same for all its
+	// attempts.
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	// Job is the job as returned by GET /v1/jobs/{job_id}.
+	Job json.RawMessage `json:"job"`
+}
+
+// deliver POSTs the result until it succeeds or the last
+// attempt fails. It returns false if ctx is done first.
+func (d *Dispatcher) deliver(ctx context.Context, m kafka.Message, event compressionmodel.CompressionResultEvent) bool {
+	ctx, span := otel.Tracer(tracerID).Start(tracing.ExtractKafka(ctx, &m), "Webhook/Deliver",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.Int64("job.id", event.JobID)),
+	)
+	defer span.End()
+
+	id := deliveryID(m)
+	status := model.JobStatusToProto(event)
+	job, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(status)
+	if err != nil {
+		log.Printf("failed to marshal job %d: %v", event.JobID, err)
+		return true
+	}
+	body, err := json.Marshal(payload{ID: id, Type: "job." + status.Status, CreatedAt: time.Now().UTC(), Job: job})
+	if err != nil {
+		log.Printf("failed to marshal webhook payload of job %d: %v", event.JobID, err)
+		return true
+	}
+
+	backoff := d.cfg.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		delivery := d.attempt(ctx, id, attempt, event, body)
+		last := delivery.Succeeded || attempt >= d.cfg.MaxAttempts
+		if !last {
+			next := time.Now().Add(backoff).UTC()
+			delivery.NextAttemptAt = &next
+		}
+		d.record(ctx, delivery)
+		switch {
+		case delivery.Succeeded:
+			return true
+		case last:
+			deliveriesAbandoned.Inc()
+			span.SetStatus(codes.Error, "webhook delivery abandoned")
+			log.Printf("giving up delivering job %d to %s after %d attempts: %s", event.JobID, delivery.URL, attempt, delivery.Error)
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, d.cfg.MaxBackoff)
+	}
+}
+
+// attempt POSTs body to the callback URL once. An attempt
+// whose secret cannot be read fails.
+func (d *Dispatcher) attempt(ctx context.Context, id string, attempt int, event compressionmodel.CompressionResultEvent, body []byte) Delivery {
+	delivery := Delivery{
+		ID:          id,
+		JobID:       event.JobID,
+		Attempt:     attempt,
+		URL:         event.Callback.URL,
+		AttemptedAt: time.Now().UTC(),
+	}
+	secret, err := d.secrets.Get(ctx, event.JobID)
+	if err != nil {
+		delivery.Error = err.Error()
+		attempts.WithLabelValues("failed").Inc()
+		return delivery
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, event.Callback.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		attempts.WithLabelValues("failed").Inc()
+		return delivery
+	}
+	timestamp := strconv.FormatInt(delivery.AttemptedAt.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "discord-filecompressor-webhooks")
+	req.Header.Set("X-Webhook-ID", id)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		attempts.WithLabelValues("failed").Inc()
+		return delivery
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	delivery.Succeeded = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Succeeded {
+		delivery.Error = "unexpected response status " + resp.Status
+		attempts.WithLabelValues("failed").Inc()
+		return delivery
+	}
+	attempts.WithLabelValues("succeeded").Inc()
+	return delivery
+}
+
+// record publishes the attempt to the delivery log.
+func (d *Dispatcher) record(ctx context.Context, delivery Delivery) {
+	value, err := json.Marshal(delivery)
+	if err != nil {
+		log.Printf("failed to marshal webhook delivery: %v", err)
+		return
+	}
+	msg := kafka.Message{
+		Key:   []byte(strconv.FormatInt(delivery.JobID, 10)),
+		Value: value,
+	}
+	tracing.InjectKafka(ctx, &msg)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := d.writer.WriteMessages(ctx, msg); err != nil {
+		log.Printf("failed to publish webhook delivery of job %d: %v", delivery.JobID, err)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of timestamp, a dot and
+// body, keyed with secret. Receivers compare it to the
+// X-Webhook-Signature header, and reject old timestamps.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func isPublic(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// deliveryID derives the ID of a delivery from the result
+// message, so that a delivery made again after a restart
+// keeps its ID.
+func deliveryID(m kafka.Message) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%d", m.Topic, m.Partition, m.Offset)))
+	return hex.EncodeToString(sum[:16])
+}
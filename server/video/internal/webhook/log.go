@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"ffmpeg/wrapper/gen"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// logRetention is how long the deliveries of a job are
+// kept after its last attempt.
+const logRetention = 7 * 24 * time.Hour
+
+// Delivery is an attempt to POST the result of a job to its
+// callback URL, as published to the deliveries topic.
+type Delivery struct {
+	ID          string    `json:"id"`
+	JobID       int64     `json:"job_id"`
+	Attempt     int       `json:"attempt"`
+	URL         string    `json:"url"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Succeeded   bool      `json:"succeeded"`
+	// NextAttemptAt is nil after the last attempt.
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// DeliveryToProto converts a delivery to its API form.
+func DeliveryToProto(d Delivery) *gen.WebhookDelivery {
+	p := &gen.WebhookDelivery{
+		Id:          d.ID,
+		JobId:       d.JobID,
+		Attempt:     int32(d.Attempt),
+		Url:         d.URL,
+		AttemptedAt: timestamppb.New(d.AttemptedAt),
+		StatusCode:  int32(d.StatusCode),
+		Error:       d.Error,
+		Succeeded:   d.Succeeded,
+	}
+	if d.NextAttemptAt != nil {
+		p.NextAttemptAt = timestamppb.New(*d.NextAttemptAt)
+	}
+	return p
+}
+
+// Log keeps the delivery log read from the deliveries
+// topic. Every replica reads the whole topic, so that any
+// of them can list the deliveries of a job.
+type Log struct {
+	reader *kafka.Reader
+
+	mu         sync.Mutex
+	deliveries map[int64][]Delivery
+}
+
+// NewLog creates a delivery log fed by reader.
+func NewLog(reader *kafka.Reader) *Log {
+	return &Log{reader: reader, deliveries: map[int64][]Delivery{}}
+}
+
+// Consume reads deliveries until ctx is done.
+func (l *Log) Consume(ctx context.Context) error {
+	lastPrune := time.Now()
+	for {
+		m, err := l.reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("kafka reading error: %v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		var d Delivery
+		if err := json.Unmarshal(m.Value, &d); err != nil || d.ID == "" {
+			continue // skip malformed messages
+		}
+		now := time.Now()
+		l.mu.Lock()
+		l.deliveries[d.JobID] = append(l.deliveries[d.JobID], d)
+		if now.Sub(lastPrune) > time.Hour {
+			for jobID, ds := range l.deliveries {
+				if now.Sub(ds[len(ds)-1].AttemptedAt) > logRetention {
+					delete(l.deliveries, jobID)
+				}
+			}
+			lastPrune = now
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Deliveries returns the attempts made for the job, oldest
+// first.
+func (l *Log) Deliveries(jobID int64) []Delivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Delivery(nil), l.deliveries[jobID]...)
+}
@@ -0,0 +1,17 @@
+package webhook
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	attempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "video_webhook_attempts_total",
+		Help: "Total number of webhook delivery attempts, by result.",
+	}, []string{"result"})
+	deliveriesAbandoned = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "video_webhook_deliveries_abandoned_total",
+		Help: "Total number of webhook deliveries given up after webhooks.maxAttempts.",
+	})
+)
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNoSecret is returned for jobs whose callback secret
+// is not stored, or expired.
+var ErrNoSecret = errors.New("callback secret not found")
+
+// SecretStore keeps the callback secrets of jobs in the
+// video service, so that they are never published to
+// Kafka.
+type SecretStore interface {
+	// Put stores the secret of the job for ttl.
+	Put(ctx context.Context, jobID int64, secret string, ttl time.Duration) error
+	// Get returns the secret of the job, or ErrNoSecret.
+	Get(ctx context.Context, jobID int64) (string, error)
+}
+
+// RedisSecretStore keeps the secrets in Redis, shared by
+// the video service replicas.
+type RedisSecretStore struct {
+	client  *redis.Client
+	timeout time.Duration
+}
+
+// NewRedisSecretStore creates a store on the given Redis
+// client. Each command is bounded by timeout.
+func NewRedisSecretStore(client *redis.Client, timeout time.Duration) *RedisSecretStore {
+	return &RedisSecretStore{client: client, timeout: timeout}
+}
+
+func (s *RedisSecretStore) Put(ctx context.Context, jobID int64, secret string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.client.Set(ctx, secretKey(jobID), secret, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store the callback secret of job %d: %w", jobID, err)
+	}
+	return nil
+}
+
+func (s *RedisSecretStore) Get(ctx context.Context, jobID int64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	secret, err := s.client.Get(ctx, secretKey(jobID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNoSecret
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read the callback secret of job %d: %w", jobID, err)
+	}
+	return secret, nil
+}
+
+func secretKey(jobID int64) string {
+	return "webhook:secret:" + strconv.FormatInt(jobID, 10)
+}
+
+// MemorySecretStore keeps the secrets in memory, for a
+// single video service replica. They are lost on restart.
+type MemorySecretStore struct {
+	mu      sync.Mutex
+	secrets map[int64]secret
+}
+
+type secret struct {
+	value   string
+	expires time.Time
+}
+
+// NewMemorySecretStore creates an empty in-memory store.
+func NewMemorySecretStore() *MemorySecretStore {
+	return &MemorySecretStore{secrets: map[int64]secret{}}
+}
+
+func (s *MemorySecretStore) Put(_ context.Context, jobID int64, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// Expired secrets are dropped on writes, so that the
+	// map does not grow with jobs that never finished.
+	for id, sec := range s.secrets {
+		if now.After(sec.expires) {
+			delete(s.secrets, id)
+		}
+	}
+	s.secrets[jobID] = secret{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemorySecretStore) Get(_ context.Context, jobID int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sec, ok := s.secrets[jobID]
+	if !ok || time.Now().After(sec.expires) {
+		return "", ErrNoSecret
+	}
+	return sec.value, nil
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	compressionmodel "ffmpeg/wrapper/compression/pkg/model"
+	"ffmpeg/wrapper/gen"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// JobStatusToProto converts a compression result to the
+// status of its job.
+func JobStatusToProto(event compressionmodel.CompressionResultEvent) *gen.GetJobStatusResponse {
+	resp := &gen.GetJobStatusResponse{
+		JobId:         event.JobID,
+		ObjectKey:     event.ObjectKey,
+		CompressedKey: event.CompressedKey,
+		Strategy:      string(event.Strategy),
+		Outcome:       string(event.Outcome),
+		FailureReason: string(event.Reason),
+	}
+	switch event.CompressionEventType {
+	case compressionmodel.CompressionEventTypeSuccess:
+		resp.Status = JobStatusSucceeded
+	case compressionmodel.CompressionEventTypeFail:
+		resp.Status = JobStatusFailed
+	case compressionmodel.CompressionEventTypeCancelled:
+		resp.Status = JobStatusCancelled
+	default:
+		resp.Status = JobStatusProcessing
+	}
+	if !event.Expiry.IsZero() {
+		resp.ExpiresAt = timestamppb.New(event.Expiry)
+	}
+	if p := event.PresignedDownloadUrl; p != nil && resp.Status == JobStatusSucceeded {
+		resp.DownloadUrl = &gen.PresignedRequest{Method: p.Method, Url: p.URL, Headers: p.Headers}
+	}
+	return resp
+}
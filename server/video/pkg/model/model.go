@@ -6,3 +6,11 @@ type ConvertedVideo struct {
 	Link        string         `json:"link"`
 	OldMetadata model.Metadata `json:"metadata"`
 }
+
+// Job statuses reported by GetJobStatus.
+const (
+	JobStatusProcessing = "processing"
+	JobStatusSucceeded  = "succeeded"
+	JobStatusFailed     = "failed"
+	JobStatusCancelled  = "cancelled"
+)